@@ -0,0 +1,260 @@
+package dial
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"we.com/jiabiao/common/jsonpath"
+)
+
+// AssertError describes an assertion on the response that failed
+type AssertError struct {
+	// Assertion is a short description of the assertion, e.g. `code == 200`
+	Assertion string
+	// Actual is what we get from the response
+	Actual string
+}
+
+func (e *AssertError) Error() string {
+	return fmt.Sprintf("assertion failed: %s, actual: %s", e.Assertion, e.Actual)
+}
+
+// assertion checks the response, return nil if passed
+type assertion func(res *Dresponse, body string) *AssertError
+
+// WithResultJSONPath assert on value of the json response selected by a jsonpath expression.
+// expr is of the form `{.path} op value`, where op is one of ==, !=, =~, >, >=, <, <=
+// e.g. `{.status} == "ok"`, `{.data.count} > 0`, `{.version} =~ "^1\."`.
+// If op and value are omitted, the path must exist.
+func WithResultJSONPath(expr string) Option {
+	return func(op *Op) {
+		a, err := newJSONPathAssertion(expr)
+		if err != nil {
+			op.errs = append(op.errs, err)
+			return
+		}
+		op.asserts = append(op.asserts, a)
+	}
+}
+
+// WithResultRegexp assert the response body matches pattern
+func WithResultRegexp(pattern string) Option {
+	return func(op *Op) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			op.errs = append(op.errs, fmt.Errorf("invalid regexp %q: %v", pattern, err))
+			return
+		}
+		op.asserts = append(op.asserts, func(res *Dresponse, body string) *AssertError {
+			if re.MatchString(body) {
+				return nil
+			}
+			return &AssertError{
+				Assertion: fmt.Sprintf("body =~ %q", pattern),
+				Actual:    abbrev(body),
+			}
+		})
+	}
+}
+
+// WithResultHeader assert value of response header name matches pattern,
+// if pattern is empty, the header must be present
+func WithResultHeader(name, pattern string) Option {
+	return func(op *Op) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			op.errs = append(op.errs, fmt.Errorf("invalid regexp for header %s %q: %v", name, pattern, err))
+			return
+		}
+		op.asserts = append(op.asserts, func(res *Dresponse, body string) *AssertError {
+			var values []string
+			ok := false
+			if res.Response != nil {
+				values, ok = res.Response.Header[http.CanonicalHeaderKey(name)]
+			}
+			if ok && re.MatchString(strings.Join(values, ",")) {
+				return nil
+			}
+			return &AssertError{
+				Assertion: fmt.Sprintf("header %s =~ %q", name, pattern),
+				Actual:    strings.Join(values, ","),
+			}
+		})
+	}
+}
+
+// WithMaxLatency assert the total time of the request is less than max
+func WithMaxLatency(max time.Duration) Option {
+	return func(op *Op) {
+		op.asserts = append(op.asserts, func(res *Dresponse, body string) *AssertError {
+			if res.Elapsed.Total <= max {
+				return nil
+			}
+			return &AssertError{
+				Assertion: fmt.Sprintf("latency <= %v", max),
+				Actual:    res.Elapsed.Total.String(),
+			}
+		})
+	}
+}
+
+// WithMaxFirstByte assert the time to receive the first byte of response is less than max
+func WithMaxFirstByte(max time.Duration) Option {
+	return func(op *Op) {
+		op.asserts = append(op.asserts, func(res *Dresponse, body string) *AssertError {
+			if res.Elapsed.FirstByte <= max {
+				return nil
+			}
+			return &AssertError{
+				Assertion: fmt.Sprintf("first byte <= %v", max),
+				Actual:    res.Elapsed.FirstByte.String(),
+			}
+		})
+	}
+}
+
+var jsonpathOps = []string{"==", "!=", "=~", ">=", "<=", ">", "<"}
+
+// splitJSONPathExpr split `{.path} op value` into its parts
+func splitJSONPathExpr(expr string) (path, op, value string, err error) {
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, "{") {
+		return "", "", "", fmt.Errorf("invalid jsonpath assertion %q: must start with {", expr)
+	}
+
+	depth := 0
+	end := -1
+	for i, c := range expr {
+		if c == '{' {
+			depth++
+		} else if c == '}' {
+			depth--
+			if depth == 0 {
+				end = i
+				break
+			}
+		}
+	}
+	if end < 0 {
+		return "", "", "", fmt.Errorf("invalid jsonpath assertion %q: unclosed {", expr)
+	}
+
+	path = expr[:end+1]
+	rest := strings.TrimSpace(expr[end+1:])
+	if rest == "" {
+		return path, "", "", nil
+	}
+
+	for _, o := range jsonpathOps {
+		if strings.HasPrefix(rest, o) {
+			op = o
+			break
+		}
+	}
+	if op == "" {
+		return "", "", "", fmt.Errorf("invalid jsonpath assertion %q: unknown operator", expr)
+	}
+
+	value = strings.TrimSpace(rest[len(op):])
+	if strings.HasPrefix(value, `"`) {
+		if value, err = strconv.Unquote(value); err != nil {
+			return "", "", "", fmt.Errorf("invalid jsonpath assertion %q: %v", expr, err)
+		}
+	}
+
+	return path, op, value, nil
+}
+
+func newJSONPathAssertion(expr string) (assertion, error) {
+	path, op, value, err := splitJSONPathExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	jp := jsonpath.New("assert")
+	if err := jp.Parse(path); err != nil {
+		return nil, fmt.Errorf("invalid jsonpath %q: %v", path, err)
+	}
+
+	var re *regexp.Regexp
+	var num float64
+	switch op {
+	case "=~":
+		if re, err = regexp.Compile(value); err != nil {
+			return nil, fmt.Errorf("invalid regexp in %q: %v", expr, err)
+		}
+	case ">", ">=", "<", "<=":
+		if num, err = strconv.ParseFloat(value, 64); err != nil {
+			return nil, fmt.Errorf("invalid number in %q: %v", expr, err)
+		}
+	}
+
+	return func(res *Dresponse, body string) *AssertError {
+		actual, err := jsonpathValue(jp, body)
+		if err != nil {
+			return &AssertError{Assertion: expr, Actual: err.Error()}
+		}
+
+		var ok bool
+		switch op {
+		case "":
+			ok = true
+		case "==":
+			ok = actual == value
+		case "!=":
+			ok = actual != value
+		case "=~":
+			ok = re.MatchString(actual)
+		default:
+			f, err := strconv.ParseFloat(actual, 64)
+			if err != nil {
+				break
+			}
+			switch op {
+			case ">":
+				ok = f > num
+			case ">=":
+				ok = f >= num
+			case "<":
+				ok = f < num
+			case "<=":
+				ok = f <= num
+			}
+		}
+
+		if ok {
+			return nil
+		}
+		return &AssertError{Assertion: expr, Actual: actual}
+	}, nil
+}
+
+// jsonpathValue execute jp over a json document and return the text of the result
+func jsonpathValue(jp *jsonpath.JSONPath, body string) (string, error) {
+	var data interface{}
+	if err := json.Unmarshal([]byte(body), &data); err != nil {
+		return "", fmt.Errorf("response is not json: %v", err)
+	}
+
+	buf := bytes.Buffer{}
+	if err := jp.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// abbrev shorten s so that it can be shown in an AssertError
+func abbrev(s string) string {
+	const max = 128
+	if len(s) <= max {
+		return s
+	}
+	return s[:max] + "..."
+}
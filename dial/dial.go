@@ -2,17 +2,21 @@ package dial
 
 import (
 	"bytes"
-	"crypto/md5"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
-	"net/http/httptrace"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"golang.org/x/net/context"
+	utilerrors "we.com/jiabiao/common/errors"
+	"we.com/jiabiao/common/exec"
+	utilnet "we.com/jiabiao/common/net"
 )
 
 //Drequest is a dial request messages
@@ -20,18 +24,13 @@ type Drequest struct {
 	Header   map[string]string
 	Body     []byte
 	Method   string //method must upper:GET POST PUT...
-	DailType string //http or exec or other
+	DailType string //http(default), tcp, tls, dns or exec
 	URL      string
 	Timeout  time.Duration
 }
 
-type Elapsed struct {
-	DNStime      time.Duration
-	CreateConn   time.Duration
-	StartRequest time.Duration
-	FirstByteR   time.Duration
-	Totletime    time.Duration
-}
+// Elapsed is the time breakdown of a dial
+type Elapsed = utilnet.Timing
 
 //Dresponse is dialtest result
 type Dresponse struct {
@@ -40,19 +39,35 @@ type Dresponse struct {
 	Elapsed  Elapsed
 	Result   string
 	Response *http.Response
-	Status   bool
+	// TLS connection state for http(s) and tls
+	TLS *tls.ConnectionState
+	// Code is the http status code, or exit status for exec
+	Code   int
+	Status bool
+	// Size is the number of bytes of the response
+	Size int64
+	// Truncated is true if Result only holds the first bytes of the response, see WithMaxBodySize
+	Truncated bool
+	// Failures lists assertions that failed, Status is false if any
+	Failures []*AssertError
 }
 
 type Op struct {
 	client       *http.Client
 	responsetype string
-	responsemin  int64
-	responsemax  int64
-	responsemd5  string
 	includeItem  string
 	excludeItem  string
-	contain      string
 	code         int
+	asserts      []assertion
+	errs         []error
+	expect       *regexp.Regexp
+	tlsConfig    *tls.Config
+	resolver     string
+	dnsType      string
+	executor     exec.Interface
+	maxBodySize  int64
+	// streams are constructors of the checks run while reading the response
+	streams []func() streamCheck
 	//Checkmethod  map[string]string
 	//WithResultContains, WithResultSize, WithResultMd5sum, WithHttpClient
 }
@@ -67,16 +82,20 @@ func WithHttpClinet(client *http.Client) Option {
 	return func(op *Op) { op.client = client }
 }
 
+// WithResultCode assert the code, it replaces the default check, which is
+// code < 400 for http and exit status == 0 for exec
 func WithResultCode(code int) Option {
 	return func(op *Op) {
 		op.code = code
 	}
 }
 
+// WithResultSize assert size of the response is > min and < max, 0 means no limit
 func WithResultSize(min, max int64) Option {
 	return func(op *Op) {
-		op.responsemin = min
-		op.responsemax = max
+		op.streams = append(op.streams, func() streamCheck {
+			return &sizeCheck{min: min, max: max}
+		})
 	}
 }
 
@@ -94,18 +113,26 @@ func WithResultExclude(item string) Option {
 
 func WithResultContains(contian string) Option {
 	return func(op *Op) {
-		op.contain = contian
+		op.streams = append(op.streams, func() streamCheck {
+			return &containsCheck{sub: []byte(contian)}
+		})
 	}
 }
 
 func WithResultMd5sum(md5 string) Option {
-	return func(op *Op) {
-		op.responsemd5 = md5
-	}
+	return withHash("md5", md5)
 }
 
 //Dial a service, with service and interface set in the header,and this type is http
 //method:GET POST PUT DELETE..
+//
+// dreq.DailType selects how to dial:
+//
+//	http (default): send a http request to dreq.URL
+//	tcp:  connect to dreq.URL (host:port), optionally send/expect data
+//	tls:  tls handshake with dreq.URL (host:port), check the certificate
+//	dns:  resolve dreq.URL (a domain name)
+//	exec: run dreq.URL as a local command, dreq.Body as stdin
 func Dial(dreq *Drequest, options ...Option) (res *Dresponse, err error) {
 	res = &Dresponse{}
 
@@ -114,10 +141,47 @@ func Dial(dreq *Drequest, options ...Option) (res *Dresponse, err error) {
 		o(reopt)
 	}
 
+	if err = utilerrors.NewAggregate(reopt.errs); err != nil {
+		return
+	}
+
 	if dreq.Timeout == 0 {
 		dreq.Timeout = 5 * time.Second
 	}
+	if dreq.DailType == "" {
+		dreq.DailType = "http"
+	}
 
+	switch dreq.DailType {
+	case "http":
+		return dialHTTP(dreq, reopt)
+	case "tcp":
+		res, err = dialTCP(dreq, reopt)
+	case "tls":
+		res, err = dialTLS(dreq, reopt)
+	case "dns":
+		res, err = dialDNS(dreq, reopt)
+	case "exec":
+		res, err = dialExec(dreq, reopt)
+	default:
+		return res, fmt.Errorf("unsupported dial type: %s", dreq.DailType)
+	}
+
+	if err != nil {
+		return
+	}
+
+	var streamed []*AssertError
+	res.Size, streamed, err = verifyStream(strings.NewReader(res.Result), ioutil.Discard, reopt)
+	if err != nil {
+		return
+	}
+	res.Failures = append(streamed, statuschk(res, dreq.DailType, res.Result, reopt)...)
+	res.Status = len(res.Failures) == 0
+	return
+}
+
+func dialHTTP(dreq *Drequest, reopt *Op) (res *Dresponse, err error) {
 	if reopt.client != nil {
 		reopt.client.Timeout = dreq.Timeout
 	} else {
@@ -135,88 +199,31 @@ func Dial(dreq *Drequest, options ...Option) (res *Dresponse, err error) {
 	}
 
 	defer res.Response.Body.Close()
+	res.Code = res.Response.StatusCode
+	res.TLS = res.Response.TLS
 
-	/*r := strings.NewReader("some io.Reader stream to be read\n")
-	  	lr := io.LimitReader(resp.Body, 1024*1024)
-
-	  if _, err := io.Copy(os.Stdout, lr); err != nil {
-	      log.Fatal(err)
-	  }*/
-	lr := io.LimitReader(res.Response.Body, 1024*1024*10)
-	var out []byte
-	out, err = ioutil.ReadAll(lr)
-	res.Result = string(out)
-
-	//var result string
-	if len(out) >= 1024*1024*10-1 {
-		//	result = string(out[:1024*1024*10])
-		res.Status = false
-	} else {
-		//result = string(out)
-		res.Status = statuschk(res.Response.StatusCode, dreq.DailType, string(out), reopt)
+	limit := reopt.maxBodySize
+	if limit <= 0 {
+		limit = DefaultMaxBodySize
+	}
+	buf := &limitedBuffer{limit: limit}
+	var streamed []*AssertError
+	res.Size, streamed, err = verifyStream(res.Response.Body, buf, reopt)
+	res.Elapsed.BodyDone()
+	res.Result = buf.String()
+	res.Truncated = buf.truncated
+	if err != nil {
+		return
 	}
+
+	res.Failures = append(streamed, statuschk(res, dreq.DailType, res.Result, reopt)...)
+	res.Status = len(res.Failures) == 0
 	return
 }
 
 // client: 对java服务不需要， 对php可能需要先认证
 func request(client *http.Client, method, url string, header map[string]string, body io.Reader) (*Dresponse, error) {
 	res := &Dresponse{}
-	var tracetime struct {
-		connstart    time.Time
-		connDone     time.Time
-		DNSstart     time.Time
-		DNSdone      time.Time
-		getconn      time.Time
-		gotconn      time.Time
-		gotfbyte     time.Time
-		requeststart time.Time
-	}
-
-	traceCtx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
-		GetConn: func(hostPort string) {
-			fmt.Printf("Prepare to get a connection for %s.\n", hostPort)
-			tracetime.getconn = time.Now()
-		},
-		GotConn: func(info httptrace.GotConnInfo) {
-			fmt.Printf("Got a connection: reused: %v, from the idle pool: %v.\n", info.Reused, info.WasIdle)
-			tracetime.gotconn = time.Now()
-		},
-		ConnectStart: func(network, addr string) {
-			fmt.Printf("Dialing... (%s:%s).\n", network, addr)
-			tracetime.connstart = time.Now()
-		},
-		ConnectDone: func(network, addr string, err error) {
-			if err == nil {
-				fmt.Printf("Dial is done. (%s:%s)\n", network, addr)
-				tracetime.connDone = time.Now()
-				res.Elapsed.CreateConn = time.Since(tracetime.getconn)
-			} else {
-				fmt.Printf("Dial is done with error: %s. (%s:%s)\n", err, network, addr)
-			}
-		},
-		WroteRequest: func(info httptrace.WroteRequestInfo) {
-			if info.Err == nil {
-				tracetime.requeststart = time.Now()
-				res.Elapsed.StartRequest = time.Since(tracetime.getconn)
-			} else {
-				fmt.Println("Wrote a request:", info.Err.Error())
-			}
-		},
-		GotFirstResponseByte: func() {
-			//fmt.Println("Got the first response byte.")
-			tracetime.gotfbyte = time.Now()
-			res.Elapsed.FirstByteR = time.Since(tracetime.getconn)
-		},
-		DNSStart: func(info httptrace.DNSStartInfo) {
-			//fmt.Println("DNS start.")
-			tracetime.DNSstart = time.Now()
-		},
-		DNSDone: func(info httptrace.DNSDoneInfo) {
-			//fmt.Println("DNS done.")
-			tracetime.DNSdone = time.Now()
-			res.Elapsed.DNStime = time.Since(tracetime.DNSstart)
-		},
-	})
 
 	req, err := http.NewRequest(method, url, body)
 	if err != nil {
@@ -229,11 +236,10 @@ func request(client *http.Client, method, url string, header map[string]string,
 		}
 	}
 
-	req = req.WithContext(traceCtx)
-	starttime := time.Now()
+	res.Elapsed = *utilnet.NewTiming()
+	req = req.WithContext(res.Elapsed.WithClientTrace(context.Background()))
 	resp, err := client.Do(req)
-	res.Elapsed.Totletime = time.Since(starttime)
-	//res.Elapsed = requestT
+	res.Elapsed.GotResponse(resp)
 	res.Response = resp
 
 	return res, err
@@ -241,77 +247,64 @@ func request(client *http.Client, method, url string, header map[string]string,
 
 // 具体怎么检测返回的结果，可以在调用的dial的时候以option的形式指定
 // WithResultContains, WithResultSize, WitherResultMd5sum, WithHttpCode等
-func statuschk(code int, dailtype, response string, op *Op) bool {
-	f := true
-	flag := true
+// returns all the assertions failed
+func statuschk(res *Dresponse, dailtype, response string, op *Op) []*AssertError {
+	var failures []*AssertError
+	code := res.Code
+	fail := func(assertion, actual string) {
+		failures = append(failures, &AssertError{Assertion: assertion, Actual: actual})
+	}
 
 	if op.code != 0 {
-		f = chkCode(code, op.code)
-		if f == false {
-			return f
+		if !chkCode(code, op.code) {
+			fail(fmt.Sprintf("code == %d", op.code), strconv.Itoa(code))
 		}
-		flag = false
 	}
 
-	if op.contain != "" {
-		f = chkContains(response, op.contain)
-		if f == false {
-			return f
+	if op.responsetype != "" {
+		contentType := ""
+		if res.Response != nil {
+			contentType = res.Response.Header.Get("Content-Type")
+		}
+		if !chkResponseType(contentType, response, op.responsetype) {
+			fail("type == "+op.responsetype, contentType)
 		}
-		flag = false
 	}
 
 	if op.excludeItem != "" {
-		f = chkInclude(response, op.excludeItem)
-		if f {
-			f = false
-			return f
+		if chkInclude(response, op.excludeItem) {
+			fail(fmt.Sprintf("exclude %q", op.excludeItem), abbrev(response))
 		}
-		flag = false
 	}
 
 	if op.includeItem != "" {
-		f = chkInclude(response, op.includeItem)
-		flag = false
-		if f == false {
-			return f
-		}
-	}
-
-	if op.responsemd5 != "" {
-		f, _ = chkMd5(response, op.responsemd5)
-		flag = false
-		if f == false {
-			return f
+		if !chkInclude(response, op.includeItem) {
+			fail(fmt.Sprintf("include %q", op.includeItem), abbrev(response))
 		}
 	}
 
-	if op.responsemin != 0 {
-		f = chkSize(response, op.responsemin, "ge")
-		if f == false {
-			return f
+	for _, a := range op.asserts {
+		if f := a(res, response); f != nil {
+			failures = append(failures, f)
 		}
-		flag = false
 	}
 
-	if op.responsemax != 0 {
-		f = chkSize(response, op.responsemax, "le")
-		if f == false {
-			return f
-		}
-		flag = false
-	}
-
-	//if flag is true,no checkmethod give use default checkmethod
-	if flag {
-		if dailtype == "http" {
+	// the default check of the code is replaced only by WithResultCode, other
+	// assertions are checked in addition to it
+	if op.code == 0 {
+		switch dailtype {
+		case "http":
 			if code >= 400 {
-				f = false
+				fail("code < 400", strconv.Itoa(code))
+			}
+		case "exec":
+			if code != 0 {
+				fail("exit status == 0", strconv.Itoa(code))
 			}
 		}
 	}
 
-	return f
+	return failures
 }
 
 /*func check(code int, response string, checkmethod map[string]string) bool {
@@ -351,40 +344,14 @@ func statuschk(code int, dailtype, response string, op *Op) bool {
 	return s
 }*/
 
-func chkMd5(response, md5sum string) (bool, error) {
-	//md5
-	d := []byte(response)
-	rmd5 := fmt.Sprintf("%x", md5.Sum(d))
-
-	if md5sum == rmd5 {
-		return true, nil
+// chkResponseType check response is of type rtype,
+// for json, the response must be a valid json document
+// otherwise, the content type must contains rtype
+func chkResponseType(contentType, response, rtype string) bool {
+	if rtype == "json" {
+		return json.Valid([]byte(response))
 	}
-	return false, nil
-}
-
-func chkSize(response string, size int64, j string) bool {
-	f := false
-	switch j {
-	case "ge":
-		if int64(len(response)) > size {
-			f = true
-		}
-	case "le":
-		if int64(len(response)) < size {
-			f = true
-		}
-	default:
-		f = false
-	}
-	return f
-}
-
-func chkContains(response, contain string) bool {
-	f := false
-	if strings.Contains(response, contain) {
-		f = true
-	}
-	return f
+	return strings.Contains(contentType, rtype)
 }
 
 func chkInclude(response, item string) bool {
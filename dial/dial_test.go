@@ -0,0 +1,317 @@
+package dial
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	osexec "os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"we.com/jiabiao/common/exec"
+)
+
+func TestSplitJSONPathExpr(t *testing.T) {
+	cases := []struct {
+		expr      string
+		path      string
+		op        string
+		value     string
+		expectErr bool
+	}{
+		{`{.status} == "ok"`, "{.status}", "==", "ok", false},
+		{`{.data.count}>=3`, "{.data.count}", ">=", "3", false},
+		{`{.version} =~ "^1\\."`, "{.version}", "=~", `^1\.`, false},
+		{`{.items[0].name}`, "{.items[0].name}", "", "", false},
+		{`.status == "ok"`, "", "", "", true},
+		{`{.status == "ok"`, "", "", "", true},
+		{`{.status} ~ ok`, "", "", "", true},
+	}
+
+	for _, c := range cases {
+		path, op, value, err := splitJSONPathExpr(c.expr)
+		if c.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected error", c.expr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.expr, err)
+			continue
+		}
+		if path != c.path || op != c.op || value != c.value {
+			t.Errorf("%s: expected (%s, %s, %s), got (%s, %s, %s)", c.expr, c.path, c.op, c.value, path, op, value)
+		}
+	}
+}
+
+func TestDialAssertions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Version", "1.2.3")
+		w.Write([]byte(`{"status": "ok", "data": {"count": 3}}`))
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		options  []Option
+		failures []string
+	}{
+		{nil, nil},
+		{
+			[]Option{
+				WithResultCode(200),
+				WithResponsetype("json"),
+				WithResultJSONPath(`{.status} == "ok"`),
+				WithResultJSONPath(`{.data.count} > 2`),
+				WithResultRegexp(`"count":\s*\d+`),
+				WithResultHeader("x-version", `^1\.`),
+				WithMaxLatency(5 * time.Second),
+			},
+			nil,
+		},
+		{
+			[]Option{
+				WithResultCode(201),
+				WithResultJSONPath(`{.status} != "ok"`),
+				WithResultJSONPath(`{.missing}`),
+				WithResultHeader("X-Missing", ""),
+				WithMaxLatency(0),
+			},
+			[]string{"code == 201", `{.status} != "ok"`, "{.missing}", `header X-Missing =~ ""`, "latency <= 0s"},
+		},
+	}
+
+	for i, c := range cases {
+		res, err := Dial(&Drequest{Method: "GET", URL: server.URL, DailType: "http"}, c.options...)
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+			continue
+		}
+		var failed []string
+		for _, f := range res.Failures {
+			failed = append(failed, f.Assertion)
+		}
+		if strings.Join(failed, "|") != strings.Join(c.failures, "|") {
+			t.Errorf("case %d: expected failures %v, got %v", i, c.failures, failed)
+		}
+		if res.Status != (len(c.failures) == 0) {
+			t.Errorf("case %d: unexpected status %v", i, res.Status)
+		}
+	}
+}
+
+func TestDialDefaultCode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("error"))
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		options  []Option
+		failures []string
+	}{
+		{nil, []string{"code < 400"}},
+		// other assertions do not replace the default check
+		{[]Option{WithMaxLatency(5 * time.Second), WithResultContains("error")}, []string{"code < 400"}},
+		{[]Option{WithResultCode(500)}, nil},
+	}
+
+	for i, c := range cases {
+		// http is the default dial type
+		for _, typ := range []string{"http", ""} {
+			res, err := Dial(&Drequest{Method: "GET", URL: server.URL, DailType: typ}, c.options...)
+			if err != nil {
+				t.Errorf("case %d %q: unexpected error: %v", i, typ, err)
+				continue
+			}
+			var failed []string
+			for _, f := range res.Failures {
+				failed = append(failed, f.Assertion)
+			}
+			if strings.Join(failed, "|") != strings.Join(c.failures, "|") {
+				t.Errorf("case %d %q: expected failures %v, got %v", i, typ, c.failures, failed)
+			}
+			if res.Status != (len(c.failures) == 0) {
+				t.Errorf("case %d %q: unexpected status %v", i, typ, res.Status)
+			}
+		}
+	}
+}
+
+func TestDialInvalidOption(t *testing.T) {
+	_, err := Dial(&Drequest{Method: "GET", URL: "http://127.0.0.1:1"}, WithResultRegexp("("))
+	if err == nil {
+		t.Errorf("expected error for invalid regexp")
+	}
+}
+
+func TestDialTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				buf := make([]byte, 64)
+				n, _ := c.Read(buf)
+				if string(buf[:n]) == "PING\r\n" {
+					c.Write([]byte("+PONG\r\n"))
+				}
+			}(c)
+		}
+	}()
+
+	cases := []struct {
+		body   string
+		expect string
+		status bool
+	}{
+		{"", "", true},
+		{"PING\r\n", `^\+PONG`, true},
+		{"HELLO\r\n", `^\+PONG`, false},
+	}
+
+	for i, c := range cases {
+		var options []Option
+		if c.expect != "" {
+			options = append(options, WithExpect(c.expect))
+		}
+		res, err := Dial(&Drequest{DailType: "tcp", URL: "tcp://" + l.Addr().String(), Body: []byte(c.body), Timeout: time.Second}, options...)
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+			continue
+		}
+		if res.Status != c.status {
+			t.Errorf("case %d: expected status %v, got %v: %v", i, c.status, res.Status, res.Failures)
+		}
+		if res.Elapsed.Total == 0 {
+			t.Errorf("case %d: elapsed not set", i)
+		}
+	}
+
+	if _, err := Dial(&Drequest{DailType: "tcp", URL: "127.0.0.1:-1"}); err == nil {
+		t.Errorf("expected error for invalid port")
+	}
+}
+
+func TestDialTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	addr := server.Listener.Addr().String()
+	cases := []struct {
+		options []Option
+		status  bool
+	}{
+		{[]Option{WithCertExpiry(24 * time.Hour), WithCertSAN("example.com")}, true},
+		{[]Option{WithCertExpiry(100 * 365 * 24 * time.Hour)}, false},
+		{[]Option{WithCertSAN("www.we.com")}, false},
+	}
+
+	for i, c := range cases {
+		options := append(c.options, WithTLSConfig(&tls.Config{InsecureSkipVerify: true}))
+		res, err := Dial(&Drequest{DailType: "tls", URL: addr}, options...)
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+			continue
+		}
+		if res.Status != c.status {
+			t.Errorf("case %d: expected status %v, got %v: %v", i, c.status, res.Status, res.Failures)
+		}
+	}
+
+	// certificate of the test server is not trusted
+	if _, err := Dial(&Drequest{DailType: "tls", URL: addr}); err == nil {
+		t.Errorf("expected error for untrusted certificate")
+	}
+}
+
+func TestDialDNS(t *testing.T) {
+	res, err := Dial(&Drequest{DailType: "dns", URL: "localhost"}, WithDNSRecord("127.0.0.1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !res.Status {
+		t.Errorf("unexpected failures: %v", res.Failures)
+	}
+
+	if _, err := Dial(&Drequest{DailType: "dns", URL: "localhost"}, WithDNSType("SRV")); err == nil {
+		t.Errorf("expected error for unsupported record type")
+	}
+}
+
+func TestDialExec(t *testing.T) {
+	fakeCmd := func(out string, err error) exec.FakeCommandAction {
+		return func(cmd string, args ...string) exec.Cmd {
+			fake := &exec.FakeCmd{
+				CombinedOutputScript: []exec.FakeCombinedOutputAction{
+					func() ([]byte, error) { return []byte(out), err },
+				},
+			}
+			return exec.InitFakeCmd(fake, cmd, args...)
+		}
+	}
+
+	fe := &exec.FakeExec{
+		CommandScript: []exec.FakeCommandAction{
+			fakeCmd("OK", nil),
+			fakeCmd("CRITICAL", &exec.CodeExitError{Err: fmt.Errorf("exit 2"), Code: 2}),
+			fakeCmd("", fmt.Errorf("not found")),
+		},
+	}
+
+	cases := []struct {
+		status    bool
+		code      int
+		expectErr bool
+	}{
+		{true, 0, false},
+		{false, 2, false},
+		{false, 0, true},
+	}
+
+	for i, c := range cases {
+		res, err := Dial(&Drequest{DailType: "exec", URL: "/usr/lib/nagios/check_disk -w 10%"}, WithExecutor(fe))
+		if c.expectErr {
+			if err == nil {
+				t.Errorf("case %d: expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+			continue
+		}
+		if res.Status != c.status || res.Code != c.code {
+			t.Errorf("case %d: expected (%v, %d), got (%v, %d)", i, c.status, c.code, res.Status, res.Code)
+		}
+	}
+}
+
+func TestDialExecTimeout(t *testing.T) {
+	if _, err := osexec.LookPath("sleep"); err != nil {
+		t.Skip("sleep is not found")
+	}
+	start := time.Now()
+	_, err := Dial(&Drequest{DailType: "exec", URL: "sleep 10", Timeout: 100 * time.Millisecond})
+	if err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("expected timeout, got %v", err)
+	}
+	// the command is killed instead of waited
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("returned after %v", d)
+	}
+}
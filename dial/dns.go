@@ -0,0 +1,120 @@
+package dial
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// WithResolver for dns dial, query the dns server at addr (host:port) instead of the system resolver
+func WithResolver(addr string) Option {
+	return func(op *Op) {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "53")
+		}
+		op.resolver = addr
+	}
+}
+
+// WithDNSType for dns dial, type of records to query: A(default), AAAA, CNAME, MX, NS, TXT
+func WithDNSType(rtype string) Option {
+	return func(op *Op) {
+		rtype = strings.ToUpper(rtype)
+		switch rtype {
+		case "A", "AAAA", "CNAME", "MX", "NS", "TXT":
+			op.dnsType = rtype
+		default:
+			op.errs = append(op.errs, fmt.Errorf("unsupported dns record type: %s", rtype))
+		}
+	}
+}
+
+// WithDNSRecord for dns dial, assert all of records are returned
+func WithDNSRecord(records ...string) Option {
+	return func(op *Op) {
+		op.asserts = append(op.asserts, func(res *Dresponse, body string) *AssertError {
+			got := map[string]bool{}
+			for _, r := range strings.Split(body, "\n") {
+				got[r] = true
+			}
+			var missing []string
+			for _, r := range records {
+				if !got[r] {
+					missing = append(missing, r)
+				}
+			}
+			if len(missing) == 0 {
+				return nil
+			}
+			return &AssertError{
+				Assertion: "dns records contains " + strings.Join(missing, ","),
+				Actual:    strings.Replace(body, "\n", ",", -1),
+			}
+		})
+	}
+}
+
+func dialDNS(dreq *Drequest, op *Op) (*Dresponse, error) {
+	res := &Dresponse{}
+	name := tcpAddress(dreq.URL)
+
+	resolver := net.DefaultResolver
+	if op.resolver != "" {
+		server := op.resolver
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				d := net.Dialer{}
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dreq.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	res.Elapsed.Start = start
+	var records []string
+	var err error
+	switch op.dnsType {
+	case "A", "AAAA", "":
+		var addrs []net.IPAddr
+		addrs, err = resolver.LookupIPAddr(ctx, name)
+		for _, a := range addrs {
+			isV4 := a.IP.To4() != nil
+			if (op.dnsType == "AAAA") != isV4 {
+				records = append(records, a.IP.String())
+			}
+		}
+	case "CNAME":
+		var cname string
+		cname, err = resolver.LookupCNAME(ctx, name)
+		records = append(records, cname)
+	case "MX":
+		var mxs []*net.MX
+		mxs, err = resolver.LookupMX(ctx, name)
+		for _, mx := range mxs {
+			records = append(records, mx.Host)
+		}
+	case "NS":
+		var nss []*net.NS
+		nss, err = resolver.LookupNS(ctx, name)
+		for _, ns := range nss {
+			records = append(records, ns.Host)
+		}
+	case "TXT":
+		records, err = resolver.LookupTXT(ctx, name)
+	}
+	res.Elapsed.DNS = time.Since(start)
+	res.Elapsed.Total = res.Elapsed.DNS
+
+	if err != nil {
+		return res, err
+	}
+
+	res.Result = strings.Join(records, "\n")
+	return res, nil
+}
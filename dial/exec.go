@@ -0,0 +1,60 @@
+package dial
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"we.com/jiabiao/common/exec"
+)
+
+// WithExecutor for exec dial, run commands with e instead of os/exec
+func WithExecutor(e exec.Interface) Option {
+	return func(op *Op) {
+		op.executor = e
+	}
+}
+
+// dialExec run dreq.URL as a command line, which is split by white spaces,
+// dreq.Body is used as stdin. Code of the response is the exit status.
+// The command is killed if it does not exit in dreq.Timeout
+func dialExec(dreq *Drequest, op *Op) (*Dresponse, error) {
+	res := &Dresponse{}
+	args := strings.Fields(dreq.URL)
+	if len(args) == 0 {
+		return res, fmt.Errorf("exec: command is empty")
+	}
+
+	executor := op.executor
+	if executor == nil {
+		executor = exec.New()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dreq.Timeout)
+	defer cancel()
+	cmd := executor.CommandContext(ctx, args[0], args[1:]...)
+	if len(dreq.Body) > 0 {
+		cmd.SetStdin(bytes.NewReader(dreq.Body))
+	}
+
+	start := time.Now()
+	res.Elapsed.Start = start
+	out, err := cmd.CombinedOutput()
+	res.Elapsed.Total = time.Since(start)
+	res.Result = string(out)
+	if ctx.Err() != nil {
+		return res, fmt.Errorf("exec: %s timeout after %v", args[0], dreq.Timeout)
+	}
+
+	if err != nil {
+		exit, ok := err.(exec.ExitError)
+		if !ok {
+			return res, err
+		}
+		res.Code = exit.ExitStatus()
+	}
+
+	return res, nil
+}
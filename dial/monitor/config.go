@@ -0,0 +1,228 @@
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/golang/glog"
+	"we.com/jiabiao/common/dial"
+	mytime "we.com/jiabiao/common/time"
+	"we.com/jiabiao/common/yaml"
+)
+
+const (
+	// DefaultInterval used if interval of a check is not set
+	DefaultInterval = time.Minute
+	// DefaultJitter used if jitter of a check is not set
+	DefaultJitter = 0.1
+)
+
+// Config of the checks to run
+//
+// a config file looks like:
+//
+//	checks:
+//	- name: api-health
+//	  url: http://api.we.com/health
+//	  interval: 30s
+//	  labels:
+//	    team: api
+//	  assert:
+//	    code: 200
+//	    jsonpath:
+//	    - '{.status} == "ok"'
+//	    maxLatency: 500ms
+//	- name: redis
+//	  type: tcp
+//	  url: 10.0.0.1:6379
+//	  body: "PING\r\n"
+//	  assert:
+//	    expect: '^\+PONG'
+type Config struct {
+	Checks []CheckConfig `json:"checks"`
+}
+
+// CheckConfig describes a dial request, how often to run it and how to check the result
+type CheckConfig struct {
+	Name string `json:"name"`
+	// Type is the DailType of the request, default to http
+	Type    string            `json:"type,omitempty"`
+	URL     string            `json:"url"`
+	Method  string            `json:"method,omitempty"`
+	Header  map[string]string `json:"header,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	Timeout mytime.Duration   `json:"timeout,omitempty"`
+
+	Interval mytime.Duration `json:"interval,omitempty"`
+	// Jitter factor of the interval, see wait.JitterUntil
+	Jitter float64 `json:"jitter,omitempty"`
+	// Labels are attached to alerts of this check
+	Labels map[string]string `json:"labels,omitempty"`
+
+	Assert Assert `json:"assert,omitempty"`
+}
+
+// Assert lists the assertions of a check, each maps to a dial.Option
+type Assert struct {
+	Code     int    `json:"code,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Contains string `json:"contains,omitempty"`
+	Include  string `json:"include,omitempty"`
+	Exclude  string `json:"exclude,omitempty"`
+	Md5      string `json:"md5,omitempty"`
+	Sha256   string `json:"sha256,omitempty"`
+	MinSize  int64  `json:"minSize,omitempty"`
+	MaxSize  int64  `json:"maxSize,omitempty"`
+	// MaxBodySize bytes of the body kept for jsonpath, regexp, include... checks
+	MaxBodySize int64 `json:"maxBodySize,omitempty"`
+	// StreamRegexp are matched while reading the body, without keeping it in memory
+	StreamRegexp []string          `json:"streamRegexp,omitempty"`
+	JSONPath     []string          `json:"jsonpath,omitempty"`
+	Regexp       []string          `json:"regexp,omitempty"`
+	Header       map[string]string `json:"header,omitempty"`
+	MaxLatency   mytime.Duration   `json:"maxLatency,omitempty"`
+	MaxFirstByte mytime.Duration   `json:"maxFirstByte,omitempty"`
+
+	// tcp and tls
+	Expect     string          `json:"expect,omitempty"`
+	CertExpiry mytime.Duration `json:"certExpiry,omitempty"`
+	CertSAN    []string        `json:"certSAN,omitempty"`
+
+	// dns
+	Resolver  string   `json:"resolver,omitempty"`
+	DNSType   string   `json:"dnsType,omitempty"`
+	DNSRecord []string `json:"dnsRecord,omitempty"`
+}
+
+// LoadConfig read checks from a yaml or json file
+func LoadConfig(filename string) (*Config, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		err = fmt.Errorf("error read monitor config file: %v", err)
+		glog.Error(err.Error())
+		return nil, err
+	}
+
+	cfg := Config{}
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(content), 4)
+	if err = decoder.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("error parse monitor config: %v", err)
+	}
+
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// Validate checks names are set and unique
+func (cfg *Config) Validate() error {
+	names := map[string]bool{}
+	for i, c := range cfg.Checks {
+		if c.Name == "" {
+			return fmt.Errorf("checks[%d]: name is empty", i)
+		}
+		if names[c.Name] {
+			return fmt.Errorf("checks[%d]: duplicate check %q", i, c.Name)
+		}
+		names[c.Name] = true
+		if c.URL == "" {
+			return fmt.Errorf("check %q: url is empty", c.Name)
+		}
+	}
+	return nil
+}
+
+// Request returns the dial request of the check
+func (c *CheckConfig) Request() *dial.Drequest {
+	method := c.Method
+	if method == "" {
+		method = "GET"
+	}
+
+	var body []byte
+	if c.Body != "" {
+		body = []byte(c.Body)
+	}
+
+	return &dial.Drequest{
+		Header:   c.Header,
+		Body:     body,
+		Method:   method,
+		DailType: c.Type,
+		URL:      c.URL,
+		Timeout:  time.Duration(c.Timeout),
+	}
+}
+
+// Options returns dial options of the assertions
+func (a *Assert) Options() []dial.Option {
+	var ops []dial.Option
+	if a.Code != 0 {
+		ops = append(ops, dial.WithResultCode(a.Code))
+	}
+	if a.Type != "" {
+		ops = append(ops, dial.WithResponsetype(a.Type))
+	}
+	if a.Contains != "" {
+		ops = append(ops, dial.WithResultContains(a.Contains))
+	}
+	if a.Include != "" {
+		ops = append(ops, dial.WithResultInclude(a.Include))
+	}
+	if a.Exclude != "" {
+		ops = append(ops, dial.WithResultExclude(a.Exclude))
+	}
+	if a.Md5 != "" {
+		ops = append(ops, dial.WithResultMd5sum(a.Md5))
+	}
+	if a.Sha256 != "" {
+		ops = append(ops, dial.WithResultSha256(a.Sha256))
+	}
+	if a.MinSize != 0 || a.MaxSize != 0 {
+		ops = append(ops, dial.WithResultSize(a.MinSize, a.MaxSize))
+	}
+	if a.MaxBodySize != 0 {
+		ops = append(ops, dial.WithMaxBodySize(a.MaxBodySize))
+	}
+	for _, re := range a.StreamRegexp {
+		ops = append(ops, dial.WithStreamRegexp(re, 0))
+	}
+	for _, expr := range a.JSONPath {
+		ops = append(ops, dial.WithResultJSONPath(expr))
+	}
+	for _, re := range a.Regexp {
+		ops = append(ops, dial.WithResultRegexp(re))
+	}
+	for k, v := range a.Header {
+		ops = append(ops, dial.WithResultHeader(k, v))
+	}
+	if a.MaxLatency != 0 {
+		ops = append(ops, dial.WithMaxLatency(time.Duration(a.MaxLatency)))
+	}
+	if a.MaxFirstByte != 0 {
+		ops = append(ops, dial.WithMaxFirstByte(time.Duration(a.MaxFirstByte)))
+	}
+	if a.Expect != "" {
+		ops = append(ops, dial.WithExpect(a.Expect))
+	}
+	if a.CertExpiry != 0 {
+		ops = append(ops, dial.WithCertExpiry(time.Duration(a.CertExpiry)))
+	}
+	if len(a.CertSAN) > 0 {
+		ops = append(ops, dial.WithCertSAN(a.CertSAN...))
+	}
+	if a.Resolver != "" {
+		ops = append(ops, dial.WithResolver(a.Resolver))
+	}
+	if a.DNSType != "" {
+		ops = append(ops, dial.WithDNSType(a.DNSType))
+	}
+	if len(a.DNSRecord) > 0 {
+		ops = append(ops, dial.WithDNSRecord(a.DNSRecord...))
+	}
+	return ops
+}
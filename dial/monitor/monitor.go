@@ -0,0 +1,302 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"we.com/jiabiao/common/alert"
+	"we.com/jiabiao/common/dial"
+	"we.com/jiabiao/common/wait"
+)
+
+const (
+	// DefaultHistory number of results kept for each check
+	DefaultHistory = 100
+)
+
+// DefaultBuckets upper bounds in seconds of the latency histograms
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Result of a single run of a check
+type Result struct {
+	Time     time.Time    `json:"time"`
+	Status   bool         `json:"status"`
+	Elapsed  dial.Elapsed `json:"elapsed"`
+	Failures []string     `json:"failures,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// Histogram is a cumulative latency histogram, as in prometheus
+type Histogram struct {
+	// Buckets upper bounds in seconds
+	Buckets []float64 `json:"buckets"`
+	// Counts[i] is number of observations <= Buckets[i]
+	Counts []uint64 `json:"counts"`
+	Count  uint64   `json:"count"`
+	// Sum of all observations in seconds
+	Sum float64 `json:"sum"`
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		Buckets: buckets,
+		Counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	v := d.Seconds()
+	for i, b := range h.Buckets {
+		if v <= b {
+			h.Counts[i]++
+		}
+	}
+	h.Count++
+	h.Sum += v
+}
+
+// CheckStatus is the current status of a check
+type CheckStatus struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// Status is false if last result failed
+	Status bool    `json:"status"`
+	Last   *Result `json:"last,omitempty"`
+	// Since when the check has been in current status
+	Since time.Time `json:"since"`
+	// Latency histograms keyed by phase: dns, connect, tls, processing, download, firstbyte, total
+	Latency map[string]*Histogram `json:"latency"`
+	History []Result              `json:"history,omitempty"`
+}
+
+type check struct {
+	config CheckConfig
+
+	mu      sync.Mutex
+	results []Result
+	next    int
+	count   int
+	hists   map[string]*Histogram
+	known   bool
+	status  bool
+	since   time.Time
+}
+
+func newCheck(config CheckConfig, history int, buckets []float64) *check {
+	hists := map[string]*Histogram{}
+	for _, phase := range []string{"dns", "connect", "tls", "processing", "download", "firstbyte", "total"} {
+		hists[phase] = newHistogram(buckets)
+	}
+	return &check{
+		config:  config,
+		results: make([]Result, history),
+		hists:   hists,
+	}
+}
+
+// record save r into the ring buffer, returns true if status of the check changed
+func (c *check) record(r Result) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.results[c.next] = r
+	c.next = (c.next + 1) % len(c.results)
+	if c.count < len(c.results) {
+		c.count++
+	}
+
+	e := r.Elapsed
+	if r.Error == "" {
+		c.hists["dns"].observe(e.DNS)
+		c.hists["connect"].observe(e.Connect)
+		c.hists["tls"].observe(e.TLSHandshake)
+		c.hists["processing"].observe(e.Processing)
+		c.hists["download"].observe(e.Download)
+		c.hists["firstbyte"].observe(e.FirstByte)
+		c.hists["total"].observe(e.Total)
+	}
+
+	// an unknown check which succeed is not a change
+	changed := (c.known && c.status != r.Status) || (!c.known && !r.Status)
+	if !c.known || c.status != r.Status {
+		c.since = r.Time
+	}
+	c.known = true
+	c.status = r.Status
+
+	return changed
+}
+
+// history returns results, newest first
+func (c *check) history() []Result {
+	ret := make([]Result, 0, c.count)
+	for i := 1; i <= c.count; i++ {
+		idx := (c.next - i + len(c.results)) % len(c.results)
+		ret = append(ret, c.results[idx])
+	}
+	return ret
+}
+
+func (c *check) snapshot(withHistory bool) CheckStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st := CheckStatus{
+		Name:    c.config.Name,
+		Labels:  c.config.Labels,
+		Status:  c.status,
+		Since:   c.since,
+		Latency: map[string]*Histogram{},
+	}
+	for k, h := range c.hists {
+		cp := *h
+		cp.Counts = append([]uint64(nil), h.Counts...)
+		st.Latency[k] = &cp
+	}
+
+	h := c.history()
+	if len(h) > 0 {
+		st.Last = &h[0]
+	}
+	if withHistory {
+		st.History = h
+	}
+	return st
+}
+
+// Runner runs checks periodically, keeps their results,
+// and send alerts when status of a check changes
+type Runner struct {
+	checks []*check
+	// SendAlerts used to send alerts, default to alert.SendAlerts
+	SendAlerts func(messages ...alert.Message) error
+}
+
+// NewRunner create a runner for checks in cfg, history is the number of results kept for each check
+func NewRunner(cfg *Config, history int) (*Runner, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	if history <= 0 {
+		history = DefaultHistory
+	}
+
+	r := &Runner{SendAlerts: alert.SendAlerts}
+	for _, c := range cfg.Checks {
+		r.checks = append(r.checks, newCheck(c, history, DefaultBuckets))
+	}
+	return r, nil
+}
+
+// Run start all the checks, each in its own goroutine, until stopCh is closed
+func (r *Runner) Run(stopCh <-chan struct{}) {
+	for _, c := range r.checks {
+		interval := time.Duration(c.config.Interval)
+		if interval <= 0 {
+			interval = DefaultInterval
+		}
+		jitter := c.config.Jitter
+		if jitter == 0 {
+			jitter = DefaultJitter
+		}
+
+		go func(c *check) {
+			wait.JitterUntil(func() { r.runOnce(c) }, interval, jitter, true, stopCh)
+		}(c)
+	}
+}
+
+func (r *Runner) runOnce(c *check) {
+	start := time.Now()
+	res, err := dial.Dial(c.config.Request(), c.config.Assert.Options()...)
+
+	result := Result{Time: start}
+	if res != nil {
+		result.Elapsed = res.Elapsed
+		for _, f := range res.Failures {
+			result.Failures = append(result.Failures, f.Error())
+		}
+	}
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Status = res.Status
+	}
+
+	glog.V(4).Infof("check %s: status: %v, elapsed: %v, err: %v", c.config.Name, result.Status, result.Elapsed.Total, err)
+
+	if c.record(result) {
+		r.alert(c, result)
+	}
+}
+
+func (r *Runner) alert(c *check, result Result) {
+	msg := alert.Message{
+		Labels: map[string]string{
+			"alertname": "DialCheckFailed",
+			"check":     c.config.Name,
+		},
+		Annotations: map[string]string{
+			"url": c.config.URL,
+		},
+	}
+	for k, v := range c.config.Labels {
+		msg.Labels[k] = v
+	}
+
+	if result.Status {
+		msg.Annotations["status"] = "resolved"
+		msg.Annotations["summary"] = fmt.Sprintf("check %s recovered", c.config.Name)
+	} else {
+		msg.Annotations["status"] = "firing"
+		reasons := result.Failures
+		if result.Error != "" {
+			reasons = append([]string{result.Error}, reasons...)
+		}
+		msg.Annotations["summary"] = fmt.Sprintf("check %s failed: %s", c.config.Name, strings.Join(reasons, "; "))
+	}
+
+	glog.Infof("check %s status changed: %s", c.config.Name, msg.Annotations["summary"])
+	if r.SendAlerts == nil {
+		return
+	}
+	if err := r.SendAlerts(msg); err != nil {
+		glog.Warningf("send alert of check %s: %v", c.config.Name, err)
+	}
+}
+
+// Status returns current status of all the checks, if name is not empty, only that check
+// is returned, with its history
+func (r *Runner) Status(name string) []CheckStatus {
+	var ret []CheckStatus
+	for _, c := range r.checks {
+		if name == "" {
+			ret = append(ret, c.snapshot(false))
+		} else if c.config.Name == name {
+			ret = append(ret, c.snapshot(true))
+		}
+	}
+	return ret
+}
+
+// ServeHTTP export status of the checks as json,
+// query parameter name select a single check with its history
+func (r *Runner) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	name := req.URL.Query().Get("name")
+	st := r.Status(name)
+	if name != "" && len(st) == 0 {
+		http.Error(w, fmt.Sprintf("check %q not found", name), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(st); err != nil {
+		glog.Warningf("error encode check status: %v", err)
+	}
+}
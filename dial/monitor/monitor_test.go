@@ -0,0 +1,165 @@
+package monitor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"we.com/jiabiao/common/alert"
+	"we.com/jiabiao/common/wait"
+)
+
+func TestLoadConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "monitor")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+checks:
+- name: api
+  url: http://127.0.0.1/health
+  interval: 30s
+  assert:
+    code: 200
+    jsonpath:
+    - '{.status} == "ok"'
+    maxLatency: 500ms
+`)
+	f.Close()
+
+	cfg, err := LoadConfig(f.Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Checks) != 1 {
+		t.Fatalf("expected 1 check, got %d", len(cfg.Checks))
+	}
+	c := cfg.Checks[0]
+	if time.Duration(c.Interval) != 30*time.Second || time.Duration(c.Assert.MaxLatency) != 500*time.Millisecond {
+		t.Errorf("unexpected durations: %v, %v", c.Interval, c.Assert.MaxLatency)
+	}
+	if n := len(c.Assert.Options()); n != 3 {
+		t.Errorf("expected 3 options, got %d", n)
+	}
+	if req := c.Request(); req.Method != "GET" {
+		t.Errorf("expected default method GET, got %s", req.Method)
+	}
+
+	if err := (&Config{Checks: []CheckConfig{{Name: "a", URL: "x"}, {Name: "a", URL: "y"}}}).Validate(); err == nil {
+		t.Errorf("expected error for duplicate names")
+	}
+}
+
+func TestRunner(t *testing.T) {
+	var healthy int32 = 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 1 {
+			w.Write([]byte(`{"status": "ok"}`))
+		} else {
+			w.Write([]byte(`{"status": "down"}`))
+		}
+	}))
+	defer server.Close()
+
+	cfg := &Config{Checks: []CheckConfig{{
+		Name:   "api",
+		URL:    server.URL,
+		Assert: Assert{JSONPath: []string{`{.status} == "ok"`}},
+	}}}
+	r, err := NewRunner(cfg, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var mu sync.Mutex
+	var alerts []alert.Message
+	r.SendAlerts = func(messages ...alert.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		alerts = append(alerts, messages...)
+		return nil
+	}
+
+	c := r.checks[0]
+	for i := 0; i < 2; i++ {
+		r.runOnce(c)
+	}
+	atomic.StoreInt32(&healthy, 0)
+	for i := 0; i < 2; i++ {
+		r.runOnce(c)
+	}
+	atomic.StoreInt32(&healthy, 1)
+	r.runOnce(c)
+
+	st := r.Status("api")
+	if len(st) != 1 {
+		t.Fatalf("expected status of 1 check, got %d", len(st))
+	}
+	if !st[0].Status {
+		t.Errorf("expected check recovered")
+	}
+	if len(st[0].History) != 3 {
+		t.Errorf("expected 3 results in history, got %d", len(st[0].History))
+	}
+	if st[0].History[0].Status != true || st[0].History[1].Status != false {
+		t.Errorf("history not in order: %+v", st[0].History)
+	}
+	if st[0].Latency["total"].Count != 5 {
+		t.Errorf("expected 5 observations, got %d", st[0].Latency["total"].Count)
+	}
+
+	if len(alerts) != 2 {
+		t.Fatalf("expected 2 alerts, got %d", len(alerts))
+	}
+	if alerts[0].Annotations["status"] != "firing" || alerts[1].Annotations["status"] != "resolved" {
+		t.Errorf("unexpected alerts: %+v", alerts)
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/checks", nil))
+	var got []CheckStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 1 || got[0].Name != "api" || got[0].Last == nil {
+		t.Errorf("unexpected status: %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/checks?name=missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+}
+
+func TestRunnerRun(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer server.Close()
+
+	cfg := &Config{Checks: []CheckConfig{{Name: "api", URL: server.URL, Interval: 10}}}
+	r, err := NewRunner(cfg, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r.SendAlerts = nil
+
+	stopCh := make(chan struct{})
+	r.Run(stopCh)
+	err = wait.Poll(10*time.Millisecond, wait.ForeverTestTimeout, func() (bool, error) {
+		return atomic.LoadInt32(&hits) >= 3, nil
+	})
+	close(stopCh)
+	if err != nil {
+		t.Errorf("checks not run periodically: %v", err)
+	}
+}
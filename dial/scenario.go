@@ -0,0 +1,191 @@
+package dial
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"regexp"
+	"time"
+
+	"we.com/jiabiao/common/jsonpath"
+)
+
+// Capture a value from the response of a step into a variable,
+// one of JSONPath and Regexp must be set
+type Capture struct {
+	// Var name of the variable, referenced as ${Var} in later steps
+	Var string
+	// JSONPath like {.data.token}, evaluated on the response body
+	JSONPath string
+	// Regexp matched against the response body, the first submatch
+	// is captured if any, otherwise the whole match
+	Regexp string
+}
+
+// Step of a scenario
+type Step struct {
+	Name string
+	// Request of the step, ${var} in URL, Header values and Body is replaced
+	// by variables captured in previous steps
+	Request Drequest
+	Options []Option
+	Capture []Capture
+}
+
+// Scenario is an ordered list of dials, e.g. login first then call a service
+// with the token returned. All the http steps share a cookie jar.
+type Scenario struct {
+	Steps []Step
+	// Vars are the initial variables
+	Vars map[string]string
+	// Client used for http steps, a new cookie jar is set for each run
+	Client *http.Client
+}
+
+// StepResult is the result of a step
+type StepResult struct {
+	Name     string
+	Response *Dresponse
+	Error    error
+}
+
+// ScenarioResult is the result of a scenario run
+type ScenarioResult struct {
+	// Steps ran, the scenario stops at the first step failed
+	Steps []StepResult
+	// Vars are the variables after the last step
+	Vars   map[string]string
+	Status bool
+	// Elapsed total time of all the steps
+	Elapsed time.Duration
+}
+
+var varPattern = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// Run the steps in order, stops at the first step failed
+func (s *Scenario) Run() *ScenarioResult {
+	// never fails without options
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{}
+	if s.Client != nil {
+		*client = *s.Client
+	}
+	client.Jar = jar
+
+	vars := map[string]string{}
+	for k, v := range s.Vars {
+		vars[k] = v
+	}
+	sr := &ScenarioResult{Vars: vars}
+
+	start := time.Now()
+	defer func() { sr.Elapsed = time.Since(start) }()
+
+	for i, step := range s.Steps {
+		name := step.Name
+		if name == "" {
+			name = fmt.Sprintf("step%d", i)
+		}
+		result := StepResult{Name: name}
+
+		dreq, err := expandRequest(&step.Request, vars)
+		if err == nil {
+			options := append([]Option{WithHttpClinet(client)}, step.Options...)
+			result.Response, err = Dial(dreq, options...)
+		}
+		if err == nil && !result.Response.Status {
+			err = fmt.Errorf("%s failed: %v", name, result.Response.Failures)
+		}
+		if err == nil {
+			err = capture(step.Capture, result.Response.Result, vars)
+		}
+
+		result.Error = err
+		sr.Steps = append(sr.Steps, result)
+		if err != nil {
+			return sr
+		}
+	}
+
+	sr.Status = true
+	return sr
+}
+
+// expand replace ${var} in s, undefined variables are errors
+func expand(s string, vars map[string]string) (string, error) {
+	var missing []string
+	ret := varPattern.ReplaceAllStringFunc(s, func(m string) string {
+		name := m[2 : len(m)-1]
+		v, ok := vars[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("undefined variables: %v", missing)
+	}
+	return ret, nil
+}
+
+func expandRequest(req *Drequest, vars map[string]string) (*Drequest, error) {
+	ret := *req
+
+	var err error
+	if ret.URL, err = expand(req.URL, vars); err != nil {
+		return nil, err
+	}
+
+	if req.Header != nil {
+		ret.Header = make(map[string]string, len(req.Header))
+		for k, v := range req.Header {
+			if ret.Header[k], err = expand(v, vars); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(req.Body) > 0 {
+		body, err := expand(string(req.Body), vars)
+		if err != nil {
+			return nil, err
+		}
+		ret.Body = []byte(body)
+	}
+
+	return &ret, nil
+}
+
+// capture values from body into vars
+func capture(captures []Capture, body string, vars map[string]string) error {
+	for _, c := range captures {
+		switch {
+		case c.JSONPath != "":
+			jp := jsonpath.New(c.Var)
+			if err := jp.Parse(c.JSONPath); err != nil {
+				return fmt.Errorf("invalid jsonpath %q: %v", c.JSONPath, err)
+			}
+			v, err := jsonpathValue(jp, body)
+			if err != nil {
+				return fmt.Errorf("capture %s: %v", c.Var, err)
+			}
+			vars[c.Var] = v
+		case c.Regexp != "":
+			re, err := regexp.Compile(c.Regexp)
+			if err != nil {
+				return fmt.Errorf("invalid regexp %q: %v", c.Regexp, err)
+			}
+			m := re.FindStringSubmatch(body)
+			if m == nil {
+				return fmt.Errorf("capture %s: %q not matched", c.Var, c.Regexp)
+			}
+			vars[c.Var] = m[0]
+			if len(m) > 1 {
+				vars[c.Var] = m[1]
+			}
+		default:
+			return fmt.Errorf("capture %s: neither jsonpath nor regexp is set", c.Var)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package dial
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestScenario(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != `{"user":"admin"}` {
+			http.Error(w, "bad user", http.StatusForbidden)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1"})
+		fmt.Fprint(w, `{"data":{"token":"t0k3n"}}`)
+	})
+	mux.HandleFunc("/api/v1/items", func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("session"); err != nil || c.Value != "s1" {
+			http.Error(w, "no session", http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer t0k3n" {
+			http.Error(w, "bad token", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "items: 3")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	s := &Scenario{
+		Vars: map[string]string{"base": server.URL, "user": "admin"},
+		Steps: []Step{
+			{
+				Name:    "login",
+				Request: Drequest{Method: "POST", URL: "${base}/login", Body: []byte(`{"user":"${user}"}`)},
+				Capture: []Capture{{Var: "token", JSONPath: "{.data.token}"}},
+			},
+			{
+				Name: "items",
+				Request: Drequest{
+					Method: "GET",
+					URL:    "${base}/api/${version}/items",
+					Header: map[string]string{"Authorization": "Bearer ${token}"},
+				},
+				Options: []Option{WithResultCode(200)},
+				Capture: []Capture{{Var: "count", Regexp: `items: (\d+)`}},
+			},
+		},
+	}
+
+	res := s.Run()
+	if res.Status || len(res.Steps) != 2 || res.Steps[1].Error == nil {
+		t.Fatalf("expected undefined variable error at step 2, got %+v", res)
+	}
+
+	s.Vars["version"] = "v1"
+	res = s.Run()
+	if !res.Status {
+		for _, step := range res.Steps {
+			t.Errorf("step %s: %v", step.Name, step.Error)
+		}
+		t.FailNow()
+	}
+	if res.Vars["token"] != "t0k3n" || res.Vars["count"] != "3" {
+		t.Errorf("unexpected vars: %v", res.Vars)
+	}
+	for _, step := range res.Steps {
+		if step.Response.Elapsed.Total <= 0 {
+			t.Errorf("step %s: timing not recorded", step.Name)
+		}
+	}
+
+	s.Vars["user"] = "guest"
+	res = s.Run()
+	if res.Status || len(res.Steps) != 1 || res.Steps[0].Response.Code != http.StatusForbidden {
+		t.Errorf("expected login to fail, got %+v", res.Steps)
+	}
+	// the step has no dial type, it fails by the default check of http
+	if f := res.Steps[0].Response.Failures; len(f) != 1 || f[0].Assertion != "code < 400" {
+		t.Errorf("expected code < 400 failed, got %v", f)
+	}
+}
@@ -0,0 +1,279 @@
+package dial
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultMaxBodySize default number of bytes of the response kept in Dresponse.Result
+	DefaultMaxBodySize = 10 * 1024 * 1024
+
+	// DefaultStreamWindow default window size of WithStreamRegexp
+	DefaultStreamWindow = 64 * 1024
+)
+
+// streamCheck verifies the response while it is read, in constant memory
+type streamCheck interface {
+	io.Writer
+	check() *AssertError
+}
+
+// WithMaxBodySize keep at most n bytes of the response in Dresponse.Result,
+// checks need the whole body (json, include, jsonpath, regexp...) only see these bytes.
+// The rest of the response is still read and verified by the streaming checks:
+// md5, sha256, contains, size and stream regexp
+func WithMaxBodySize(n int64) Option {
+	return func(op *Op) {
+		op.maxBodySize = n
+	}
+}
+
+// WithResultSha256 assert hex encoded sha256 of the response
+func WithResultSha256(sum string) Option {
+	return withHash("sha256", sum)
+}
+
+func withHash(name, sum string) Option {
+	return func(op *Op) {
+		op.streams = append(op.streams, func() streamCheck {
+			c := &hashCheck{name: name, expected: strings.ToLower(sum)}
+			switch name {
+			case "md5":
+				c.h = md5.New()
+			case "sha256":
+				c.h = sha256.New()
+			}
+			return c
+		})
+	}
+}
+
+// WithStreamRegexp assert the response matches pattern, the response is scanned
+// through a window of window bytes, so matches longer than window may be missed.
+// window <= 0 means DefaultStreamWindow. Anchors like ^ and $ are rejected, as they
+// would match at the boundaries of the window instead of the response
+func WithStreamRegexp(pattern string, window int) Option {
+	return func(op *Op) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			op.errs = append(op.errs, fmt.Errorf("invalid stream regexp %q: %v", pattern, err))
+			return
+		}
+		if anchored(re) {
+			op.errs = append(op.errs, fmt.Errorf("invalid stream regexp %q: anchors are not supported", pattern))
+			return
+		}
+		if window <= 0 {
+			window = DefaultStreamWindow
+		}
+		op.streams = append(op.streams, func() streamCheck {
+			return &regexpCheck{re: re, window: window}
+		})
+	}
+}
+
+// anchored whether re has an anchor of text or line
+func anchored(re *regexp.Regexp) bool {
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return false
+	}
+	var walk func(*syntax.Regexp) bool
+	walk = func(r *syntax.Regexp) bool {
+		switch r.Op {
+		case syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText:
+			return true
+		}
+		for _, sub := range r.Sub {
+			if walk(sub) {
+				return true
+			}
+		}
+		return false
+	}
+	return walk(parsed)
+}
+
+type hashCheck struct {
+	name     string
+	expected string
+	h        hash.Hash
+}
+
+func (c *hashCheck) Write(p []byte) (int, error) {
+	return c.h.Write(p)
+}
+
+func (c *hashCheck) check() *AssertError {
+	actual := hex.EncodeToString(c.h.Sum(nil))
+	if actual == c.expected {
+		return nil
+	}
+	return &AssertError{Assertion: c.name + " == " + c.expected, Actual: actual}
+}
+
+type sizeCheck struct {
+	min, max int64
+	n        int64
+}
+
+func (c *sizeCheck) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+func (c *sizeCheck) check() *AssertError {
+	if c.min != 0 && c.n <= c.min {
+		return &AssertError{Assertion: fmt.Sprintf("size > %d", c.min), Actual: strconv.FormatInt(c.n, 10)}
+	}
+	if c.max != 0 && c.n >= c.max {
+		return &AssertError{Assertion: fmt.Sprintf("size < %d", c.max), Actual: strconv.FormatInt(c.n, 10)}
+	}
+	return nil
+}
+
+// containsCheck search sub in the stream, only keeps the last len(sub)-1 bytes
+// for matches across writes
+type containsCheck struct {
+	sub   []byte
+	tail  []byte
+	n     int64
+	found bool
+}
+
+func (c *containsCheck) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	if c.found {
+		return len(p), nil
+	}
+	if len(c.sub) == 0 || bytes.Contains(p, c.sub) {
+		c.found = true
+		return len(p), nil
+	}
+
+	k := len(c.sub) - 1
+	head := p
+	if len(head) > k {
+		head = head[:k]
+	}
+	joined := append(append(make([]byte, 0, len(c.tail)+len(head)), c.tail...), head...)
+	if bytes.Contains(joined, c.sub) {
+		c.found = true
+		return len(p), nil
+	}
+
+	if len(p) >= k {
+		c.tail = append(c.tail[:0], p[len(p)-k:]...)
+	} else {
+		if len(joined) > k {
+			joined = joined[len(joined)-k:]
+		}
+		c.tail = joined
+	}
+	return len(p), nil
+}
+
+func (c *containsCheck) check() *AssertError {
+	if c.found || len(c.sub) == 0 {
+		return nil
+	}
+	return &AssertError{
+		Assertion: fmt.Sprintf("contains %q", c.sub),
+		Actual:    fmt.Sprintf("not found in %d bytes", c.n),
+	}
+}
+
+// regexpCheck match re against a sliding window, which holds at most 2*window bytes
+type regexpCheck struct {
+	re     *regexp.Regexp
+	window int
+	buf    []byte
+	n      int64
+	found  bool
+}
+
+func (c *regexpCheck) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	for rest := p; len(rest) > 0 && !c.found; {
+		chunk := rest
+		if len(chunk) > c.window {
+			chunk = chunk[:c.window]
+		}
+		rest = rest[len(chunk):]
+
+		c.buf = append(c.buf, chunk...)
+		if c.re.Match(c.buf) {
+			c.found = true
+			break
+		}
+		if len(c.buf) > c.window {
+			c.buf = append(c.buf[:0], c.buf[len(c.buf)-c.window:]...)
+		}
+	}
+	return len(p), nil
+}
+
+func (c *regexpCheck) check() *AssertError {
+	if c.found || (c.n == 0 && c.re.Match(nil)) {
+		return nil
+	}
+	return &AssertError{
+		Assertion: fmt.Sprintf("stream =~ %q", c.re.String()),
+		Actual:    fmt.Sprintf("not matched in %d bytes", c.n),
+	}
+}
+
+// limitedBuffer keeps the first limit bytes written, the rest are discarded
+type limitedBuffer struct {
+	bytes.Buffer
+	limit     int64
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := b.limit - int64(b.Len()); int64(n) > room {
+		b.truncated = true
+		if room < 0 {
+			room = 0
+		}
+		p = p[:room]
+	}
+	b.Buffer.Write(p)
+	return n, nil
+}
+
+// verifyStream reads r through, copying to w and feeding all the streaming checks of op,
+// returns number of bytes read and the failed checks
+func verifyStream(r io.Reader, w io.Writer, op *Op) (int64, []*AssertError, error) {
+	writers := []io.Writer{w}
+	var checks []streamCheck
+	for _, newCheck := range op.streams {
+		c := newCheck()
+		checks = append(checks, c)
+		writers = append(writers, c)
+	}
+
+	n, err := io.Copy(io.MultiWriter(writers...), r)
+	if err != nil {
+		return n, nil, err
+	}
+
+	var failures []*AssertError
+	for _, c := range checks {
+		if f := c.check(); f != nil {
+			failures = append(failures, f)
+		}
+	}
+	return n, failures, nil
+}
@@ -0,0 +1,113 @@
+package dial
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestContainsCheck(t *testing.T) {
+	cases := []struct {
+		chunks []string
+		sub    string
+		found  bool
+	}{
+		{[]string{"hello world"}, "world", true},
+		{[]string{"hello wo", "rld"}, "world", true},
+		{[]string{"w", "o", "r", "l", "d"}, "world", true},
+		{[]string{"hello w", "x", "orld"}, "world", false},
+		{[]string{"wor", "wor", "ld"}, "world", true},
+		{[]string{}, "", true},
+	}
+
+	for _, c := range cases {
+		check := &containsCheck{sub: []byte(c.sub)}
+		for _, chunk := range c.chunks {
+			check.Write([]byte(chunk))
+		}
+		if found := check.check() == nil; found != c.found {
+			t.Errorf("%q in %q: expected %v, got %v", c.sub, c.chunks, c.found, found)
+		}
+	}
+}
+
+func TestRegexpCheck(t *testing.T) {
+	check := &regexpCheck{re: regexp.MustCompile(`id=\d{3}`), window: 8}
+	for _, chunk := range []string{"xxxxxxxxxxxx i", "d=1", "2", "3 yyyyyyyyyyyy"} {
+		check.Write([]byte(chunk))
+	}
+	if f := check.check(); f != nil {
+		t.Errorf("expected match, got %v", f)
+	}
+	if len(check.buf) > 2*check.window {
+		t.Errorf("window exceeded: %d", len(check.buf))
+	}
+
+	check = &regexpCheck{re: regexp.MustCompile(`id=\d{3}`), window: 8}
+	check.Write([]byte(strings.Repeat("x", 100)))
+	if f := check.check(); f == nil {
+		t.Errorf("expected no match")
+	}
+}
+
+func TestStreamRegexpAnchored(t *testing.T) {
+	cases := []struct {
+		pattern   string
+		expectErr bool
+	}{
+		{`id=\d+`, false},
+		{`a\^b\$`, false},
+		{`[$^]`, false},
+		{`^id=`, true},
+		{`id=\d+$`, true},
+		{`(?m)x|\Aid`, true},
+		{`id\z`, true},
+	}
+	for _, c := range cases {
+		op := &Op{}
+		WithStreamRegexp(c.pattern, 0)(op)
+		if (len(op.errs) > 0) != c.expectErr {
+			t.Errorf("%s: expected error %v, got %v", c.pattern, c.expectErr, op.errs)
+		}
+	}
+}
+
+func TestDialStream(t *testing.T) {
+	body := strings.Repeat("0123456789", 2*1024*1024) + "THE END"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	dreq := &Drequest{Method: "GET", URL: server.URL}
+	res, err := Dial(dreq,
+		WithMaxBodySize(1024),
+		WithResultMd5sum(fmt.Sprintf("%x", md5.Sum([]byte(body)))),
+		WithResultSha256(fmt.Sprintf("%X", sha256.Sum256([]byte(body)))),
+		WithResultContains("THE END"),
+		WithResultSize(int64(len(body))-1, int64(len(body))+1),
+		WithStreamRegexp(`THE\s+END`, 0),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !res.Status {
+		t.Errorf("expected success, failures: %v", res.Failures)
+	}
+	if res.Size != int64(len(body)) || len(res.Result) != 1024 || !res.Truncated {
+		t.Errorf("unexpected size %d, result length %d, truncated %v", res.Size, len(res.Result), res.Truncated)
+	}
+
+	res, err = Dial(dreq, WithResultContains("NOT THERE"), WithResultSize(0, 100))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Status || len(res.Failures) != 2 {
+		t.Errorf("expected 2 failures, got %v", res.Failures)
+	}
+}
@@ -0,0 +1,108 @@
+package dial
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// maxTCPRead max bytes read from a tcp connection when waiting for the expected reply
+	maxTCPRead = 64 * 1024
+)
+
+// WithExpect for tcp and tls dial, read from the connection until the reply matches pattern,
+// dreq.Body if not empty, is sent before reading.
+// The assertion fails if the pattern not matched before timeout.
+func WithExpect(pattern string) Option {
+	return func(op *Op) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			op.errs = append(op.errs, fmt.Errorf("invalid expect %q: %v", pattern, err))
+			return
+		}
+		op.expect = re
+		op.asserts = append(op.asserts, func(res *Dresponse, body string) *AssertError {
+			if re.MatchString(body) {
+				return nil
+			}
+			return &AssertError{
+				Assertion: fmt.Sprintf("reply =~ %q", pattern),
+				Actual:    abbrev(body),
+			}
+		})
+	}
+}
+
+// tcpAddress strip scheme prefix like tcp:// from addr
+func tcpAddress(addr string) string {
+	if i := strings.Index(addr, "://"); i >= 0 {
+		return addr[i+3:]
+	}
+	return addr
+}
+
+func dialTCP(dreq *Drequest, op *Op) (*Dresponse, error) {
+	res := &Dresponse{}
+	start := time.Now()
+	deadline := start.Add(dreq.Timeout)
+	res.Elapsed.Start = start
+
+	conn, err := net.DialTimeout("tcp", tcpAddress(dreq.URL), dreq.Timeout)
+	if err != nil {
+		return res, err
+	}
+	defer conn.Close()
+	res.Elapsed.Connect = time.Since(start)
+	res.Elapsed.GotConn = res.Elapsed.Connect
+	res.Elapsed.RemoteAddr = conn.RemoteAddr().String()
+
+	conn.SetDeadline(deadline)
+	if err := converse(conn, dreq.Body, op.expect, res, start); err != nil {
+		return res, err
+	}
+
+	res.Elapsed.Total = time.Since(start)
+	return res, nil
+}
+
+// converse send payload on conn, then read until reply matches expect,
+// the connection is closed by peer or deadline reached.
+// The reply is saved in res.Result
+func converse(conn net.Conn, payload []byte, expect *regexp.Regexp, res *Dresponse, start time.Time) error {
+	if len(payload) > 0 {
+		if _, err := conn.Write(payload); err != nil {
+			return fmt.Errorf("error send payload: %v", err)
+		}
+		res.Elapsed.WroteRequest = time.Since(start)
+	}
+
+	if expect == nil {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	chunk := make([]byte, 4096)
+	for buf.Len() < maxTCPRead {
+		n, err := conn.Read(chunk)
+		if n > 0 {
+			if buf.Len() == 0 {
+				res.Elapsed.FirstByte = time.Since(start)
+				res.Elapsed.Processing = res.Elapsed.FirstByte - res.Elapsed.WroteRequest
+			}
+			buf.Write(chunk[:n])
+			if expect.Match(buf.Bytes()) {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	res.Result = buf.String()
+	return nil
+}
@@ -0,0 +1,120 @@
+package dial
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// WithTLSConfig config used for tls dial, ServerName default to the host of dreq.URL
+func WithTLSConfig(config *tls.Config) Option {
+	return func(op *Op) {
+		op.tlsConfig = config
+	}
+}
+
+// WithCertExpiry for tls dial, assert the server certificate is valid for at least min
+func WithCertExpiry(min time.Duration) Option {
+	return func(op *Op) {
+		op.asserts = append(op.asserts, func(res *Dresponse, body string) *AssertError {
+			cert := leafCert(res)
+			if cert != nil && time.Until(cert.NotAfter) >= min {
+				return nil
+			}
+			actual := "no certificate"
+			if cert != nil {
+				actual = cert.NotAfter.Format(time.RFC3339)
+			}
+			return &AssertError{
+				Assertion: fmt.Sprintf("certificate valid for %v", min),
+				Actual:    actual,
+			}
+		})
+	}
+}
+
+// WithCertSAN for tls dial, assert the server certificate is valid for all the names
+func WithCertSAN(names ...string) Option {
+	return func(op *Op) {
+		op.asserts = append(op.asserts, func(res *Dresponse, body string) *AssertError {
+			cert := leafCert(res)
+			var missing []string
+			for _, n := range names {
+				if cert == nil || cert.VerifyHostname(n) != nil {
+					missing = append(missing, n)
+				}
+			}
+			if len(missing) == 0 {
+				return nil
+			}
+			actual := "no certificate"
+			if cert != nil {
+				actual = strings.Join(cert.DNSNames, ",")
+			}
+			return &AssertError{
+				Assertion: "certificate SAN contains " + strings.Join(missing, ","),
+				Actual:    actual,
+			}
+		})
+	}
+}
+
+func leafCert(res *Dresponse) *x509.Certificate {
+	if res.TLS == nil || len(res.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return res.TLS.PeerCertificates[0]
+}
+
+func dialTLS(dreq *Drequest, op *Op) (*Dresponse, error) {
+	res := &Dresponse{}
+	addr := tcpAddress(dreq.URL)
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return res, err
+	}
+
+	config := &tls.Config{}
+	if op.tlsConfig != nil {
+		config = op.tlsConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+
+	start := time.Now()
+	deadline := start.Add(dreq.Timeout)
+	res.Elapsed.Start = start
+	rawConn, err := net.DialTimeout("tcp", addr, dreq.Timeout)
+	if err != nil {
+		return res, err
+	}
+	defer rawConn.Close()
+	res.Elapsed.Connect = time.Since(start)
+	res.Elapsed.RemoteAddr = rawConn.RemoteAddr().String()
+
+	rawConn.SetDeadline(deadline)
+	conn := tls.Client(rawConn, config)
+	if err := conn.Handshake(); err != nil {
+		return res, fmt.Errorf("tls handshake: %v", err)
+	}
+	res.Elapsed.GotConn = time.Since(start)
+	res.Elapsed.TLSHandshake = res.Elapsed.GotConn - res.Elapsed.Connect
+	state := conn.ConnectionState()
+	res.TLS = &state
+
+	if err := converse(conn, dreq.Body, op.expect, res, start); err != nil {
+		return res, err
+	}
+
+	if cert := leafCert(res); cert != nil && res.Result == "" {
+		res.Result = fmt.Sprintf("subject: %s, issuer: %s, notAfter: %s",
+			cert.Subject, cert.Issuer, cert.NotAfter.Format(time.RFC3339))
+	}
+
+	res.Elapsed.Total = time.Since(start)
+	return res, nil
+}
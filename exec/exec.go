@@ -17,11 +17,17 @@ limitations under the License.
 package exec
 
 import (
+	"context"
 	"io"
 	osexec "os/exec"
 	"syscall"
+	"time"
 )
 
+// waitDelay how long to wait for the output pipes after a command is killed, they may be
+// held open by its children
+const waitDelay = time.Second
+
 // ErrExecutableNotFound is returned if the executable is not found.
 var ErrExecutableNotFound = osexec.ErrNotFound
 
@@ -32,6 +38,10 @@ type Interface interface {
 	// This follows the pattern of package os/exec.
 	Command(cmd string, args ...string) Cmd
 
+	// CommandContext returns a Cmd like Command, the process is killed if ctx is done
+	// before it exits. This follows the pattern of package os/exec.
+	CommandContext(ctx context.Context, cmd string, args ...string) Cmd
+
 	// LookPath wraps os/exec.LookPath
 	LookPath(file string) (string, error)
 }
@@ -45,9 +55,13 @@ type Cmd interface {
 	CombinedOutput() ([]byte, error)
 	// Output runs the command and returns standard output, but not standard err
 	Output() ([]byte, error)
+	// Run runs the command, output is written to the writers set by SetStdout and SetStderr.
+	// This follows the pattern of package os/exec.
+	Run() error
 	SetDir(dir string)
 	SetStdin(in io.Reader)
 	SetStdout(out io.Writer)
+	SetStderr(out io.Writer)
 }
 
 // ExitError is an interface that presents an API similar to os.ProcessState, which is
@@ -73,6 +87,13 @@ func (executor *executor) Command(cmd string, args ...string) Cmd {
 	return (*cmdWrapper)(osexec.Command(cmd, args...))
 }
 
+// CommandContext is part of the Interface interface.
+func (executor *executor) CommandContext(ctx context.Context, cmd string, args ...string) Cmd {
+	c := osexec.CommandContext(ctx, cmd, args...)
+	c.WaitDelay = waitDelay
+	return (*cmdWrapper)(c)
+}
+
 // LookPath is part of the Interface interface
 func (executor *executor) LookPath(file string) (string, error) {
 	return osexec.LookPath(file)
@@ -93,6 +114,18 @@ func (cmd *cmdWrapper) SetStdout(out io.Writer) {
 	cmd.Stdout = out
 }
 
+func (cmd *cmdWrapper) SetStderr(out io.Writer) {
+	cmd.Stderr = out
+}
+
+// Run is part of the Cmd interface.
+func (cmd *cmdWrapper) Run() error {
+	if err := (*osexec.Cmd)(cmd).Run(); err != nil {
+		return handleError(err)
+	}
+	return nil
+}
+
 // CombinedOutput is part of the Cmd interface.
 func (cmd *cmdWrapper) CombinedOutput() ([]byte, error) {
 	out, err := (*osexec.Cmd)(cmd).CombinedOutput()
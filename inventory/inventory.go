@@ -0,0 +1,192 @@
+package inventory
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/golang/glog"
+	"we.com/jiabiao/common/communicator"
+	"we.com/jiabiao/common/communicator/types"
+	utilerrors "we.com/jiabiao/common/errors"
+	"we.com/jiabiao/common/labels"
+	"we.com/jiabiao/common/yaml"
+)
+
+// Inventory is a list of hosts, and the groups they belong to.
+//
+// an inventory file looks like:
+//
+//	defaults:
+//	  connInfo:
+//	    type: ssh
+//	    user: root
+//	groups:
+//	  web:
+//	    labels:
+//	      role: web
+//	    connInfo:
+//	      port: "2222"
+//	hosts:
+//	- name: web-01
+//	  address: 10.0.0.1
+//	  groups: [web]
+//	  labels:
+//	    env: prod
+//	  connInfo:
+//	    user: deploy
+type Inventory struct {
+	Defaults Group            `json:"defaults,omitempty"`
+	Groups   map[string]Group `json:"groups,omitempty"`
+	Hosts    []Host           `json:"hosts"`
+}
+
+// Group defaults shared by all the hosts belong to it
+type Group struct {
+	Labels   labels.Set     `json:"labels,omitempty"`
+	ConnInfo types.ConnInfo `json:"connInfo,omitempty"`
+}
+
+// Host is a single entry of the inventory
+type Host struct {
+	Name string `json:"name"`
+	// Address used to connect to the host, default to Name
+	Address  string         `json:"address,omitempty"`
+	Groups   []string       `json:"groups,omitempty"`
+	Labels   labels.Set     `json:"labels,omitempty"`
+	ConnInfo types.ConnInfo `json:"connInfo,omitempty"`
+}
+
+// Target is a host selected from the inventory, with defaults and group
+// settings merged in
+type Target struct {
+	Name     string
+	Labels   labels.Set
+	ConnInfo types.ConnInfo
+}
+
+// Load read inventory from a yaml or json file
+func Load(filename string) (*Inventory, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		err = fmt.Errorf("error read inventory file: %v", err)
+		glog.Error(err.Error())
+		return nil, err
+	}
+
+	return Decode(bytes.NewReader(content))
+}
+
+// Decode read inventory from r, which may be yaml or json
+func Decode(r io.Reader) (*Inventory, error) {
+	inv := Inventory{}
+	decoder := yaml.NewYAMLOrJSONDecoder(r, 4)
+	if err := decoder.Decode(&inv); err != nil {
+		return nil, fmt.Errorf("error parse inventory: %v", err)
+	}
+
+	if err := inv.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &inv, nil
+}
+
+// Validate checks host names are unique and all referenced groups are defined
+func (inv *Inventory) Validate() error {
+	var errs []error
+	names := map[string]bool{}
+	for i, h := range inv.Hosts {
+		if h.Name == "" {
+			errs = append(errs, fmt.Errorf("hosts[%d]: name is empty", i))
+			continue
+		}
+		if names[h.Name] {
+			errs = append(errs, fmt.Errorf("hosts[%d]: duplicate host %q", i, h.Name))
+		}
+		names[h.Name] = true
+
+		for _, g := range h.Groups {
+			if _, ok := inv.Groups[g]; !ok {
+				errs = append(errs, fmt.Errorf("host %q: group %q not defined", h.Name, g))
+			}
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// Target merge defaults, groups and host settings of h,
+// later ones override former ones
+func (inv *Inventory) Target(h *Host) *Target {
+	t := &Target{
+		Name:     h.Name,
+		Labels:   labels.Set{},
+		ConnInfo: types.ConnInfo{},
+	}
+
+	merge := func(g Group) {
+		for k, v := range g.Labels {
+			t.Labels[k] = v
+		}
+		for k, v := range g.ConnInfo {
+			t.ConnInfo[k] = v
+		}
+	}
+
+	merge(inv.Defaults)
+	for _, name := range h.Groups {
+		merge(inv.Groups[name])
+	}
+	merge(Group{Labels: h.Labels, ConnInfo: h.ConnInfo})
+
+	if t.ConnInfo["host"] == "" {
+		if h.Address != "" {
+			t.ConnInfo["host"] = h.Address
+		} else {
+			t.ConnInfo["host"] = h.Name
+		}
+	}
+
+	return t
+}
+
+// Select returns targets whose labels match selector, in the order they appear in the inventory.
+// an empty selector selects all the hosts
+func (inv *Inventory) Select(selector string) ([]*Target, error) {
+	sel, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %q: %v", selector, err)
+	}
+
+	var ret []*Target
+	for i := range inv.Hosts {
+		t := inv.Target(&inv.Hosts[i])
+		if sel.Matches(t.Labels) {
+			ret = append(ret, t)
+		}
+	}
+
+	glog.V(4).Infof("selector %q matches %d of %d hosts", selector, len(ret), len(inv.Hosts))
+	return ret, nil
+}
+
+// Communicators returns a communicator for each host selected by selector
+func (inv *Inventory) Communicators(selector string) ([]communicator.Communicator, error) {
+	targets, err := inv.Select(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]communicator.Communicator, 0, len(targets))
+	for _, t := range targets {
+		c, err := communicator.New(t.ConnInfo)
+		if err != nil {
+			return nil, fmt.Errorf("host %q: %v", t.Name, err)
+		}
+		ret = append(ret, c)
+	}
+
+	return ret, nil
+}
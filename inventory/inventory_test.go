@@ -0,0 +1,142 @@
+package inventory
+
+import (
+	"strings"
+	"testing"
+)
+
+const testInventory = `
+defaults:
+  connInfo:
+    type: ssh
+    user: root
+    timeout: 30s
+groups:
+  web:
+    labels:
+      role: web
+    connInfo:
+      port: "2222"
+  api:
+    labels:
+      role: api
+hosts:
+- name: web-01
+  address: 10.0.0.1
+  groups: [web]
+  labels:
+    env: prod
+  connInfo:
+    user: deploy
+- name: api-01
+  groups: [api]
+  labels:
+    env: prod
+- name: web-02
+  groups: [web]
+  labels:
+    env: test
+`
+
+func TestDecode(t *testing.T) {
+	inv, err := Decode(strings.NewReader(testInventory))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(inv.Hosts) != 3 {
+		t.Fatalf("expected 3 hosts, got %d", len(inv.Hosts))
+	}
+
+	tg := inv.Target(&inv.Hosts[0])
+	expected := map[string]string{
+		"type":    "ssh",
+		"user":    "deploy",
+		"timeout": "30s",
+		"port":    "2222",
+		"host":    "10.0.0.1",
+	}
+	for k, v := range expected {
+		if tg.ConnInfo[k] != v {
+			t.Errorf("connInfo %q: expected %q, got %q", k, v, tg.ConnInfo[k])
+		}
+	}
+	if tg.Labels.String() != "env=prod,role=web" {
+		t.Errorf("unexpected labels: %v", tg.Labels)
+	}
+
+	tg = inv.Target(&inv.Hosts[1])
+	if tg.ConnInfo["host"] != "api-01" {
+		t.Errorf("expected host default to name, got %q", tg.ConnInfo["host"])
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	cases := []string{
+		"hosts:\n- name: a\n  groups: [missing]\n",
+		"hosts:\n- name: a\n- name: a\n",
+		"hosts:\n- address: 10.0.0.1\n",
+	}
+
+	for i, c := range cases {
+		if _, err := Decode(strings.NewReader(c)); err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	inv, err := Decode(strings.NewReader(testInventory))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		selector string
+		expected []string
+	}{
+		{"", []string{"web-01", "api-01", "web-02"}},
+		{"env=prod", []string{"web-01", "api-01"}},
+		{"env=prod,role in (web,api)", []string{"web-01", "api-01"}},
+		{"role=web,env!=prod", []string{"web-02"}},
+		{"role=db", nil},
+	}
+
+	for _, c := range cases {
+		targets, err := inv.Select(c.selector)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.selector, err)
+			continue
+		}
+		var names []string
+		for _, tg := range targets {
+			names = append(names, tg.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("%q: expected %v, got %v", c.selector, c.expected, names)
+		}
+	}
+
+	if _, err := inv.Select("env in (prod"); err == nil {
+		t.Errorf("expected error for invalid selector")
+	}
+}
+
+func TestCommunicators(t *testing.T) {
+	inv, err := Decode(strings.NewReader(testInventory))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	comms, err := inv.Communicators("role=web")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(comms) != 2 {
+		t.Fatalf("expected 2 communicators, got %d", len(comms))
+	}
+
+	inv.Defaults.ConnInfo["type"] = "telnet"
+	if _, err := inv.Communicators("role=web"); err == nil {
+		t.Fatalf("expected error with telnet")
+	}
+}
@@ -0,0 +1,43 @@
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+	"we.com/jiabiao/common/zk"
+)
+
+// LoadFromZK read inventory stored as the value of zookeeper node key
+func LoadFromZK(c *zk.Client, key string) (*Inventory, error) {
+	if c == nil {
+		return nil, fmt.Errorf("zk client is nil")
+	}
+
+	value, err := c.GetNodeValue(key)
+	if err != nil {
+		return nil, fmt.Errorf("error get inventory from zk %s: %v", key, err)
+	}
+
+	return Decode(strings.NewReader(value))
+}
+
+// LoadFromEtcd read inventory stored as the value of etcd key
+func LoadFromEtcd(ctx context.Context, kv clientv3.KV, key string) (*Inventory, error) {
+	if kv == nil {
+		return nil, fmt.Errorf("etcd client is nil")
+	}
+
+	resp, err := kv.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("error get inventory from etcd %s: %v", key, err)
+	}
+
+	if len(resp.Kvs) == 0 {
+		return nil, fmt.Errorf("inventory not found in etcd: %s", key)
+	}
+
+	return Decode(bytes.NewReader(resp.Kvs[0].Value))
+}
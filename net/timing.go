@@ -0,0 +1,197 @@
+package net
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timing is the time breakdown of a request.
+// Phases (DNS, Connect, TLSHandshake, Processing, Download) are durations of each phase,
+// GotConn, WroteRequest, FirstByte and Total are measured from Start.
+// Durations are serialized to json as nanoseconds.
+type Timing struct {
+	Start time.Time `json:"start"`
+
+	// DNS lookup
+	DNS time.Duration `json:"dns"`
+	// tcp connect
+	Connect time.Duration `json:"connect"`
+	// tls handshake, zero for plain text connections
+	TLSHandshake time.Duration `json:"tlsHandshake"`
+	// Processing is the time from request written to first response byte
+	Processing time.Duration `json:"processing"`
+	// Download is the time from first response byte to body read
+	Download time.Duration `json:"download"`
+
+	GotConn      time.Duration `json:"gotConn"`
+	WroteRequest time.Duration `json:"wroteRequest"`
+	FirstByte    time.Duration `json:"firstByte"`
+	Total        time.Duration `json:"total"`
+
+	// Reused is true if the connection was reused from a previous request
+	Reused bool `json:"reused"`
+	// WasIdle is true if the connection was obtained from the idle pool
+	WasIdle    bool   `json:"wasIdle"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	// Proto is the protocol of the response, e.g. HTTP/1.1 or HTTP/2.0
+	Proto string `json:"proto,omitempty"`
+
+	state *timingState
+}
+
+// timingState keeps start time of phases, httptrace hooks may be called concurrently
+type timingState struct {
+	sync.Mutex
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+}
+
+// NewTiming returns a Timing started now
+func NewTiming() *Timing {
+	return &Timing{
+		Start: time.Now(),
+		state: &timingState{},
+	}
+}
+
+// WithClientTrace returns a context which records timing of requests made with it into t
+func (t *Timing) WithClientTrace(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, t.ClientTrace())
+}
+
+// ClientTrace returns hooks which record timing into t
+func (t *Timing) ClientTrace() *httptrace.ClientTrace {
+	if t.state == nil {
+		t.state = &timingState{}
+	}
+	s := t.state
+
+	return &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			s.Lock()
+			defer s.Unlock()
+			s.dnsStart = time.Now()
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			s.Lock()
+			defer s.Unlock()
+			t.DNS = time.Since(s.dnsStart)
+		},
+		ConnectStart: func(network, addr string) {
+			s.Lock()
+			defer s.Unlock()
+			s.connectStart = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			s.Lock()
+			defer s.Unlock()
+			if err == nil {
+				t.Connect = time.Since(s.connectStart)
+			}
+		},
+		TLSHandshakeStart: func() {
+			s.Lock()
+			defer s.Unlock()
+			s.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			s.Lock()
+			defer s.Unlock()
+			t.TLSHandshake = time.Since(s.tlsStart)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			s.Lock()
+			defer s.Unlock()
+			t.GotConn = time.Since(t.Start)
+			t.Reused = info.Reused
+			t.WasIdle = info.WasIdle
+			if info.Conn != nil {
+				t.RemoteAddr = info.Conn.RemoteAddr().String()
+			}
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			s.Lock()
+			defer s.Unlock()
+			s.wroteRequest = time.Now()
+			t.WroteRequest = s.wroteRequest.Sub(t.Start)
+		},
+		GotFirstResponseByte: func() {
+			s.Lock()
+			defer s.Unlock()
+			s.firstByte = time.Now()
+			t.FirstByte = s.firstByte.Sub(t.Start)
+			if !s.wroteRequest.IsZero() {
+				t.Processing = s.firstByte.Sub(s.wroteRequest)
+			}
+		},
+	}
+}
+
+// GotResponse records the response headers are received
+func (t *Timing) GotResponse(resp *http.Response) {
+	t.lock()
+	defer t.unlock()
+	t.Total = time.Since(t.Start)
+	if resp != nil {
+		t.Proto = resp.Proto
+	}
+}
+
+// BodyDone records the response body is read through
+func (t *Timing) BodyDone() {
+	t.lock()
+	defer t.unlock()
+	now := time.Now()
+	t.Total = now.Sub(t.Start)
+	if t.state != nil && !t.state.firstByte.IsZero() {
+		t.Download = now.Sub(t.state.firstByte)
+	}
+}
+
+// TrackBody replace resp.Body with a reader which calls BodyDone
+// when the body is read to EOF or closed
+func (t *Timing) TrackBody(resp *http.Response) {
+	if resp == nil || resp.Body == nil {
+		return
+	}
+	resp.Body = &trackedBody{ReadCloser: resp.Body, t: t}
+}
+
+func (t *Timing) lock() {
+	if t.state != nil {
+		t.state.Lock()
+	}
+}
+
+func (t *Timing) unlock() {
+	if t.state != nil {
+		t.state.Unlock()
+	}
+}
+
+type trackedBody struct {
+	io.ReadCloser
+	t    *Timing
+	once sync.Once
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(b.t.BodyDone)
+	}
+	return n, err
+}
+
+func (b *trackedBody) Close() error {
+	b.once.Do(b.t.BodyDone)
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,68 @@
+package net
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTiming(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 1024)))
+	}))
+	defer server.Close()
+
+	client := server.Client()
+	get := func() *Timing {
+		timing := NewTiming()
+		req, err := http.NewRequest("GET", server.URL, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		req = req.WithContext(timing.WithClientTrace(req.Context()))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		timing.GotResponse(resp)
+		timing.TrackBody(resp)
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return timing
+	}
+
+	first := get()
+	if first.Reused {
+		t.Errorf("first connection should not be reused")
+	}
+	if first.Connect <= 0 || first.TLSHandshake <= 0 {
+		t.Errorf("connect and tls handshake should be recorded: %+v", first)
+	}
+	if first.RemoteAddr != server.Listener.Addr().String() {
+		t.Errorf("expected remote addr %s, got %s", server.Listener.Addr(), first.RemoteAddr)
+	}
+	if first.Proto != "HTTP/1.1" {
+		t.Errorf("expected proto HTTP/1.1, got %s", first.Proto)
+	}
+	if first.FirstByte <= 0 || first.Total < first.FirstByte {
+		t.Errorf("unexpected first byte %v and total %v", first.FirstByte, first.Total)
+	}
+
+	second := get()
+	if !second.Reused || second.TLSHandshake != 0 {
+		t.Errorf("second connection should be reused: %+v", second)
+	}
+
+	data, err := json.Marshal(first)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, key := range []string{`"tlsHandshake":`, `"reused":false`, `"remoteAddr":`, `"download":`} {
+		if !strings.Contains(string(data), key) {
+			t.Errorf("expected %s in %s", key, data)
+		}
+	}
+}
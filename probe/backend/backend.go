@@ -0,0 +1,52 @@
+// Package backend probes backing services with their own protocols:
+// zookeeper four letter words, redis PING and INFO, the mysql handshake greeting and etcd /health.
+// Like the tcp and http probes, connection errors are converted into failures,
+// the string returned is a detail of the service or why the probe failed.
+package backend
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+const (
+	// maxReply max bytes read from a backend for a probe
+	maxReply = 1024 * 1024
+)
+
+// exchange sends payload to addr, and returns all the reply until the server close the
+// connection, or done returns true for the reply read so far
+func exchange(addr string, payload []byte, timeout time.Duration, done func(reply []byte) bool) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if len(payload) > 0 {
+		if _, err := conn.Write(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	if done == nil {
+		return ioutil.ReadAll(io.LimitReader(conn, maxReply))
+	}
+
+	var reply []byte
+	buf := make([]byte, 4096)
+	for len(reply) < maxReply {
+		n, err := conn.Read(buf)
+		reply = append(reply, buf[:n]...)
+		if done(reply) {
+			return reply, nil
+		}
+		if err != nil {
+			return reply, err
+		}
+	}
+	return reply, nil
+}
@@ -0,0 +1,207 @@
+package backend
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"we.com/jiabiao/common/etcd"
+	"we.com/jiabiao/common/probe"
+)
+
+// serve accepts connections on a random port, and calls handle for each of them
+func serve(t *testing.T, handle func(conn net.Conn)) (string, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func fakeZK(mntr string) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		buf := make([]byte, 4)
+		if _, err := conn.Read(buf); err != nil {
+			return
+		}
+		switch string(buf) {
+		case "ruok":
+			conn.Write([]byte("imok"))
+		case "mntr":
+			conn.Write([]byte(mntr))
+		}
+	}
+}
+
+func TestZKProbe(t *testing.T) {
+	cases := []struct {
+		mntr   string
+		result probe.Result
+		detail string
+	}{
+		{"zk_version\t3.4.10\nzk_avg_latency\t0\nzk_server_state\tfollower\n", probe.Success, "state: follower"},
+		{"This ZooKeeper instance is not currently serving requests\n", probe.Failure, "not currently serving"},
+		{"mntr is not executed because it is not in the whitelist.\n", probe.Warning, "whitelist"},
+	}
+
+	for _, c := range cases {
+		addr, stop := serve(t, fakeZK(c.mntr))
+		result, detail, err := DoZKProbe(addr, time.Second)
+		stop()
+		if err != nil || result != c.result || !strings.Contains(detail, c.detail) {
+			t.Errorf("mntr %q: expected %v %q, got %v %q %v", c.mntr, c.result, c.detail, result, detail, err)
+		}
+	}
+
+	addr, stop := serve(t, func(conn net.Conn) {})
+	defer stop()
+	if result, _, _ := DoZKProbe(addr, time.Second); result != probe.Failure {
+		t.Errorf("expected failure if ruok is not answered, got %v", result)
+	}
+}
+
+func fakeRedis(password, info string) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		authed := password == ""
+		for {
+			// *n, then $len and arg for each argument
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			var n int
+			fmt.Sscanf(line, "*%d", &n)
+			var args []string
+			for i := 0; i < n; i++ {
+				r.ReadString('\n')
+				arg, _ := r.ReadString('\n')
+				args = append(args, strings.TrimSpace(arg))
+			}
+
+			switch {
+			case args[0] == "AUTH":
+				if args[1] != password {
+					conn.Write([]byte("-ERR invalid password\r\n"))
+					continue
+				}
+				authed = true
+				conn.Write([]byte("+OK\r\n"))
+			case !authed:
+				conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+			case args[0] == "PING":
+				conn.Write([]byte("+PONG\r\n"))
+			case args[0] == "INFO":
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(info), info)
+			}
+		}
+	}
+}
+
+func TestRedisProbe(t *testing.T) {
+	master := "# Replication\r\nrole:master\r\nconnected_slaves:2\r\n"
+	slaveDown := "# Replication\r\nrole:slave\r\nmaster_host:10.0.0.1\r\nmaster_port:6379\r\nmaster_link_status:down\r\n"
+
+	cases := []struct {
+		name     string
+		server   string
+		info     string
+		password string
+		result   probe.Result
+		detail   string
+	}{
+		{"master", "", master, "", probe.Success, "role: master, connected slaves: 2"},
+		{"slave link down", "", slaveDown, "", probe.Warning, "link: down"},
+		{"auth", "secret", master, "secret", probe.Success, "role: master"},
+		{"no auth", "secret", master, "", probe.Failure, "NOAUTH"},
+		{"wrong password", "secret", master, "wrong", probe.Failure, "invalid password"},
+	}
+
+	for _, c := range cases {
+		addr, stop := serve(t, fakeRedis(c.server, c.info))
+		result, detail, err := DoRedisProbe(addr, c.password, time.Second)
+		stop()
+		if err != nil || result != c.result || !strings.Contains(detail, c.detail) {
+			t.Errorf("%s: expected %v %q, got %v %q %v", c.name, c.result, c.detail, result, detail, err)
+		}
+	}
+}
+
+func TestMySQLProbe(t *testing.T) {
+	packet := func(payload string) []byte {
+		n := len(payload)
+		return append([]byte{byte(n), byte(n >> 8), byte(n >> 16), 0}, payload...)
+	}
+
+	cases := []struct {
+		name   string
+		reply  []byte
+		result probe.Result
+		detail string
+	}{
+		{"greeting", packet("\x0a5.7.30-log\x00\x01\x00\x00\x00rest"), probe.Success, "version: 5.7.30-log"},
+		{"too many connections", packet("\xff\x10\x04Too many connections"), probe.Failure, "error 1040: Too many connections"},
+		{"incomplete", []byte{50, 0, 0, 0, 10}, probe.Failure, "incomplete greeting"},
+	}
+
+	for _, c := range cases {
+		reply := c.reply
+		addr, stop := serve(t, func(conn net.Conn) { conn.Write(reply) })
+		result, detail, err := DoMySQLProbe(addr, time.Second)
+		stop()
+		if err != nil || result != c.result || !strings.Contains(detail, c.detail) {
+			t.Errorf("%s: expected %v %q, got %v %q %v", c.name, c.result, c.detail, result, detail, err)
+		}
+	}
+}
+
+func TestEtcdProbe(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			fmt.Fprint(w, `{"health": "true"}`)
+		}
+	}))
+	defer healthy.Close()
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `{"health": "false", "reason": "NOSPACE"}`)
+	}))
+	defer unhealthy.Close()
+
+	cases := []struct {
+		endpoints []string
+		result    probe.Result
+	}{
+		{[]string{healthy.URL, strings.TrimPrefix(healthy.URL, "http://")}, probe.Success},
+		{[]string{healthy.URL, unhealthy.URL}, probe.Warning},
+		{[]string{unhealthy.URL, "127.0.0.1:1"}, probe.Failure},
+	}
+
+	for _, c := range cases {
+		result, detail, err := DoEtcdProbe(etcd.Config{Endpoints: c.endpoints}, time.Second)
+		if err != nil || result != c.result {
+			t.Errorf("%v: expected %v, got %v %q %v", c.endpoints, c.result, result, detail, err)
+		}
+	}
+
+	if _, _, err := DoEtcdProbe(etcd.Config{}, time.Second); err == nil {
+		t.Errorf("expected error without endpoints")
+	}
+}
@@ -0,0 +1,78 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"we.com/jiabiao/common/etcd"
+	utilnet "we.com/jiabiao/common/net"
+	"we.com/jiabiao/common/probe"
+)
+
+// DoEtcdProbe gets /health of every endpoint of cfg, with the tls config of cfg.
+// It returns Success if all of them are healthy, Failure if none of them is,
+// Warning otherwise, the detail lists the unhealthy endpoints
+func DoEtcdProbe(cfg etcd.Config, timeout time.Duration) (probe.Result, string, error) {
+	if len(cfg.Endpoints) == 0 {
+		return probe.Unknown, "", fmt.Errorf("etcd probe: no endpoints")
+	}
+
+	transport := utilnet.SetTransportDefaults(&http.Transport{TLSClientConfig: cfg.TLS, DisableKeepAlives: true})
+	client := &http.Client{Timeout: timeout, Transport: transport}
+
+	var results []probe.Result
+	var unhealthy []string
+	for _, ep := range cfg.Endpoints {
+		if err := etcdHealth(client, ep); err != nil {
+			results = append(results, probe.Failure)
+			unhealthy = append(unhealthy, fmt.Sprintf("%s: %v", ep, err))
+			continue
+		}
+		results = append(results, probe.Success)
+	}
+
+	result := probe.PartialFailure(results)
+	if len(unhealthy) == 0 {
+		return result, fmt.Sprintf("etcd: %d endpoints healthy", len(results)), nil
+	}
+	sort.Strings(unhealthy)
+	return result, "etcd unhealthy " + strings.Join(unhealthy, "; "), nil
+}
+
+func etcdHealth(client *http.Client, endpoint string) error {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	resp, err := client.Get(strings.TrimSuffix(endpoint, "/") + "/health")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxReply))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code %d: %s", resp.StatusCode, b)
+	}
+
+	// {"health": "true"} or {"health": true}, newer versions have reason
+	var health struct {
+		Health interface{} `json:"health"`
+		Reason string      `json:"reason"`
+	}
+	if err := json.Unmarshal(b, &health); err != nil {
+		return fmt.Errorf("invalid response %q: %v", b, err)
+	}
+	if fmt.Sprint(health.Health) != "true" {
+		return fmt.Errorf("health: %v %s", health.Health, health.Reason)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"we.com/jiabiao/common/probe"
+)
+
+// DoMySQLProbe reads the handshake greeting of the mysql server at addr, without authentication.
+// It returns Failure if the server replies an error packet, e.g. too many connections or host blocked,
+// and Success with the server version otherwise
+func DoMySQLProbe(addr string, timeout time.Duration) (probe.Result, string, error) {
+	var packet []byte
+	reply, err := exchange(addr, nil, timeout, func(reply []byte) bool {
+		if len(reply) < 4 {
+			return false
+		}
+		size := int(reply[0]) | int(reply[1])<<8 | int(reply[2])<<16
+		if len(reply) < 4+size {
+			return false
+		}
+		packet = reply[4 : 4+size]
+		return true
+	})
+	if packet == nil {
+		return probe.Failure, fmt.Sprintf("mysql %s: incomplete greeting %q: %v", addr, reply, err), nil
+	}
+
+	return parseGreeting(addr, packet)
+}
+
+func parseGreeting(addr string, packet []byte) (probe.Result, string, error) {
+	if len(packet) == 0 {
+		return probe.Failure, fmt.Sprintf("mysql %s: empty greeting", addr), nil
+	}
+
+	switch packet[0] {
+	case 0xff:
+		// error packet: 0xff, 2 bytes error code, message
+		if len(packet) < 3 {
+			return probe.Failure, fmt.Sprintf("mysql %s: invalid error packet", addr), nil
+		}
+		code := binary.LittleEndian.Uint16(packet[1:3])
+		return probe.Failure, fmt.Sprintf("mysql %s: error %d: %s", addr, code, packet[3:]), nil
+	case 10:
+		// protocol version 10, followed by null terminated server version
+		version := packet[1:]
+		if i := bytes.IndexByte(version, 0); i >= 0 {
+			version = version[:i]
+		}
+		return probe.Success, fmt.Sprintf("mysql %s: version: %s", addr, version), nil
+	}
+	return probe.Failure, fmt.Sprintf("mysql %s: unsupported protocol version %d", addr, packet[0]), nil
+}
@@ -0,0 +1,120 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"we.com/jiabiao/common/probe"
+)
+
+// DoRedisProbe sends PING, and INFO replication to the redis server at addr, AUTH first if
+// password is not empty. It returns Failure if PING is not answered with PONG, Warning if the server
+// is a replica whose link to the master is down, Success otherwise with the role in detail
+func DoRedisProbe(addr, password string, timeout time.Duration) (probe.Result, string, error) {
+	var payload bytes.Buffer
+	replies := 2
+	if password != "" {
+		payload.Write(redisCommand("AUTH", password))
+		replies++
+	}
+	payload.Write(redisCommand("PING"))
+	payload.Write(redisCommand("INFO", "replication"))
+
+	var parsed []string
+	var perr error
+	reply, err := exchange(addr, payload.Bytes(), timeout, func(reply []byte) bool {
+		parsed, perr = parseRedisReplies(reply, replies)
+		return perr != nil || len(parsed) == replies
+	})
+	if perr != nil {
+		return probe.Failure, fmt.Sprintf("redis %s: %v", addr, perr), nil
+	}
+	if len(parsed) < replies {
+		if err == nil {
+			err = fmt.Errorf("incomplete reply: %q", reply)
+		}
+		return probe.Failure, fmt.Sprintf("redis %s: %v", addr, err), nil
+	}
+	glog.V(4).Infof("redis %s replies: %q", addr, parsed)
+
+	for _, r := range parsed[:replies-1] {
+		if strings.HasPrefix(r, "-") {
+			return probe.Failure, fmt.Sprintf("redis %s: %s", addr, r[1:]), nil
+		}
+	}
+	if parsed[replies-2] != "+PONG" {
+		return probe.Failure, fmt.Sprintf("redis %s: unexpected reply to PING: %s", addr, parsed[replies-2]), nil
+	}
+
+	info := map[string]string{}
+	for _, line := range strings.Split(parsed[replies-1], "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(kv) == 2 {
+			info[kv[0]] = kv[1]
+		}
+	}
+
+	role := info["role"]
+	if role == "slave" {
+		detail := fmt.Sprintf("redis %s: role: slave, master: %s:%s, link: %s",
+			addr, info["master_host"], info["master_port"], info["master_link_status"])
+		if info["master_link_status"] != "up" {
+			return probe.Warning, detail, nil
+		}
+		return probe.Success, detail, nil
+	}
+	return probe.Success, fmt.Sprintf("redis %s: role: %s, connected slaves: %s", addr, role, info["connected_slaves"]), nil
+}
+
+// redisCommand encodes a command as a RESP array of bulk strings
+func redisCommand(args ...string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	return b.Bytes()
+}
+
+// parseRedisReplies parse at most n simple strings, errors or bulk strings from data,
+// incomplete replies at the end are ignored
+func parseRedisReplies(data []byte, n int) ([]string, error) {
+	var ret []string
+	for len(ret) < n {
+		i := bytes.Index(data, []byte("\r\n"))
+		if i < 0 {
+			return ret, nil
+		}
+		line := string(data[:i])
+		data = data[i+2:]
+
+		if line == "" {
+			return ret, fmt.Errorf("empty reply")
+		}
+		switch line[0] {
+		case '+', '-', ':':
+			ret = append(ret, line)
+		case '$':
+			size, err := strconv.Atoi(line[1:])
+			if err != nil {
+				return ret, fmt.Errorf("invalid bulk string length: %q", line)
+			}
+			if size < 0 {
+				ret = append(ret, "")
+				continue
+			}
+			if len(data) < size+2 {
+				return ret, nil
+			}
+			ret = append(ret, string(data[:size]))
+			data = data[size+2:]
+		default:
+			return ret, fmt.Errorf("unexpected reply: %q", line)
+		}
+	}
+	return ret, nil
+}
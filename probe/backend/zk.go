@@ -0,0 +1,51 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/samuel/go-zookeeper/zk"
+	"we.com/jiabiao/common/probe"
+)
+
+// DoZKProbe checks the zookeeper server at addr replies imok to ruok, then reports its
+// state with mntr. It returns Failure if the server is not serving requests, e.g. out of the quorum,
+// and Warning if mntr is not allowed by the server's four letter words whitelist
+func DoZKProbe(addr string, timeout time.Duration) (probe.Result, string, error) {
+	if oks := zk.FLWRuok([]string{addr}, timeout); !oks[0] {
+		return probe.Failure, fmt.Sprintf("zookeeper %s: ruok is not answered with imok", addr), nil
+	}
+
+	reply, err := exchange(addr, []byte("mntr"), timeout, nil)
+	if err != nil {
+		return probe.Warning, fmt.Sprintf("zookeeper %s: imok, mntr: %v", addr, err), nil
+	}
+	glog.V(4).Infof("zookeeper %s mntr: %s", addr, reply)
+
+	stats := parseMntr(string(reply))
+	if len(stats) == 0 {
+		msg := strings.TrimSpace(string(reply))
+		if strings.Contains(msg, "not currently serving") {
+			return probe.Failure, fmt.Sprintf("zookeeper %s: %s", addr, msg), nil
+		}
+		return probe.Warning, fmt.Sprintf("zookeeper %s: imok, mntr: %s", addr, msg), nil
+	}
+
+	return probe.Success, fmt.Sprintf("zookeeper %s: state: %s, version: %s, avg latency: %s, outstanding: %s, connections: %s",
+		addr, stats["zk_server_state"], stats["zk_version"], stats["zk_avg_latency"],
+		stats["zk_outstanding_requests"], stats["zk_num_alive_connections"]), nil
+}
+
+// parseMntr parse lines of key\tvalue
+func parseMntr(s string) map[string]string {
+	ret := map[string]string{}
+	for _, line := range strings.Split(s, "\n") {
+		kv := strings.SplitN(line, "\t", 2)
+		if len(kv) == 2 && strings.HasPrefix(kv[0], "zk_") {
+			ret[kv[0]] = strings.TrimSpace(kv[1])
+		}
+	}
+	return ret
+}
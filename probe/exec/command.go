@@ -0,0 +1,158 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	osexec "os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"we.com/jiabiao/common/probe"
+)
+
+// Spec of a command to probe
+type Spec struct {
+	// Command and its args, e.g. nagios plugins like ["check_disk", "-w", "20%"]
+	Command []string `json:"command"`
+	Dir     string   `json:"dir,omitempty"`
+	// Env if not empty, is the environment of the command
+	Env   []string `json:"env,omitempty"`
+	Stdin string   `json:"stdin,omitempty"`
+	// Timeout the command and all its children are killed after timeout
+	Timeout time.Duration `json:"-"`
+
+	// Nagios maps exit codes as nagios plugins do, see NagiosCodes
+	Nagios bool `json:"nagios,omitempty"`
+	// ExitCodes maps exit codes to results, codes not in the map are Failure, e.g.
+	// {0: green, 1: yellow}. It takes precedence over Nagios. If neither is set,
+	// codes of the prober are used
+	ExitCodes map[int]probe.Result `json:"exitCodes,omitempty"`
+}
+
+// codes returns the exit codes of the spec, nil if not set
+func (s *Spec) codes() map[int]probe.Result {
+	if s.ExitCodes != nil {
+		return s.ExitCodes
+	}
+	if s.Nagios {
+		return NagiosCodes
+	}
+	return nil
+}
+
+func init() {
+	probe.Register("exec", probe.Factory{
+		NewSpec: func() probe.Spec { return &Spec{} },
+		Prober:  specProber{New().(execProber)},
+	})
+}
+
+// specProber implements probe.Interface
+type specProber struct {
+	pr execProber
+}
+
+func (p specProber) Probe(ctx context.Context, spec probe.Spec) (probe.Result, string, error) {
+	s, ok := spec.(*Spec)
+	if !ok {
+		return probe.Unknown, "", fmt.Errorf("exec: unexpected spec %T", spec)
+	}
+	return p.pr.probeSpec(ctx, s)
+}
+
+// ProbeSpec runs the command of spec in its own process group, which is killed on timeout,
+// DefaultTimeout is used if spec.Timeout is not set. Exit code is mapped to result by codes of spec, or of the prober, a timeout is Failure
+func (pr execProber) ProbeSpec(spec *Spec) (probe.Result, string, error) {
+	return pr.probeSpec(context.Background(), spec)
+}
+
+func (pr execProber) probeSpec(ctx context.Context, spec *Spec) (probe.Result, string, error) {
+	if len(spec.Command) == 0 {
+		return probe.Unknown, "", fmt.Errorf("exec probe: command is empty")
+	}
+
+	cmd := osexec.Command(spec.Command[0], spec.Command[1:]...)
+	cmd.Dir = spec.Dir
+	cmd.Env = spec.Env
+	if spec.Stdin != "" {
+		cmd.Stdin = strings.NewReader(spec.Stdin)
+	}
+	out := &limitedBuffer{limit: pr.maxOutput}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.WaitDelay = waitDelay
+	setProcessGroup(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return probe.Unknown, "", err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	timeout := spec.Timeout
+	if _, ok := ctx.Deadline(); !ok && timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	// the shorter of timeout and the deadline of ctx
+	timeout = probe.Timeout(ctx, timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	timeout = timeout.Round(time.Millisecond)
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		if kerr := killProcessGroup(cmd); kerr != nil {
+			glog.Warningf("exec probe: kill %s: %v", spec.Command[0], kerr)
+		}
+		<-done
+		if ctx.Err() == context.DeadlineExceeded {
+			return probe.Failure, fmt.Sprintf("%s timeout after %v, output: %s", spec.Command[0], timeout, out), nil
+		}
+		return probe.Failure, fmt.Sprintf("%s %v, output: %s", spec.Command[0], ctx.Err(), out), nil
+	}
+
+	glog.V(4).Infof("Exec probe response: %q", out.String())
+	if err != nil {
+		// ErrWaitDelay the command exited, but the output is still held by others
+		if _, ok := err.(*osexec.ExitError); !ok && !errors.Is(err, osexec.ErrWaitDelay) {
+			return probe.Unknown, "", err
+		}
+	}
+	code := cmd.ProcessState.ExitCode()
+	if codes := spec.codes(); codes != nil {
+		return resultOf(codes, code), out.String(), nil
+	}
+	return pr.result(code), out.String(), nil
+}
+
+// limitedBuffer keeps the first limit bytes written, the rest are discarded
+type limitedBuffer struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.limit - b.buf.Len(); room < len(p) {
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,58 @@
+//go:build !windows
+// +build !windows
+
+package exec
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"we.com/jiabiao/common/probe"
+)
+
+func TestProbeSpec(t *testing.T) {
+	nagios := New(WithExitCodes(NagiosCodes), WithMaxOutput(16))
+	tests := []struct {
+		prober  ExecProber
+		script  string
+		timeout time.Duration
+		result  probe.Result
+		output  string
+	}{
+		{New(), "echo OK", 0, probe.Success, "OK\n"},
+		{New(), "echo fail >&2; exit 1", 0, probe.Failure, "fail\n"},
+		{nagios, "echo 'DISK OK'; exit 0", 0, probe.Success, "DISK OK\n"},
+		{nagios, "echo 'DISK WARNING'; exit 1", 0, probe.Warning, "DISK WARNING\n"},
+		{nagios, "echo 'DISK CRITICAL'; exit 2", 0, probe.Failure, "DISK CRITICAL\n"},
+		{nagios, "echo 'DISK UNKNOWN'; exit 3", 0, probe.Unknown, "DISK UNKNOWN\n"},
+		{nagios, "exit 4", 0, probe.Failure, ""},
+		{nagios, "echo 0123456789abcdefghij", 0, probe.Success, "0123456789abcdef"},
+		// the background sleep holds the output, it must be killed as well
+		{New(), "echo started; sleep 10 & sleep 10", 200 * time.Millisecond, probe.Failure, "timeout after 200ms, output: started"},
+		{New(), "setsid sleep 10 & echo done", 0, probe.Success, "done\n"},
+		// a process left the group still holds the output after the group is killed
+		{New(), "echo started; setsid sleep 10 & sleep 10", 200 * time.Millisecond, probe.Failure, "timeout after 200ms, output: started"},
+	}
+
+	for _, tt := range tests {
+		start := time.Now()
+		result, output, err := tt.prober.ProbeSpec(&Spec{Command: []string{"sh", "-c", tt.script}, Timeout: tt.timeout})
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.script, err)
+		}
+		if result != tt.result {
+			t.Errorf("%q: expected %v, got %v", tt.script, tt.result, result)
+		}
+		if !strings.Contains(output, tt.output) {
+			t.Errorf("%q: expected output %q, got %q", tt.script, tt.output, output)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%q: took %v", tt.script, elapsed)
+		}
+	}
+
+	if result, _, err := New().ProbeSpec(&Spec{Command: []string{"/no/such/command"}}); result != probe.Unknown || err == nil {
+		t.Errorf("expected unknown with error, got %v %v", result, err)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build windows
+// +build windows
+
+package exec
+
+import (
+	osexec "os/exec"
+)
+
+func setProcessGroup(cmd *osexec.Cmd) {}
+
+// killProcessGroup only kills the command itself on windows
+func killProcessGroup(cmd *osexec.Cmd) error {
+	return cmd.Process.Kill()
+}
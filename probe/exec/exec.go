@@ -17,35 +17,102 @@ limitations under the License.
 package exec
 
 import (
+	"time"
+
 	"we.com/jiabiao/common/exec"
 	"we.com/jiabiao/common/probe"
 
 	"github.com/golang/glog"
 )
 
-func New() ExecProber {
-	return execProber{}
+const (
+	// DefaultMaxOutput max bytes of output captured
+	DefaultMaxOutput = 64 * 1024
+	// DefaultTimeout of a spec probe, if neither spec.Timeout nor a deadline of ctx is set
+	DefaultTimeout = 10 * time.Second
+	// waitDelay how long to wait for the output after the command exits or is killed,
+	// processes left the group, e.g. by setsid, may still hold it
+	waitDelay = time.Second
+)
+
+// NagiosCodes maps exit codes of nagios plugins: 0 OK, 1 Warning, 2 Critical, 3 Unknown
+var NagiosCodes = map[int]probe.Result{
+	0: probe.Success,
+	1: probe.Warning,
+	2: probe.Failure,
+	3: probe.Unknown,
+}
+
+// Option of the exec prober
+type Option func(*execProber)
+
+// WithExitCodes maps exit codes to results, codes not in the map are Failure.
+// By default 0 is Success and others are Failure
+func WithExitCodes(codes map[int]probe.Result) Option {
+	return func(pr *execProber) {
+		pr.codes = codes
+	}
+}
+
+// WithMaxOutput max bytes of output captured, the rest is discarded
+func WithMaxOutput(n int) Option {
+	return func(pr *execProber) {
+		pr.maxOutput = n
+	}
+}
+
+func New(opts ...Option) ExecProber {
+	pr := execProber{maxOutput: DefaultMaxOutput}
+	for _, o := range opts {
+		o(&pr)
+	}
+	return pr
 }
 
 type ExecProber interface {
 	Probe(e exec.Cmd) (probe.Result, string, error)
+	ProbeSpec(spec *Spec) (probe.Result, string, error)
 }
 
-type execProber struct{}
+type execProber struct {
+	codes     map[int]probe.Result
+	maxOutput int
+}
 
+// Probe runs e, stdout and stderr of e are replaced, only the first max output bytes are kept
 func (pr execProber) Probe(e exec.Cmd) (probe.Result, string, error) {
-	data, err := e.CombinedOutput()
-	glog.V(4).Infof("Exec probe response: %q", string(data))
+	out := &limitedBuffer{limit: pr.maxOutput}
+	e.SetStdout(out)
+	e.SetStderr(out)
+	err := e.Run()
+	data := out.String()
+	glog.V(4).Infof("Exec probe response: %q", data)
 	if err != nil {
 		exit, ok := err.(exec.ExitError)
 		if ok {
-			if exit.ExitStatus() == 0 {
-				return probe.Success, string(data), nil
-			} else {
-				return probe.Failure, string(data), nil
-			}
+			return pr.result(exit.ExitStatus()), data, nil
 		}
 		return probe.Unknown, "", err
 	}
-	return probe.Success, string(data), nil
+	return pr.result(0), data, nil
+}
+
+// result maps exit code to result with codes of the prober
+func (pr execProber) result(code int) probe.Result {
+	return resultOf(pr.codes, code)
+}
+
+// resultOf maps exit code to result, codes not in the map are Failure,
+// if codes is nil, 0 is Success and others are Failure
+func resultOf(codes map[int]probe.Result, code int) probe.Result {
+	if codes == nil {
+		if code == 0 {
+			return probe.Success
+		}
+		return probe.Failure
+	}
+	if r, ok := codes[code]; ok {
+		return r
+	}
+	return probe.Failure
 }
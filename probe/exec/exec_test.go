@@ -28,6 +28,7 @@ type FakeCmd struct {
 	out    []byte
 	stdout []byte
 	err    error
+	writer io.Writer
 }
 
 func (f *FakeCmd) CombinedOutput() ([]byte, error) {
@@ -38,11 +39,20 @@ func (f *FakeCmd) Output() ([]byte, error) {
 	return f.stdout, f.err
 }
 
+func (f *FakeCmd) Run() error {
+	if f.writer != nil {
+		f.writer.Write(f.out)
+	}
+	return f.err
+}
+
 func (f *FakeCmd) SetDir(dir string) {}
 
 func (f *FakeCmd) SetStdin(in io.Reader) {}
 
-func (f *FakeCmd) SetStdout(out io.Writer) {}
+func (f *FakeCmd) SetStdout(out io.Writer) { f.writer = out }
+
+func (f *FakeCmd) SetStderr(out io.Writer) { f.writer = out }
 
 type fakeExitError struct {
 	exited     bool
@@ -102,4 +112,10 @@ func TestExec(t *testing.T) {
 			t.Errorf("[%d] expected %s, got %s", i, test.output, output)
 		}
 	}
+
+	// output is truncated while written
+	fake := FakeCmd{out: []byte("0123456789")}
+	if _, output, _ := New(WithMaxOutput(4)).Probe(&fake); output != "0123" {
+		t.Errorf("expected truncated output, got %q", output)
+	}
 }
@@ -0,0 +1,166 @@
+// Package grpc probes services implementing the grpc health checking protocol,
+// see https://github.com/grpc/grpc/blob/master/doc/health-checking.md
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"we.com/jiabiao/common/probe"
+)
+
+// Option of the grpc prober
+type Option func(*grpcProber)
+
+// WithTLS connect with tls, plaintext is used by default
+func WithTLS(config *tls.Config) Option {
+	return func(pr *grpcProber) {
+		pr.tlsConfig = config
+	}
+}
+
+// WithMetadata metadata sent with the health requests, e.g. auth tokens
+func WithMetadata(md map[string]string) Option {
+	return func(pr *grpcProber) {
+		pr.md = metadata.New(md)
+	}
+}
+
+// WithWarningLatency a SERVING response which takes longer than d returns Warning
+func WithWarningLatency(d time.Duration) Option {
+	return func(pr *grpcProber) {
+		pr.warningLatency = d
+	}
+}
+
+func New(opts ...Option) GRPCProber {
+	pr := grpcProber{}
+	for _, o := range opts {
+		o(&pr)
+	}
+	return pr
+}
+
+type GRPCProber interface {
+	// Probe calls Check of service at addr, empty service means health of the whole server
+	Probe(addr, service string, timeout time.Duration) (probe.Result, string, error)
+	// Watch calls Watch of service at addr, results are sent whenever the status changes,
+	// the channel is closed when ctx is done or the stream is broken, after a Failure sent
+	Watch(ctx context.Context, addr, service string) (<-chan probe.Result, error)
+}
+
+type grpcProber struct {
+	tlsConfig      *tls.Config
+	md             metadata.MD
+	warningLatency time.Duration
+}
+
+func (pr grpcProber) dial(ctx context.Context, addr string) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if pr.tlsConfig != nil {
+		creds = credentials.NewTLS(pr.tlsConfig)
+	}
+	return grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(creds), grpc.WithBlock())
+}
+
+func (pr grpcProber) outgoing(ctx context.Context) context.Context {
+	if len(pr.md) == 0 {
+		return ctx
+	}
+	return metadata.NewOutgoingContext(ctx, pr.md)
+}
+
+// Probe returns Success if the service is SERVING, Unknown if UNKNOWN, Failure otherwise.
+// Connection and rpc errors are converted into failures, like the http probe
+func (pr grpcProber) Probe(addr, service string, timeout time.Duration) (probe.Result, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := pr.dial(ctx, addr)
+	if err != nil {
+		return probe.Failure, fmt.Sprintf("dial %s: %v", addr, err), nil
+	}
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(pr.outgoing(ctx), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return probe.Failure, rpcError(err), nil
+	}
+
+	result, output := mapStatus(resp.Status)
+	glog.V(4).Infof("grpc probe %s %q: %v", addr, service, resp.Status)
+	return probe.CheckLatency(result, output, nil, time.Since(start), pr.warningLatency)
+}
+
+func (pr grpcProber) Watch(ctx context.Context, addr, service string) (<-chan probe.Result, error) {
+	conn, err := pr.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := healthpb.NewHealthClient(conn).Watch(pr.outgoing(ctx), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	ch := make(chan probe.Result, 1)
+	go func() {
+		defer close(ch)
+		defer conn.Close()
+		for {
+			resp, err := stream.Recv()
+			result := probe.Failure
+			if err == nil {
+				result, _ = mapStatus(resp.Status)
+			} else if ctx.Err() != nil {
+				return
+			} else {
+				glog.V(4).Infof("grpc watch %s %q: %v", addr, service, rpcError(err))
+			}
+
+			select {
+			case ch <- result:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+func mapStatus(s healthpb.HealthCheckResponse_ServingStatus) (probe.Result, string) {
+	switch s {
+	case healthpb.HealthCheckResponse_SERVING:
+		return probe.Success, s.String()
+	case healthpb.HealthCheckResponse_UNKNOWN:
+		return probe.Unknown, s.String()
+	}
+	return probe.Failure, fmt.Sprintf("grpc probe failed with status: %v", s)
+}
+
+func rpcError(err error) string {
+	st, _ := status.FromError(err)
+	switch st.Code() {
+	case codes.Unimplemented:
+		return "server does not implement the grpc health protocol"
+	case codes.NotFound:
+		return "service not found"
+	}
+	return fmt.Sprintf("health rpc failed: %v", err)
+}
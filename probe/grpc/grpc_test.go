@@ -0,0 +1,124 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"we.com/jiabiao/common/probe"
+)
+
+// startServer starts a grpc server with health service, requests without
+// metadata token=secret are rejected
+func startServer(t *testing.T) (string, *health.Server, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	auth := func(ctx context.Context) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if v := md.Get("token"); len(v) == 0 || v[0] != "secret" {
+			return status.Error(codes.Unauthenticated, "bad token")
+		}
+		return nil
+	}
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := auth(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := auth(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(server, hs)
+	go server.Serve(lis)
+
+	return lis.Addr().String(), hs, server.Stop
+}
+
+func TestGRPCProbe(t *testing.T) {
+	addr, hs, stop := startServer(t)
+	defer stop()
+
+	hs.SetServingStatus("ok", healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus("down", healthpb.HealthCheckResponse_NOT_SERVING)
+	hs.SetServingStatus("unknown", healthpb.HealthCheckResponse_UNKNOWN)
+
+	prober := New(WithMetadata(map[string]string{"token": "secret"}))
+	cases := []struct {
+		service string
+		result  probe.Result
+	}{
+		{"", probe.Success},
+		{"ok", probe.Success},
+		{"down", probe.Failure},
+		{"unknown", probe.Unknown},
+		{"missing", probe.Failure},
+	}
+	for _, c := range cases {
+		result, output, err := prober.Probe(addr, c.service, time.Second)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.service, err)
+		}
+		if result != c.result {
+			t.Errorf("%q: expected %v, got %v (%s)", c.service, c.result, result, output)
+		}
+	}
+
+	if result, output, _ := New().Probe(addr, "ok", time.Second); result != probe.Failure {
+		t.Errorf("expected failure without token, got %v (%s)", result, output)
+	}
+
+	if result, _, _ := prober.Probe("127.0.0.1:1", "", 200*time.Millisecond); result != probe.Failure {
+		t.Errorf("expected failure for closed port, got %v", result)
+	}
+}
+
+func TestGRPCWatch(t *testing.T) {
+	addr, hs, stop := startServer(t)
+	defer stop()
+	hs.SetServingStatus("svc", healthpb.HealthCheckResponse_SERVING)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ch, err := New(WithMetadata(map[string]string{"token": "secret"})).Watch(ctx, addr, "svc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expect := func(expected probe.Result) {
+		select {
+		case r, ok := <-ch:
+			if !ok || r != expected {
+				t.Fatalf("expected %v, got %v (open: %v)", expected, r, ok)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timeout waiting for %v", expected)
+		}
+	}
+
+	expect(probe.Success)
+	hs.SetServingStatus("svc", healthpb.HealthCheckResponse_NOT_SERVING)
+	expect(probe.Failure)
+	hs.SetServingStatus("svc", healthpb.HealthCheckResponse_SERVING)
+	expect(probe.Success)
+
+	cancel()
+	for range ch {
+	}
+}
@@ -3,6 +3,7 @@ package http
 import (
 	"crypto/tls"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -14,23 +15,41 @@ import (
 	"github.com/golang/glog"
 )
 
-func New() HTTPProber {
+// Option of the http prober
+type Option func(*httpProber)
+
+// WithWarningLatency a successful probe which takes longer than d returns Warning
+func WithWarningLatency(d time.Duration) Option {
+	return func(pr *httpProber) {
+		pr.warningLatency = d
+	}
+}
+
+func New(opts ...Option) HTTPProber {
 	tlsConfig := &tls.Config{InsecureSkipVerify: true}
 	transport := utilnet.SetTransportDefaults(&http.Transport{TLSClientConfig: tlsConfig, DisableKeepAlives: true})
-	return httpProber{transport}
+	pr := httpProber{transport: transport}
+	for _, o := range opts {
+		o(&pr)
+	}
+	return pr
 }
 
 type HTTPProber interface {
 	Probe(url *url.URL, headers http.Header, timeout time.Duration) (probe.Result, string, error)
+	ProbeSpec(spec *Spec) (probe.Result, string, error)
 }
 
 type httpProber struct {
-	transport *http.Transport
+	transport      *http.Transport
+	warningLatency time.Duration
 }
 
 // Probe returns a ProbeRunner capable of running an http check.
 func (pr httpProber) Probe(url *url.URL, headers http.Header, timeout time.Duration) (probe.Result, string, error) {
-	return DoHTTPProbe(url, headers, &http.Client{Timeout: timeout, Transport: pr.transport})
+	start := time.Now()
+	result, output, err := DoHTTPProbe(url, headers, &http.Client{Timeout: timeout, Transport: pr.transport})
+	return probe.CheckLatency(result, output, err, time.Since(start), pr.warningLatency)
 }
 
 type HTTPGetInterface interface {
@@ -57,7 +76,7 @@ func DoHTTPProbe(url *url.URL, headers http.Header, client HTTPGetInterface) (pr
 		return probe.Failure, err.Error(), nil
 	}
 	defer res.Body.Close()
-	b, err := ioutil.ReadAll(res.Body)
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, DefaultMaxBodySize))
 	if err != nil {
 		return probe.Failure, "", err
 	}
@@ -118,3 +118,28 @@ func TestHTTPProbeChecker(t *testing.T) {
 		}()
 	}
 }
+
+func TestHTTPProbeWarningLatency(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	prober := New(WithWarningLatency(50 * time.Millisecond))
+	for path, expected := range map[string]probe.Result{"/fast": probe.Success, "/slow": probe.Warning} {
+		u, _ := url.Parse(server.URL + path)
+		health, output, err := prober.Probe(u, http.Header{}, time.Second)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", path, err)
+		}
+		if health != expected {
+			t.Errorf("%s: expected %v, got %v", path, expected, health)
+		}
+		if health == probe.Warning && !strings.Contains(output, "slow response") {
+			t.Errorf("%s: expected reason, got %q", path, output)
+		}
+	}
+}
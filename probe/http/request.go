@@ -4,42 +4,18 @@ import (
 	"context"
 	"io"
 	"net/http"
-	"net/http/httptrace"
 	"time"
-)
 
-// TimeTrack  time track of the request
-type TimeTrack struct {
-	StartTime time.Time
-	DNSTime   time.Time
-	// Connect or get a connection from the pool
-	ConnTime time.Time
+	utilnet "we.com/jiabiao/common/net"
+)
 
-	RequestTime   time.Time
-	FirstByteTime time.Time
-	EndTime       time.Time
-}
+// TimeTrack  time track of the request, Download and Total are updated
+// when the response body is read through or closed
+type TimeTrack = utilnet.Timing
 
 // Request client: 对java服务不需要， 对php可能需要先认证
 func Request(ctx context.Context, client *http.Client, method, url string, header map[string]string, body io.Reader) (*http.Response, *TimeTrack, error) {
 
-	var tracetime TimeTrack
-
-	ct := httptrace.ClientTrace{
-		GotConn: func(info httptrace.GotConnInfo) {
-			tracetime.ConnTime = time.Now()
-		},
-		WroteRequest: func(info httptrace.WroteRequestInfo) {
-			tracetime.RequestTime = time.Now()
-		},
-		GotFirstResponseByte: func() {
-			tracetime.FirstByteTime = time.Now()
-		},
-		DNSDone: func(info httptrace.DNSDoneInfo) {
-			tracetime.DNSTime = time.Now()
-		},
-	}
-
 	req, err := http.NewRequest(method, url, body)
 	if err != nil {
 		return nil, nil, err
@@ -60,12 +36,11 @@ func Request(ctx context.Context, client *http.Client, method, url string, heade
 		client = http.DefaultClient
 	}
 
-	traceCtx := httptrace.WithClientTrace(ctx, &ct)
-
-	req = req.WithContext(traceCtx)
-	tracetime.StartTime = time.Now()
+	tracetime := utilnet.NewTiming()
+	req = req.WithContext(tracetime.WithClientTrace(ctx))
 	resp, err := client.Do(req)
-	tracetime.EndTime = time.Now()
+	tracetime.GotResponse(resp)
+	tracetime.TrackBody(resp)
 
-	return resp, &tracetime, err
+	return resp, tracetime, err
 }
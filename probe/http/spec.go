@@ -0,0 +1,276 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"we.com/jiabiao/common/jsonpath"
+	utilnet "we.com/jiabiao/common/net"
+	"we.com/jiabiao/common/probe"
+)
+
+const (
+	// DefaultMaxBodySize max bytes of the response body read by a spec probe
+	DefaultMaxBodySize = 1024 * 1024
+)
+
+// RedirectPolicy how redirects are handled
+type RedirectPolicy string
+
+const (
+	// RedirectFollow follow redirects, up to 10, default
+	RedirectFollow RedirectPolicy = "follow"
+	// RedirectNone do not follow, the 3xx response is checked
+	RedirectNone RedirectPolicy = "none"
+	// RedirectSameHost only follow redirects to the same host
+	RedirectSameHost RedirectPolicy = "same-host"
+)
+
+// TLSMode how the server certificate is verified
+type TLSMode string
+
+const (
+	// TLSInsecure skip verification, default
+	TLSInsecure TLSMode = "insecure"
+	// TLSVerify verify with the system roots, or CAFile/CAData if set
+	TLSVerify TLSMode = "verify"
+)
+
+// Spec of a http probe
+type Spec struct {
+	URL string `json:"url"`
+	// Method default to GET
+	Method  string      `json:"method,omitempty"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+	// Timeout of the whole request, when probed with a context, the deadline of it is also respected
+	Timeout time.Duration `json:"-"`
+
+	Redirect RedirectPolicy `json:"redirect,omitempty"`
+	// ExpectedStatus codes of success, default to 200 <= code < 400
+	ExpectedStatus []int `json:"expectedStatus,omitempty"`
+	// BodyRegexp if set, the body must match it
+	BodyRegexp string `json:"bodyRegexp,omitempty"`
+	// BodyJSONPath if set, the body must be json and the path, like {.status}, must exist;
+	// if BodyJSONValue is also set, the value must equal to it
+	BodyJSONPath  string `json:"bodyJSONPath,omitempty"`
+	BodyJSONValue string `json:"bodyJSONValue,omitempty"`
+	// MaxBodySize bytes of the body read and checked, the rest is ignored, default to DefaultMaxBodySize
+	MaxBodySize int64 `json:"maxBodySize,omitempty"`
+
+	TLSMode TLSMode `json:"tlsMode,omitempty"`
+	CAFile  string  `json:"caFile,omitempty"`
+	// CAData pem encoded ca certificates
+	CAData     string `json:"caData,omitempty"`
+	ServerName string `json:"serverName,omitempty"`
+}
+
+func init() {
+	probe.Register("http", probe.Factory{
+		NewSpec: func() probe.Spec { return &Spec{} },
+		Prober:  specProber{New().(httpProber)},
+	})
+}
+
+// specProber implements probe.Interface
+type specProber struct {
+	pr httpProber
+}
+
+func (p specProber) Probe(ctx context.Context, spec probe.Spec) (probe.Result, string, error) {
+	s, ok := spec.(*Spec)
+	if !ok {
+		return probe.Unknown, "", fmt.Errorf("http: unexpected spec %T", spec)
+	}
+	return p.pr.probeSpec(ctx, s)
+}
+
+// ProbeSpec probes as spec describes, invalid spec returns Unknown with an error,
+// request errors and unexpected responses are converted into failures
+func (pr httpProber) ProbeSpec(spec *Spec) (probe.Result, string, error) {
+	return pr.probeSpec(context.Background(), spec)
+}
+
+func (pr httpProber) probeSpec(ctx context.Context, spec *Spec) (probe.Result, string, error) {
+	if spec.URL == "" {
+		return probe.Unknown, "", fmt.Errorf("url is required")
+	}
+	if _, err := url.Parse(spec.URL); err != nil {
+		return probe.Unknown, "", fmt.Errorf("invalid url %q: %v", spec.URL, err)
+	}
+	client, err := pr.client(spec)
+	if err != nil {
+		return probe.Unknown, "", err
+	}
+	check, err := newBodyCheck(spec)
+	if err != nil {
+		return probe.Unknown, "", err
+	}
+
+	method := spec.Method
+	if method == "" {
+		method = "GET"
+	}
+	req, err := http.NewRequest(method, spec.URL, strings.NewReader(spec.Body))
+	if err != nil {
+		return probe.Unknown, "", err
+	}
+	req = req.WithContext(ctx)
+	if spec.Headers != nil {
+		req.Header = spec.Headers
+		if h := spec.Headers.Get("Host"); h != "" {
+			req.Host = h
+		}
+	}
+
+	start := time.Now()
+	res, err := client.Do(req)
+	if err != nil {
+		// Convert errors into failures to catch timeouts.
+		return probe.Failure, err.Error(), nil
+	}
+	defer res.Body.Close()
+
+	max := spec.MaxBodySize
+	if max <= 0 {
+		max = DefaultMaxBodySize
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, max))
+	if err != nil {
+		return probe.Failure, fmt.Sprintf("read body: %v", err), nil
+	}
+	body := string(b)
+	elapsed := time.Since(start)
+
+	if !expectedStatus(res.StatusCode, spec.ExpectedStatus) {
+		glog.V(4).Infof("Probe failed for %s with statuscode: %d, response body: %v", spec.URL, res.StatusCode, body)
+		return probe.Failure, fmt.Sprintf("HTTP probe failed with statuscode: %d", res.StatusCode), nil
+	}
+	if msg := check(body); msg != "" {
+		glog.V(4).Infof("Probe failed for %s: %s, response body: %v", spec.URL, msg, body)
+		return probe.Failure, msg, nil
+	}
+
+	return probe.CheckLatency(probe.Success, body, nil, elapsed, pr.warningLatency)
+}
+
+func expectedStatus(code int, expected []int) bool {
+	if len(expected) == 0 {
+		return code >= http.StatusOK && code < http.StatusBadRequest
+	}
+	for _, c := range expected {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// newBodyCheck returns a func, which returns why the body is not expected
+func newBodyCheck(spec *Spec) (func(body string) string, error) {
+	var re *regexp.Regexp
+	if spec.BodyRegexp != "" {
+		var err error
+		if re, err = regexp.Compile(spec.BodyRegexp); err != nil {
+			return nil, fmt.Errorf("invalid body regexp %q: %v", spec.BodyRegexp, err)
+		}
+	}
+
+	var jp *jsonpath.JSONPath
+	if spec.BodyJSONPath != "" {
+		jp = jsonpath.New("probe")
+		if err := jp.Parse(spec.BodyJSONPath); err != nil {
+			return nil, fmt.Errorf("invalid body jsonpath %q: %v", spec.BodyJSONPath, err)
+		}
+	}
+
+	return func(body string) string {
+		if re != nil && !re.MatchString(body) {
+			return fmt.Sprintf("body does not match %q", spec.BodyRegexp)
+		}
+		if jp == nil {
+			return ""
+		}
+
+		var data interface{}
+		if err := json.Unmarshal([]byte(body), &data); err != nil {
+			return fmt.Sprintf("body is not json: %v", err)
+		}
+		buf := bytes.Buffer{}
+		if err := jp.Execute(&buf, data); err != nil {
+			return fmt.Sprintf("body jsonpath %s: %v", spec.BodyJSONPath, err)
+		}
+		if spec.BodyJSONValue != "" && buf.String() != spec.BodyJSONValue {
+			return fmt.Sprintf("body jsonpath %s is %q, expected %q", spec.BodyJSONPath, buf.String(), spec.BodyJSONValue)
+		}
+		return ""
+	}, nil
+}
+
+func (pr httpProber) client(spec *Spec) (*http.Client, error) {
+	transport := pr.transport
+	switch spec.TLSMode {
+	case "", TLSInsecure:
+	case TLSVerify:
+		tlsConfig := &tls.Config{ServerName: spec.ServerName}
+		if spec.CAFile != "" || spec.CAData != "" {
+			pool, err := certPool(spec.CAFile, []byte(spec.CAData))
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = pool
+		}
+		transport = utilnet.SetTransportDefaults(&http.Transport{TLSClientConfig: tlsConfig, DisableKeepAlives: true})
+	default:
+		return nil, fmt.Errorf("unknown tls mode: %s", spec.TLSMode)
+	}
+
+	client := &http.Client{Timeout: spec.Timeout, Transport: transport}
+	switch spec.Redirect {
+	case "", RedirectFollow:
+	case RedirectNone:
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	case RedirectSameHost:
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			if req.URL.Host != via[0].URL.Host {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown redirect policy: %s", spec.Redirect)
+	}
+	return client, nil
+}
+
+func certPool(file string, data []byte) (*x509.CertPool, error) {
+	if file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file: %v", err)
+		}
+		data = append(append(append([]byte(nil), data...), '\n'), b...)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no valid ca certificate found")
+	}
+	return pool, nil
+}
@@ -0,0 +1,111 @@
+package http
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"we.com/jiabiao/common/probe"
+)
+
+func TestHTTPProbeSpec(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status": "ok", "version": "1.2.3"}`)
+	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Write(b)
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/status", http.StatusFound)
+	})
+	mux.HandleFunc("/away", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://127.0.0.1:1/", http.StatusFound)
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 100) + "needle"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	parse := func(path string) string {
+		return server.URL + path
+	}
+
+	prober := New()
+	cases := []struct {
+		name   string
+		spec   Spec
+		result probe.Result
+	}{
+		{"default", Spec{URL: parse("/status")}, probe.Success},
+		{"jsonpath", Spec{URL: parse("/status"), BodyJSONPath: "{.status}", BodyJSONValue: "ok"}, probe.Success},
+		{"jsonpath mismatch", Spec{URL: parse("/status"), BodyJSONPath: "{.status}", BodyJSONValue: "down"}, probe.Failure},
+		{"jsonpath missing", Spec{URL: parse("/status"), BodyJSONPath: "{.missing}"}, probe.Failure},
+		{"regexp", Spec{URL: parse("/status"), BodyRegexp: `"version": "1\.`}, probe.Success},
+		{"regexp mismatch", Spec{URL: parse("/status"), BodyRegexp: `"version": "2\.`}, probe.Failure},
+		{"post", Spec{URL: parse("/echo"), Method: "POST", Body: "ping", BodyRegexp: "^ping$"}, probe.Success},
+		{"get not allowed", Spec{URL: parse("/echo")}, probe.Failure},
+		{"expected status", Spec{URL: parse("/echo"), ExpectedStatus: []int{405}}, probe.Success},
+		{"redirect follow", Spec{URL: parse("/redirect"), ExpectedStatus: []int{200}}, probe.Success},
+		{"redirect none", Spec{URL: parse("/redirect"), Redirect: RedirectNone, ExpectedStatus: []int{200}}, probe.Failure},
+		{"redirect none 302", Spec{URL: parse("/redirect"), Redirect: RedirectNone, ExpectedStatus: []int{302}}, probe.Success},
+		{"redirect same host", Spec{URL: parse("/away"), Redirect: RedirectSameHost, ExpectedStatus: []int{302}}, probe.Success},
+		{"max body size", Spec{URL: parse("/large"), MaxBodySize: 100, BodyRegexp: "needle"}, probe.Failure},
+		{"invalid regexp", Spec{URL: parse("/status"), BodyRegexp: "("}, probe.Unknown},
+		{"invalid redirect", Spec{URL: parse("/status"), Redirect: "sometimes"}, probe.Unknown},
+	}
+
+	for _, c := range cases {
+		c.spec.Timeout = time.Second
+		result, output, err := prober.ProbeSpec(&c.spec)
+		if result != c.result {
+			t.Errorf("%s: expected %v, got %v (%s, %v)", c.name, c.result, result, output, err)
+		}
+		if (result == probe.Unknown) != (err != nil) {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+		}
+	}
+}
+
+func TestHTTPProbeSpecTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	u := server.URL
+	ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	prober := New()
+	cases := []struct {
+		name   string
+		spec   Spec
+		result probe.Result
+	}{
+		{"insecure", Spec{URL: u}, probe.Success},
+		{"verify unknown authority", Spec{URL: u, TLSMode: TLSVerify}, probe.Failure},
+		{"verify with ca", Spec{URL: u, TLSMode: TLSVerify, CAData: ca}, probe.Success},
+		{"verify server name", Spec{URL: u, TLSMode: TLSVerify, CAData: ca, ServerName: "example.com"}, probe.Success},
+		{"verify wrong server name", Spec{URL: u, TLSMode: TLSVerify, CAData: ca, ServerName: "example.org"}, probe.Failure},
+		{"invalid ca", Spec{URL: u, TLSMode: TLSVerify, CAData: "junk"}, probe.Unknown},
+	}
+
+	for _, c := range cases {
+		c.spec.Timeout = time.Second
+		result, output, err := prober.ProbeSpec(&c.spec)
+		if result != c.result {
+			t.Errorf("%s: expected %v, got %v (%s, %v)", c.name, c.result, result, output, err)
+		}
+	}
+}
@@ -3,19 +3,30 @@ package java
 import (
 	"context"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/golang/glog"
 	"github.com/pkg/errors"
 	"we.com/jiabiao/common/probe"
 	phttp "we.com/jiabiao/common/probe/http"
+	mytime "we.com/jiabiao/common/time"
 	"we.com/jiabiao/common/yaml"
 )
 
 const (
 	// DefaultRespSize max  bytes read from response for an probe
 	DefaultRespSize = 1024 * 1024
+
+	// DefaultTimeout deadline of a batch probe
+	DefaultTimeout = 10 * time.Second
+
+	// DefaultConcurrency max number of targets probed at the same time
+	DefaultConcurrency = 10
 )
 
 // Args args config when probe
@@ -28,7 +39,72 @@ type Args struct {
 	MaxRespSize int64
 }
 
-// Probe implements probe.Prober interface
+// Result java probe result
+type Result struct {
+	Name   string
+	Result probe.Result
+	// Data is the response, at most Args.MaxRespSize bytes
+	Data      string
+	TimeTrack *phttp.TimeTrack
+	// Err is why the probe failed, or the reason of Warning
+	Err error
+}
+
+// BatchProber post to a batch of targets, and checks their responses
+// follow the status/success/fail contract
+type BatchProber interface {
+	// Probe returns results keyed by Args.Name
+	Probe(ctx context.Context, args []*Args) map[string]*Result
+}
+
+// Option of the batch prober
+type Option func(*batchProber)
+
+// WithTimeout deadline of the whole batch, targets not finished are failed
+func WithTimeout(timeout time.Duration) Option {
+	return func(p *batchProber) {
+		p.timeout = timeout
+	}
+}
+
+// WithConcurrency max number of targets probed at the same time
+func WithConcurrency(n int) Option {
+	return func(p *batchProber) {
+		p.concurrency = n
+	}
+}
+
+// WithWarningLatency a successful target which takes longer than d is Warning
+func WithWarningLatency(d time.Duration) Option {
+	return func(p *batchProber) {
+		p.warningLatency = d
+	}
+}
+
+// WithClient http client used to send requests, default to http.DefaultClient
+func WithClient(client *http.Client) Option {
+	return func(p *batchProber) {
+		p.client = client
+	}
+}
+
+// New create a batch prober
+func New(opts ...Option) BatchProber {
+	p := &batchProber{}
+	for _, o := range opts {
+		o(p)
+	}
+	if p.timeout <= 0 {
+		p.timeout = DefaultTimeout
+	}
+	if p.concurrency <= 0 {
+		p.concurrency = DefaultConcurrency
+	}
+	return p
+}
+
+// Probe implements probe.Prober interface, the load generator must return []*Args,
+// it returns Warning if some of the targets failed, Failure if all of them failed
 func Probe(lg probe.LoadGenerator) (probe.Result, string, error) {
 	dat := lg()
 	if dat == nil {
@@ -40,102 +116,173 @@ func Probe(lg probe.LoadGenerator) (probe.Result, string, error) {
 		return probe.Failure, "", errors.New("java probe: load generator must return data of type Java Args")
 	}
 
-	result := probe0(args)
+	results := New().Probe(context.Background(), args)
+	result, msg := Summarize(results, probe.PartialFailure)
+	return result, msg, nil
+}
 
-	for _, r := range result {
-		if r.err != nil {
-			return probe.Failure, "", r.err
-		}
-		if r.Result == probe.Failure {
-			return probe.Failure, "", r.err
+// Summarize combines results with rule,
+// the message lists the targets not succeed and why
+func Summarize(results map[string]*Result, rule probe.AggregateRule) (probe.Result, string) {
+	var rs []probe.Result
+	var reasons []string
+	for name, r := range results {
+		rs = append(rs, r.Result)
+		if r.Result != probe.Success {
+			reason := string(r.Result)
+			if r.Err != nil {
+				reason = r.Err.Error()
+			}
+			reasons = append(reasons, name+": "+reason)
 		}
 	}
+	sort.Strings(reasons)
+	return rule(rs), strings.Join(reasons, "; ")
+}
 
-	return probe.Success, "", nil
+// Target of a java probe spec
+type Target struct {
+	Name        string            `json:"name"`
+	Cluster     string            `json:"cluster,omitempty"`
+	URL         string            `json:"url"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Data        string            `json:"data,omitempty"`
+	MaxRespSize int64             `json:"maxRespSize,omitempty"`
 }
 
-// Result java probe result
-type Result struct {
-	Name      string
-	Result    probe.Result
-	data      string
-	TimeTrack *phttp.TimeTrack
-	err       error
+// Spec of a java probe, targets are probed as a batch, and the results
+// are combined with probe.PartialFailure
+type Spec struct {
+	Targets        []Target        `json:"targets"`
+	Concurrency    int             `json:"concurrency,omitempty"`
+	WarningLatency mytime.Duration `json:"warningLatency,omitempty"`
+}
+
+func init() {
+	probe.Register("java", probe.Factory{
+		NewSpec: func() probe.Spec { return &Spec{} },
+		Prober:  specProber{},
+	})
 }
 
-func probe0(args []*Args) map[string]*Result {
+// specProber implements probe.Interface
+type specProber struct{}
+
+func (specProber) Probe(ctx context.Context, spec probe.Spec) (probe.Result, string, error) {
+	s, ok := spec.(*Spec)
+	if !ok {
+		return probe.Unknown, "", errors.Errorf("java: unexpected spec %T", spec)
+	}
+	if len(s.Targets) == 0 {
+		return probe.Unknown, "", errors.New("java probe: no targets")
+	}
+
+	args := make([]*Args, 0, len(s.Targets))
+	for _, t := range s.Targets {
+		args = append(args, &Args{
+			Name:        t.Name,
+			Cluster:     t.Cluster,
+			URL:         t.URL,
+			Headers:     t.Headers,
+			Data:        strings.NewReader(t.Data),
+			MaxRespSize: t.MaxRespSize,
+		})
+	}
+
+	p := New(WithConcurrency(s.Concurrency), WithWarningLatency(time.Duration(s.WarningLatency)))
+	result, msg := Summarize(p.Probe(ctx, args), probe.PartialFailure)
+	return result, msg, nil
+}
+
+type batchProber struct {
+	timeout        time.Duration
+	concurrency    int
+	client         *http.Client
+	warningLatency time.Duration
+}
+
+func (p *batchProber) Probe(ctx context.Context, args []*Args) map[string]*Result {
+	ret := make(map[string]*Result, len(args))
 	if len(args) == 0 {
-		return nil
+		return ret
 	}
-	ctx, cf := context.WithCancel(context.Background())
-	time.AfterFunc(10*time.Second, cf)
 
-	resultC := make(chan *Result, 1)
-	defer close(resultC)
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var mu sync.Mutex
 	var wg sync.WaitGroup
+	sem := make(chan struct{}, p.concurrency)
 
-	p := func(args *Args) {
+	for _, a := range args {
 		wg.Add(1)
-		defer wg.Done()
-		ps := Result{
-			Name:   args.Name,
-			Result: probe.Failure,
-		}
-		resp, tt, err := phttp.Request(ctx, nil, "POST", args.URL, args.Headers, args.Data)
-		ps.err = err
-		ps.TimeTrack = tt
-		if err != nil {
-			resultC <- &ps
-			return
-		}
+		go func(a *Args) {
+			defer wg.Done()
 
-		if args.MaxRespSize <= 0 {
-			args.MaxRespSize = DefaultRespSize
-		}
+			var r *Result
+			select {
+			case sem <- struct{}{}:
+				r = p.probeOne(ctx, a)
+				<-sem
+			case <-ctx.Done():
+				r = &Result{Name: a.Name, Result: probe.Failure, Err: ctx.Err()}
+			}
 
-		size := resp.ContentLength
-		// read at most args.MaxRespSize data from response
-		if size > args.MaxRespSize {
-			size = args.MaxRespSize
-			ps.err = errors.Errorf("response to large: %v", size)
-		}
+			glog.V(4).Infof("java probe %s: %v, err: %v", a.Name, r.Result, r.Err)
+			mu.Lock()
+			ret[a.Name] = r
+			mu.Unlock()
+		}(a)
+	}
 
-		content := make([]byte, size)
-		_, err = io.ReadFull(resp.Body, content)
-		ps.data = string(content)
-		if err != nil {
-			ps.err = err
-			return
-		}
+	wg.Wait()
 
-		if ps.err != nil {
-			ps.Result = checkResp(ps.data)
-		}
+	return ret
+}
 
-		resultC <- &ps
+func (p *batchProber) probeOne(ctx context.Context, args *Args) *Result {
+	ps := &Result{
+		Name:   args.Name,
+		Result: probe.Failure,
 	}
 
-	ret := map[string]*Result{}
+	resp, tt, err := phttp.Request(ctx, p.client, "POST", args.URL, args.Headers, args.Data)
+	ps.TimeTrack = tt
+	if err != nil {
+		ps.Err = err
+		return ps
+	}
+	defer resp.Body.Close()
 
-	go func() {
-		for {
-			select {
-			case r, ok := <-resultC:
-				if !ok {
-					return
-				}
-				ret[r.Name] = r
-			}
-		}
-	}()
+	max := args.MaxRespSize
+	if max <= 0 {
+		max = DefaultRespSize
+	}
 
-	for _, a := range args {
-		go p(a)
+	// read at most max bytes data from response
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, max+1))
+	if int64(len(content)) > max {
+		ps.Data = string(content[:max])
+		ps.Err = errors.Errorf("response too large, more than %d bytes", max)
+		return ps
+	}
+	ps.Data = string(content)
+	if err != nil {
+		ps.Err = errors.Wrap(err, "read response")
+		return ps
 	}
 
-	wg.Wait()
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		ps.Err = errors.Errorf("unexpected status code: %d", resp.StatusCode)
+		return ps
+	}
 
-	return ret
+	ps.Result, ps.Err = checkResp(ps.Data)
+	if ps.Result == probe.Success && p.warningLatency > 0 && tt.Total > p.warningLatency {
+		ps.Result = probe.Warning
+		ps.Err = errors.Errorf("slow response: took %v, more than %v", tt.Total, p.warningLatency)
+	}
+	return ps
 }
 
 type respStatus struct {
@@ -144,22 +291,34 @@ type respStatus struct {
 	Fail    string `json:"fail,omitempty"`
 }
 
-func checkResp(res string) probe.Result {
+// checkResp checks the response follows the contract:
+// fail is not empty: Failure,
+// status is set: Success if it's 2xx,
+// success is not empty: Success,
+// otherwise Failure
+func checkResp(res string) (probe.Result, error) {
 	input := strings.NewReader(res)
 	decoder := yaml.NewYAMLOrJSONDecoder(input, 4)
 	result := respStatus{}
 	err := decoder.Decode(&result)
 	if err != nil {
-		return probe.Failure
+		return probe.Failure, errors.Wrap(err, "decode response")
 	}
 
-	if result.Status > 300 {
-		return probe.Failure
+	if result.Fail != "" {
+		return probe.Failure, errors.Errorf("fail: %s", result.Fail)
 	}
 
-	if result.Fail != "" {
-		return probe.Failure
+	if result.Status != 0 {
+		if result.Status < 200 || result.Status >= 300 {
+			return probe.Failure, errors.Errorf("status: %d", result.Status)
+		}
+		return probe.Success, nil
+	}
+
+	if result.Success != "" {
+		return probe.Success, nil
 	}
 
-	return probe.Failure
+	return probe.Failure, errors.New("response has none of status, success and fail")
 }
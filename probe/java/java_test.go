@@ -0,0 +1,134 @@
+package java
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"we.com/jiabiao/common/probe"
+)
+
+func TestCheckResp(t *testing.T) {
+	cases := []struct {
+		resp   string
+		result probe.Result
+	}{
+		{`{"status": 200}`, probe.Success},
+		{`{"status": 204, "success": "ok"}`, probe.Success},
+		{`{"status": 500}`, probe.Failure},
+		{`{"success": "true"}`, probe.Success},
+		{`{"status": 200, "fail": "db down"}`, probe.Failure},
+		{`{}`, probe.Failure},
+		{`not json`, probe.Failure},
+		{"status: 200\n", probe.Success},
+	}
+
+	for _, c := range cases {
+		r, err := checkResp(c.resp)
+		if r != c.result {
+			t.Errorf("%q: expected %v, got %v (%v)", c.resp, c.result, r, err)
+		}
+		if (r == probe.Success) != (err == nil) {
+			t.Errorf("%q: unexpected err %v for result %v", c.resp, err, r)
+		}
+	}
+}
+
+func TestBatchProbe(t *testing.T) {
+	var running, maxRunning int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		switch r.URL.Path {
+		case "/ok":
+			fmt.Fprint(w, `{"status": 200}`)
+		case "/fail":
+			fmt.Fprint(w, `{"status": 200, "fail": "db down"}`)
+		case "/large":
+			fmt.Fprint(w, `{"status": 200, "data": "`+strings.Repeat("x", 100)+`"}`)
+		case "/error":
+			http.Error(w, "oops", http.StatusInternalServerError)
+		case "/slow":
+			time.Sleep(time.Second)
+			fmt.Fprint(w, `{"status": 200}`)
+		}
+	}))
+	defer server.Close()
+
+	var args []*Args
+	for i := 0; i < 6; i++ {
+		args = append(args, &Args{Name: fmt.Sprintf("ok%d", i), URL: server.URL + "/ok"})
+	}
+	args = append(args,
+		&Args{Name: "fail", URL: server.URL + "/fail"},
+		&Args{Name: "large", URL: server.URL + "/large", MaxRespSize: 50},
+		&Args{Name: "error", URL: server.URL + "/error"},
+		&Args{Name: "slow", URL: server.URL + "/slow"},
+	)
+
+	start := time.Now()
+	results := New(WithTimeout(300*time.Millisecond), WithConcurrency(3)).Probe(context.Background(), args)
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Errorf("deadline not respected: %v", elapsed)
+	}
+	if m := atomic.LoadInt32(&maxRunning); m > 3 {
+		t.Errorf("expected at most 3 concurrent requests, got %d", m)
+	}
+	if len(results) != len(args) {
+		t.Fatalf("expected %d results, got %d", len(args), len(results))
+	}
+
+	for _, a := range args {
+		r := results[a.Name]
+		expected := probe.Failure
+		if strings.HasPrefix(a.Name, "ok") {
+			expected = probe.Success
+		}
+		if r.Result != expected {
+			t.Errorf("%s: expected %v, got %v (%v)", a.Name, expected, r.Result, r.Err)
+		}
+		if expected == probe.Failure && r.Err == nil {
+			t.Errorf("%s: expected error", a.Name)
+		}
+	}
+
+	if r := results["ok0"]; r.TimeTrack == nil || r.TimeTrack.Total <= 0 {
+		t.Errorf("time track not recorded: %+v", r.TimeTrack)
+	}
+	if r := results["large"]; len(r.Data) != 50 {
+		t.Errorf("expected 50 bytes data, got %d", len(r.Data))
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	results := map[string]*Result{
+		"a": {Name: "a", Result: probe.Success},
+		"b": {Name: "b", Result: probe.Warning, Err: fmt.Errorf("slow response")},
+		"c": {Name: "c", Result: probe.Failure, Err: fmt.Errorf("fail: db down")},
+	}
+
+	r, msg := Summarize(results, probe.PartialFailure)
+	if r != probe.Warning {
+		t.Errorf("expected warning, got %v", r)
+	}
+	if msg != "b: slow response; c: fail: db down" {
+		t.Errorf("unexpected message: %q", msg)
+	}
+
+	if r, _ := Summarize(results, probe.Worst); r != probe.Failure {
+		t.Errorf("expected failure, got %v", r)
+	}
+}
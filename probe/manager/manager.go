@@ -0,0 +1,304 @@
+// Package manager runs probes periodically, and tracks state of the targets
+// with success/failure thresholds, like kubernetes liveness/readiness probes.
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"we.com/jiabiao/common/probe"
+	"we.com/jiabiao/common/wait"
+)
+
+const (
+	// DefaultPeriod how often a target is probed
+	DefaultPeriod = 10 * time.Second
+	// DefaultSuccessThreshold consecutive successes needed to be Success
+	DefaultSuccessThreshold = 1
+	// DefaultFailureThreshold consecutive failures (or warnings) needed to be Failure (or Warning)
+	DefaultFailureThreshold = 3
+)
+
+// Target to probe
+type Target struct {
+	Name   string
+	Prober probe.Interface
+	// Spec is passed to Prober, e.g. a *probe.Config with probe.ConfigProber
+	Spec probe.Spec
+
+	// InitialDelay before the first probe
+	InitialDelay time.Duration
+	Period       time.Duration
+	// SuccessThreshold consecutive successes needed to change to Success
+	SuccessThreshold int
+	// FailureThreshold consecutive failures needed to change to Failure,
+	// and consecutive warnings to change to Warning
+	FailureThreshold int
+}
+
+// State of a target
+type State struct {
+	Name string
+	// Result is the current state, Unknown until a threshold is reached
+	Result probe.Result
+	// Since when the target is in Result
+	Since time.Time
+
+	// result of the last probe, an error or Unknown result is counted as Failure
+	LastProbe  time.Time
+	LastResult probe.Result
+	LastOutput string
+	LastError  string
+	// Consecutive is number of consecutive probes returned LastResult
+	Consecutive int
+}
+
+// Event is sent to subscribers when state of a target changes
+type Event struct {
+	Target string
+	Old    probe.Result
+	New    probe.Result
+	Time   time.Time
+	// Output and Err of the probe caused the transition
+	Output string
+	Err    error
+}
+
+type worker struct {
+	target Target
+	state  State
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+func (w *worker) stop() {
+	w.stopOnce.Do(func() { close(w.stopCh) })
+}
+
+// Manager schedules probes of targets
+type Manager struct {
+	mu      sync.Mutex
+	workers map[string]*worker
+	subs    map[int]chan Event
+	nextSub int
+	running bool
+	// stopped after stopCh of Run is closed, the manager can't be run again
+	stopped bool
+}
+
+// New create a manager, targets are not probed until Run
+func New() *Manager {
+	return &Manager{
+		workers: map[string]*worker{},
+		subs:    map[int]chan Event{},
+	}
+}
+
+// Add a target, it's started immediately if the manager is running
+func (m *Manager) Add(t Target) error {
+	if t.Name == "" {
+		return fmt.Errorf("target name is empty")
+	}
+	if t.Prober == nil {
+		return fmt.Errorf("target %s: prober is nil", t.Name)
+	}
+	if t.Period <= 0 {
+		t.Period = DefaultPeriod
+	}
+	if t.SuccessThreshold <= 0 {
+		t.SuccessThreshold = DefaultSuccessThreshold
+	}
+	if t.FailureThreshold <= 0 {
+		t.FailureThreshold = DefaultFailureThreshold
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.workers[t.Name]; ok {
+		return fmt.Errorf("target %s already exists", t.Name)
+	}
+
+	w := &worker{
+		target: t,
+		state:  State{Name: t.Name, Result: probe.Unknown, Since: time.Now()},
+		stopCh: make(chan struct{}),
+	}
+	m.workers[t.Name] = w
+	if m.running {
+		go m.run(w)
+	}
+	return nil
+}
+
+// Remove stop probing the target
+func (m *Manager) Remove(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if w, ok := m.workers[name]; ok {
+		w.stop()
+		delete(m.workers, name)
+	}
+}
+
+// Run start probing all the targets, each in its own goroutine, until stopCh is closed.
+// A manager can only be run once, Run again is a no-op, even after stopCh is closed
+func (m *Manager) Run(stopCh <-chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.running || m.stopped {
+		return
+	}
+	m.running = true
+	for _, w := range m.workers {
+		go m.run(w)
+	}
+
+	go func() {
+		<-stopCh
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.running = false
+		m.stopped = true
+		for _, w := range m.workers {
+			w.stop()
+		}
+	}()
+}
+
+func (m *Manager) run(w *worker) {
+	// probes running are canceled when the worker is stopped
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-w.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if w.target.InitialDelay > 0 {
+		select {
+		case <-time.After(w.target.InitialDelay):
+		case <-w.stopCh:
+			return
+		}
+	}
+	wait.Until(func() { m.probe(ctx, w) }, w.target.Period, w.stopCh)
+}
+
+func (m *Manager) probe(ctx context.Context, w *worker) {
+	result, output, err := w.target.Prober.Probe(ctx, w.target.Spec)
+	if ctx.Err() != nil {
+		// stopped while probing
+		return
+	}
+	glog.V(4).Infof("probe %s: %v, output: %q, err: %v", w.target.Name, result, output, err)
+	if err != nil || result == probe.Unknown {
+		result = probe.Failure
+	}
+	m.update(w, result, output, err)
+}
+
+func (m *Manager) update(w *worker, result probe.Result, output string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// removed while probing
+	if m.workers[w.target.Name] != w {
+		return
+	}
+
+	now := time.Now()
+	st := &w.state
+	if st.LastResult == result {
+		st.Consecutive++
+	} else {
+		st.Consecutive = 1
+	}
+	st.LastProbe = now
+	st.LastResult = result
+	st.LastOutput = output
+	st.LastError = ""
+	if err != nil {
+		st.LastError = err.Error()
+	}
+
+	threshold := w.target.FailureThreshold
+	if result == probe.Success {
+		threshold = w.target.SuccessThreshold
+	}
+	if st.Result == result || st.Consecutive < threshold {
+		return
+	}
+
+	e := Event{
+		Target: w.target.Name,
+		Old:    st.Result,
+		New:    result,
+		Time:   now,
+		Output: output,
+		Err:    err,
+	}
+	st.Result = result
+	st.Since = now
+
+	glog.Infof("probe %s: %v -> %v", e.Target, e.Old, e.New)
+	for id, ch := range m.subs {
+		select {
+		case ch <- e:
+		default:
+			glog.Warningf("probe subscriber %d is full, drop event of %s", id, e.Target)
+		}
+	}
+}
+
+// Subscribe to state transitions, events are dropped if the channel is full.
+// cancel must be called when the subscriber is done, which closes the channel
+func (m *Manager) Subscribe(buffer int) (events <-chan Event, cancel func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ch := make(chan Event, buffer)
+	id := m.nextSub
+	m.nextSub++
+	m.subs[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			delete(m.subs, id)
+			close(ch)
+		})
+	}
+}
+
+// State returns current state of a target
+func (m *Manager) State(name string) (State, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w, ok := m.workers[name]
+	if !ok {
+		return State{}, false
+	}
+	return w.state, true
+}
+
+// States returns current state of all the targets, sorted by name
+func (m *Manager) States() []State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ret := make([]State, 0, len(m.workers))
+	for _, w := range m.workers {
+		ret = append(ret, w.state)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
@@ -0,0 +1,193 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"we.com/jiabiao/common/probe"
+)
+
+// scripted returns results in order, the last one is repeated
+type scripted struct {
+	mu      sync.Mutex
+	results []probe.Result
+	calls   int
+}
+
+func (s *scripted) Probe(ctx context.Context, spec probe.Spec) (probe.Result, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.calls
+	if i >= len(s.results) {
+		i = len(s.results) - 1
+	}
+	s.calls++
+	if s.results[i] == probe.Unknown {
+		return probe.Unknown, "", errors.New("probe error")
+	}
+	return s.results[i], string(s.results[i]), nil
+}
+
+func TestUpdateThresholds(t *testing.T) {
+	m := New()
+	if err := m.Add(Target{Name: "t", Prober: &scripted{}, SuccessThreshold: 2, FailureThreshold: 3}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	events, cancel := m.Subscribe(10)
+	defer cancel()
+
+	w := m.workers["t"]
+	steps := []struct {
+		result probe.Result
+		state  probe.Result
+	}{
+		{probe.Success, probe.Unknown},
+		{probe.Success, probe.Success},
+		{probe.Failure, probe.Success},
+		{probe.Failure, probe.Success},
+		{probe.Success, probe.Success},
+		{probe.Failure, probe.Success},
+		{probe.Failure, probe.Success},
+		{probe.Failure, probe.Failure},
+		{probe.Warning, probe.Failure},
+		{probe.Warning, probe.Failure},
+		{probe.Warning, probe.Warning},
+		{probe.Success, probe.Warning},
+		{probe.Success, probe.Success},
+	}
+	for i, s := range steps {
+		m.update(w, s.result, "", nil)
+		st, _ := m.State("t")
+		if st.Result != s.state {
+			t.Fatalf("step %d: expected state %v, got %v", i, s.state, st.Result)
+		}
+	}
+
+	var transitions []probe.Result
+	for len(events) > 0 {
+		e := <-events
+		transitions = append(transitions, e.New)
+	}
+	expected := []probe.Result{probe.Success, probe.Failure, probe.Warning, probe.Success}
+	if len(transitions) != len(expected) {
+		t.Fatalf("expected transitions %v, got %v", expected, transitions)
+	}
+	for i := range expected {
+		if transitions[i] != expected[i] {
+			t.Errorf("expected transitions %v, got %v", expected, transitions)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	m := New()
+	events, cancel := m.Subscribe(10)
+	defer cancel()
+
+	err := m.Add(Target{
+		Name:             "flaky",
+		Prober:           &scripted{results: []probe.Result{probe.Success, probe.Unknown}},
+		InitialDelay:     20 * time.Millisecond,
+		Period:           10 * time.Millisecond,
+		FailureThreshold: 2,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := m.Add(Target{Name: "flaky", Prober: &scripted{}}); err == nil {
+		t.Errorf("expected error adding duplicate target")
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	m.Run(stopCh)
+
+	var got []Event
+	timeout := time.After(2 * time.Second)
+	for len(got) < 2 {
+		select {
+		case e := <-events:
+			got = append(got, e)
+		case <-timeout:
+			t.Fatalf("timeout waiting for events, got %v", got)
+		}
+	}
+	if got[0].Old != probe.Unknown || got[0].New != probe.Success {
+		t.Errorf("unexpected first event: %+v", got[0])
+	}
+	if got[1].New != probe.Failure || got[1].Err == nil {
+		t.Errorf("unexpected second event: %+v", got[1])
+	}
+
+	states := m.States()
+	if len(states) != 1 || states[0].Result != probe.Failure || states[0].LastError == "" {
+		t.Errorf("unexpected states: %+v", states)
+	}
+
+	m.Remove("flaky")
+	if _, ok := m.State("flaky"); ok {
+		t.Errorf("target should be removed")
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	m := New()
+	stopCh := make(chan struct{})
+	m.Run(stopCh)
+	close(stopCh)
+	for stopped := false; !stopped; {
+		time.Sleep(time.Millisecond)
+		m.mu.Lock()
+		stopped = m.stopped
+		m.mu.Unlock()
+	}
+
+	// run again after stopped is a no-op, targets added are not probed
+	m.Run(make(chan struct{}))
+	s := &scripted{results: []probe.Result{probe.Success}}
+	if err := m.Add(Target{Name: "t", Prober: s, Period: time.Millisecond}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.calls != 0 {
+		t.Errorf("expected not probed, got %d calls", s.calls)
+	}
+}
+
+// blocking probes block until ctx is done
+type blocking struct {
+	started  chan struct{}
+	canceled chan struct{}
+}
+
+func (b *blocking) Probe(ctx context.Context, spec probe.Spec) (probe.Result, string, error) {
+	close(b.started)
+	<-ctx.Done()
+	close(b.canceled)
+	return probe.Failure, "", ctx.Err()
+}
+
+func TestStopCancelsProbe(t *testing.T) {
+	m := New()
+	b := &blocking{started: make(chan struct{}), canceled: make(chan struct{})}
+	if err := m.Add(Target{Name: "t", Prober: b}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stopCh := make(chan struct{})
+	m.Run(stopCh)
+	<-b.started
+	close(stopCh)
+	select {
+	case <-b.canceled:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("probe is not canceled after stopped")
+	}
+	if st, _ := m.State("t"); !st.LastProbe.IsZero() {
+		t.Errorf("result of a canceled probe should be ignored: %+v", st)
+	}
+}
@@ -1,5 +1,11 @@
 package probe
 
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
 type Result string
 
 const (
@@ -11,6 +17,133 @@ const (
 
 type LoadGenerator func() interface{}
 
+// Deprecated: Prober can't be canceled, use Interface, or adapt it with FromProber
 type Prober interface {
 	Prob(lg LoadGenerator) (Result, string, error)
 }
+
+// ProberFunc adapts a function, like java.Probe, to a Prober
+type ProberFunc func(lg LoadGenerator) (Result, string, error)
+
+// Prob calls f(lg)
+func (f ProberFunc) Prob(lg LoadGenerator) (Result, string, error) {
+	return f(lg)
+}
+
+// FromProber adapts p to Interface, the spec must be the LoadGenerator passed to p.
+// p is not canceled with ctx, the result is discarded if ctx is done first
+func FromProber(p Prober) Interface {
+	return legacyProber{p}
+}
+
+type legacyProber struct {
+	p Prober
+}
+
+func (l legacyProber) Probe(ctx context.Context, spec Spec) (Result, string, error) {
+	var lg LoadGenerator
+	if spec != nil {
+		var ok bool
+		if lg, ok = spec.(LoadGenerator); !ok {
+			return Unknown, "", fmt.Errorf("unexpected spec %T, expected LoadGenerator", spec)
+		}
+	}
+
+	type result struct {
+		r   Result
+		out string
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		r, out, err := l.p.Prob(lg)
+		done <- result{r, out, err}
+	}()
+	select {
+	case r := <-done:
+		return r.r, r.out, r.err
+	case <-ctx.Done():
+		return Failure, "", ctx.Err()
+	}
+}
+
+// AggregateRule combines results of several probes into one
+type AggregateRule func(results []Result) Result
+
+// severity of results, the larger the worse
+var severity = map[Result]int{
+	Success: 0,
+	Warning: 1,
+	Unknown: 2,
+	Failure: 3,
+}
+
+// Worst is Failure if any of results failed, then Unknown, Warning and Success,
+// Unknown if results is empty
+func Worst(results []Result) Result {
+	if len(results) == 0 {
+		return Unknown
+	}
+	ret := Success
+	for _, r := range results {
+		if severity[r] > severity[ret] {
+			ret = r
+		}
+	}
+	return ret
+}
+
+// Best is Success if any of results succeed, then Warning, Unknown and Failure,
+// Unknown if results is empty
+func Best(results []Result) Result {
+	if len(results) == 0 {
+		return Unknown
+	}
+	ret := Failure
+	for _, r := range results {
+		if severity[r] < severity[ret] {
+			ret = r
+		}
+	}
+	return ret
+}
+
+// Ratio returns a rule based on the ratio of failed(Failure or Unknown) results:
+// Failure if the ratio >= failure, Warning if the ratio > warning or any result is Warning,
+// Success otherwise
+func Ratio(warning, failure float64) AggregateRule {
+	return func(results []Result) Result {
+		if len(results) == 0 {
+			return Unknown
+		}
+		failed, warned := 0, false
+		for _, r := range results {
+			switch r {
+			case Failure, Unknown:
+				failed++
+			case Warning:
+				warned = true
+			}
+		}
+		ratio := float64(failed) / float64(len(results))
+		switch {
+		case failed > 0 && ratio >= failure:
+			return Failure
+		case ratio > warning || warned:
+			return Warning
+		}
+		return Success
+	}
+}
+
+// PartialFailure is Warning if some of the results failed, Failure if all of them failed
+var PartialFailure = Ratio(0, 1)
+
+// CheckLatency turns a successful result into Warning if elapsed > threshold,
+// the output is the reason then. threshold <= 0 means no limit
+func CheckLatency(result Result, output string, err error, elapsed, threshold time.Duration) (Result, string, error) {
+	if result != Success || err != nil || threshold <= 0 || elapsed <= threshold {
+		return result, output, err
+	}
+	return Warning, fmt.Sprintf("slow response: took %v, more than %v", elapsed, threshold), nil
+}
@@ -0,0 +1,65 @@
+package probe
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAggregateRules(t *testing.T) {
+	cases := []struct {
+		results []Result
+		worst   Result
+		best    Result
+		partial Result
+		ratio   Result
+	}{
+		{nil, Unknown, Unknown, Unknown, Unknown},
+		{[]Result{Success, Success}, Success, Success, Success, Success},
+		{[]Result{Success, Warning}, Warning, Success, Warning, Warning},
+		{[]Result{Success, Success, Success, Failure}, Failure, Success, Warning, Success},
+		{[]Result{Success, Failure, Unknown, Success}, Failure, Success, Warning, Failure},
+		{[]Result{Unknown, Warning}, Unknown, Warning, Warning, Failure},
+		{[]Result{Failure, Failure}, Failure, Failure, Failure, Failure},
+	}
+
+	// warning if more than 1/4 failed, failure if half of them failed
+	ratio := Ratio(0.25, 0.5)
+	for _, c := range cases {
+		if r := Worst(c.results); r != c.worst {
+			t.Errorf("worst of %v: expected %v, got %v", c.results, c.worst, r)
+		}
+		if r := Best(c.results); r != c.best {
+			t.Errorf("best of %v: expected %v, got %v", c.results, c.best, r)
+		}
+		if r := PartialFailure(c.results); r != c.partial {
+			t.Errorf("partial failure of %v: expected %v, got %v", c.results, c.partial, r)
+		}
+		if r := ratio(c.results); r != c.ratio {
+			t.Errorf("ratio of %v: expected %v, got %v", c.results, c.ratio, r)
+		}
+	}
+}
+
+func TestFromProber(t *testing.T) {
+	p := FromProber(ProberFunc(func(lg LoadGenerator) (Result, string, error) {
+		if v, _ := lg().(time.Duration); v > 0 {
+			time.Sleep(v)
+		}
+		return Success, "ok", nil
+	}))
+	load := LoadGenerator(func() interface{} { return nil })
+	if r, out, err := p.Probe(context.Background(), load); r != Success || out != "ok" || err != nil {
+		t.Errorf("unexpected result %v %q %v", r, out, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	slow := LoadGenerator(func() interface{} { return time.Second })
+	if r, _, err := p.Probe(ctx, slow); r != Failure || err == nil {
+		t.Errorf("expected failure of ctx, got %v %v", r, err)
+	}
+	if r, _, err := p.Probe(context.Background(), "x"); r != Unknown || err == nil {
+		t.Errorf("expected unknown for invalid spec, got %v %v", r, err)
+	}
+}
@@ -0,0 +1,168 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	mytime "we.com/jiabiao/common/time"
+	"we.com/jiabiao/common/yaml"
+)
+
+// DefaultTimeout of a config without timeout
+const DefaultTimeout = 10 * time.Second
+
+// Spec describes what to probe, each probe type has its own spec
+type Spec interface{}
+
+// Interface is implemented by all the probe types, timeout of the probe
+// is the deadline of ctx
+type Interface interface {
+	Probe(ctx context.Context, spec Spec) (Result, string, error)
+}
+
+// Factory of a probe type
+type Factory struct {
+	// NewSpec returns a pointer to an empty spec, to which config is decoded
+	NewSpec func() Spec
+	Prober  Interface
+}
+
+var (
+	registryLock sync.RWMutex
+	registry     = map[string]Factory{}
+)
+
+// Register a probe type, usually called in init of the probe packages,
+// so they must be imported before used, e.g.
+//
+//	import _ "we.com/jiabiao/common/probe/http"
+//
+// Register panics if the type is registered twice
+func Register(typ string, f Factory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, ok := registry[typ]; ok {
+		panic(fmt.Sprintf("probe type %s registered twice", typ))
+	}
+	registry[typ] = f
+}
+
+// Lookup the factory of a probe type
+func Lookup(typ string) (Factory, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	f, ok := registry[typ]
+	return f, ok
+}
+
+// Types returns all the registered probe types, sorted
+func Types() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	var ret []string
+	for typ := range registry {
+		ret = append(ret, typ)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Config declares a probe, fields of the spec are at the same level as type, e.g.
+//
+//	name: api
+//	type: http
+//	timeout: 2s
+//	url: http://127.0.0.1:8080/health
+//	expectedStatus: [200]
+type Config struct {
+	Name    string
+	Type    string
+	Timeout mytime.Duration
+	// Spec decoded according to Type
+	Spec Spec
+}
+
+type configHeader struct {
+	Name    string          `json:"name"`
+	Type    string          `json:"type"`
+	Timeout mytime.Duration `json:"timeout,omitempty"`
+}
+
+// UnmarshalJSON decode the spec of registered type
+func (c *Config) UnmarshalJSON(data []byte) error {
+	h := configHeader{}
+	if err := json.Unmarshal(data, &h); err != nil {
+		return err
+	}
+	f, ok := Lookup(h.Type)
+	if !ok {
+		return fmt.Errorf("probe %s: unknown type %q", h.Name, h.Type)
+	}
+	spec := f.NewSpec()
+	if err := json.Unmarshal(data, spec); err != nil {
+		return fmt.Errorf("probe %s: %v", h.Name, err)
+	}
+
+	c.Name, c.Type, c.Timeout, c.Spec = h.Name, h.Type, h.Timeout, spec
+	return nil
+}
+
+// LoadConfigs read a list of probe configs from a yaml or json file
+func LoadConfigs(filename string) ([]Config, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error read probe config file: %v", err)
+	}
+
+	var cfgs []Config
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(content), 4)
+	if err = decoder.Decode(&cfgs); err != nil {
+		return nil, fmt.Errorf("error parse probe config: %v", err)
+	}
+	return cfgs, nil
+}
+
+// Probe runs the probe with the prober of its type, with c.Timeout, or DefaultTimeout if not set
+func (c *Config) Probe(ctx context.Context) (Result, string, error) {
+	f, ok := Lookup(c.Type)
+	if !ok {
+		return Unknown, "", fmt.Errorf("probe %s: unknown type %q", c.Name, c.Type)
+	}
+	timeout := time.Duration(c.Timeout)
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return f.Prober.Probe(ctx, c.Spec)
+}
+
+// ConfigProber implements Interface, the spec must be a *Config, which is probed
+// with the prober of its type, so configs can be scheduled by the probe manager
+type ConfigProber struct{}
+
+func (ConfigProber) Probe(ctx context.Context, spec Spec) (Result, string, error) {
+	c, ok := spec.(*Config)
+	if !ok {
+		return Unknown, "", fmt.Errorf("unexpected spec %T, expected *Config", spec)
+	}
+	return c.Probe(ctx)
+}
+
+// Timeout returns time left before the deadline of ctx, or def if ctx has no deadline
+func Timeout(ctx context.Context, def time.Duration) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return def
+	}
+	if left := time.Until(deadline); def <= 0 || left < def {
+		return left
+	}
+	return def
+}
@@ -0,0 +1,173 @@
+package probe_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"we.com/jiabiao/common/probe"
+	_ "we.com/jiabiao/common/probe/exec"
+	_ "we.com/jiabiao/common/probe/http"
+	_ "we.com/jiabiao/common/probe/java"
+	_ "we.com/jiabiao/common/probe/tcp"
+)
+
+func TestTypes(t *testing.T) {
+	expected := []string{"exec", "http", "java", "tcp"}
+	if types := probe.Types(); !reflect.DeepEqual(types, expected) {
+		t.Errorf("expected %v, got %v", expected, types)
+	}
+}
+
+func TestLoadConfigs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			fmt.Fprint(w, `{"status": "ok"}`)
+		case "/java":
+			fmt.Fprint(w, `{"status": 200}`)
+		case "/slow":
+			time.Sleep(time.Second)
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "probe")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := `
+- name: api
+  type: http
+  timeout: 1s
+  url: ` + server.URL + `/health
+  expectedStatus: [200]
+  bodyJSONPath: "{.status}"
+  bodyJSONValue: ok
+- name: slow
+  type: http
+  timeout: 100ms
+  url: ` + server.URL + `/slow
+- name: port
+  type: tcp
+  addr: ` + strings.TrimPrefix(server.URL, "http://") + `
+- name: closed
+  type: tcp
+  timeout: 100ms
+  addr: 127.0.0.1:1
+- name: script
+  type: exec
+  command: [sh, -c, "read line; echo $line"]
+  stdin: "hello\n"
+- name: nagios
+  type: exec
+  command: [sh, -c, "echo WARNING; exit 1"]
+  nagios: true
+- name: codes
+  type: exec
+  command: [sh, -c, "exit 3"]
+  nagios: true
+  exitCodes:
+    3: green
+- name: sleep
+  type: exec
+  timeout: 100ms
+  command: [sleep, "10"]
+- name: java
+  type: java
+  targets:
+  - name: a
+    url: ` + server.URL + `/java
+`
+	filename := filepath.Join(dir, "probes.yaml")
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cfgs, err := probe.LoadConfigs(filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]probe.Result{
+		"api":    probe.Success,
+		"slow":   probe.Failure,
+		"port":   probe.Success,
+		"closed": probe.Failure,
+		"script": probe.Success,
+		"nagios": probe.Warning,
+		"codes":  probe.Success,
+		"sleep":  probe.Failure,
+		"java":   probe.Success,
+	}
+	if len(cfgs) != len(expected) {
+		t.Fatalf("expected %d configs, got %d", len(expected), len(cfgs))
+	}
+	for _, c := range cfgs {
+		start := time.Now()
+		result, output, err := c.Probe(context.Background())
+		if result != expected[c.Name] {
+			t.Errorf("%s: expected %v, got %v (%s, %v)", c.Name, expected[c.Name], result, output, err)
+		}
+		if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+			t.Errorf("%s: timeout not respected, took %v", c.Name, elapsed)
+		}
+		if c.Name == "script" && output != "hello\n" {
+			t.Errorf("%s: unexpected output %q", c.Name, output)
+		}
+	}
+}
+
+func TestConfigProbeContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ln.Close()
+
+	cfgs := []probe.Config{}
+	data := `[{"name": "hang", "type": "tcp", "addr": "` + ln.Addr().String() + `", "expect": "never"}]`
+	if err := json.Unmarshal([]byte(data), &cfgs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if result, _, _ := cfgs[0].Probe(ctx); result != probe.Failure {
+		t.Errorf("expected failure, got %v", result)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("deadline of context not respected, took %v", elapsed)
+	}
+
+	// scheduled by the manager
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if result, _, _ := (probe.ConfigProber{}).Probe(ctx, &cfgs[0]); result != probe.Failure {
+		t.Errorf("expected failure, got %v", result)
+	}
+	if result, _, err := (probe.ConfigProber{}).Probe(ctx, cfgs[0]); result != probe.Unknown || err == nil {
+		t.Errorf("expected unknown for invalid spec, got %v %v", result, err)
+	}
+}
+
+func TestConfigUnknownType(t *testing.T) {
+	cfgs := []probe.Config{}
+	err := json.Unmarshal([]byte(`[{"name": "x", "type": "smtp"}]`), &cfgs)
+	if err == nil || !strings.Contains(err.Error(), `unknown type "smtp"`) {
+		t.Errorf("expected unknown type error, got %v", err)
+	}
+}
@@ -1,27 +1,59 @@
 package tcp
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
 	"net"
+	"regexp"
 	"strconv"
 	"time"
 
+	utilnet "we.com/jiabiao/common/net"
 	"we.com/jiabiao/common/probe"
 
 	"github.com/golang/glog"
 )
 
-func New() TCPProber {
-	return tcpProber{}
+// Option of the tcp prober
+type Option func(*tcpProber)
+
+// WithWarningLatency a successful probe which takes longer than d to connect returns Warning
+func WithWarningLatency(d time.Duration) Option {
+	return func(pr *tcpProber) {
+		pr.warningLatency = d
+	}
+}
+
+func New(opts ...Option) TCPProber {
+	pr := tcpProber{}
+	for _, o := range opts {
+		o(&pr)
+	}
+	return pr
 }
 
 type TCPProber interface {
 	Probe(host string, port int, timeout time.Duration) (probe.Result, string, error)
+	ProbeSpec(spec *Spec) (probe.Result, string, *utilnet.Timing, error)
 }
 
-type tcpProber struct{}
+type tcpProber struct {
+	warningLatency time.Duration
+}
 
 func (pr tcpProber) Probe(host string, port int, timeout time.Duration) (probe.Result, string, error) {
-	return DoTCPProbe(net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	start := time.Now()
+	result, output, err := DoTCPProbe(net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	return probe.CheckLatency(result, output, err, time.Since(start), pr.warningLatency)
+}
+
+func (pr tcpProber) ProbeSpec(spec *Spec) (probe.Result, string, *utilnet.Timing, error) {
+	result, output, timing, err := DoTCPSpecProbe(spec)
+	result, output, err = probe.CheckLatency(result, output, err, timing.Total, pr.warningLatency)
+	return result, output, timing, err
 }
 
 // DoTCPProbe checks that a TCP socket to the address can be opened.
@@ -40,3 +72,161 @@ func DoTCPProbe(addr string, timeout time.Duration) (probe.Result, string, error
 	}
 	return probe.Success, "", nil
 }
+
+const (
+	// maxExpectRead max bytes read when waiting for the expected reply
+	maxExpectRead = 64 * 1024
+	// DefaultTimeout of a spec probe, if neither spec.Timeout nor a deadline of ctx is set
+	DefaultTimeout = 10 * time.Second
+)
+
+// Spec of a tcp probe
+type Spec struct {
+	// Addr host:port to connect
+	Addr    string        `json:"addr"`
+	Timeout time.Duration `json:"-"`
+	// Send is written after connected, if not empty
+	Send string `json:"send,omitempty"`
+	// Expect if set, the reply must match it before timeout
+	Expect string `json:"expect,omitempty"`
+
+	// TLS do a tls handshake after connected, ServerName is used for SNI and verification,
+	// default to host of Addr
+	TLS                bool   `json:"tls,omitempty"`
+	ServerName         string `json:"serverName,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+	// CAFile pem encoded ca certificates, added to RootCAs
+	CAFile  string         `json:"caFile,omitempty"`
+	RootCAs *x509.CertPool `json:"-"`
+}
+
+func init() {
+	probe.Register("tcp", probe.Factory{
+		NewSpec: func() probe.Spec { return &Spec{} },
+		Prober:  specProber{New().(tcpProber)},
+	})
+}
+
+// specProber implements probe.Interface
+type specProber struct {
+	pr tcpProber
+}
+
+func (p specProber) Probe(ctx context.Context, spec probe.Spec) (probe.Result, string, error) {
+	s, ok := spec.(*Spec)
+	if !ok {
+		return probe.Unknown, "", fmt.Errorf("tcp: unexpected spec %T", spec)
+	}
+	result, output, timing, err := doTCPSpecProbe(ctx, s)
+	return probe.CheckLatency(result, output, err, timing.Total, p.pr.warningLatency)
+}
+
+// DoTCPSpecProbe connects to spec.Addr, optionally with tls, sends spec.Send and waits for
+// a reply matches spec.Expect. Connect, TLSHandshake and FirstByte of the timing are set.
+// An invalid spec returns Unknown with an error, other errors are converted into failures.
+// DefaultTimeout is used if spec.Timeout is not set
+func DoTCPSpecProbe(spec *Spec) (probe.Result, string, *utilnet.Timing, error) {
+	return doTCPSpecProbe(context.Background(), spec)
+}
+
+func doTCPSpecProbe(ctx context.Context, spec *Spec) (probe.Result, string, *utilnet.Timing, error) {
+	timing := utilnet.NewTiming()
+
+	var expect *regexp.Regexp
+	if spec.Expect != "" {
+		var err error
+		if expect, err = regexp.Compile(spec.Expect); err != nil {
+			return probe.Unknown, "", timing, fmt.Errorf("invalid expect %q: %v", spec.Expect, err)
+		}
+	}
+	rootCAs := spec.RootCAs
+	if spec.CAFile != "" {
+		data, err := ioutil.ReadFile(spec.CAFile)
+		if err != nil {
+			return probe.Unknown, "", timing, fmt.Errorf("read ca file: %v", err)
+		}
+		// the pool of spec is shared by probes, certificates are added to a copy
+		if rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		} else {
+			rootCAs = rootCAs.Clone()
+		}
+		if !rootCAs.AppendCertsFromPEM(data) {
+			return probe.Unknown, "", timing, fmt.Errorf("no valid ca certificate found in %s", spec.CAFile)
+		}
+	}
+
+	timeout := spec.Timeout
+	if _, ok := ctx.Deadline(); !ok && timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", spec.Addr)
+	if err != nil {
+		// Convert errors to failures to handle timeouts.
+		return probe.Failure, err.Error(), timing, nil
+	}
+	defer conn.Close()
+	timing.Connect = time.Since(timing.Start)
+	timing.RemoteAddr = conn.RemoteAddr().String()
+	// ctx always has a deadline
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	if spec.TLS {
+		serverName := spec.ServerName
+		if serverName == "" {
+			serverName, _, _ = net.SplitHostPort(spec.Addr)
+		}
+		tlsConn := tls.Client(conn, &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: spec.InsecureSkipVerify,
+			RootCAs:            rootCAs,
+		})
+		if err := tlsConn.Handshake(); err != nil {
+			return probe.Failure, fmt.Sprintf("tls handshake: %v", err), timing, nil
+		}
+		timing.TLSHandshake = time.Since(timing.Start) - timing.Connect
+		conn = tlsConn
+	}
+	timing.GotConn = time.Since(timing.Start)
+
+	if len(spec.Send) > 0 {
+		if _, err := conn.Write([]byte(spec.Send)); err != nil {
+			return probe.Failure, fmt.Sprintf("send: %v", err), timing, nil
+		}
+		timing.WroteRequest = time.Since(timing.Start)
+	}
+
+	if expect == nil {
+		timing.Total = time.Since(timing.Start)
+		return probe.Success, "", timing, nil
+	}
+
+	var reply []byte
+	buf := make([]byte, 4096)
+	for len(reply) < maxExpectRead {
+		n, err := conn.Read(buf)
+		if n > 0 && len(reply) == 0 {
+			timing.FirstByte = time.Since(timing.Start)
+		}
+		reply = append(reply, buf[:n]...)
+		if expect.Match(reply) {
+			timing.Total = time.Since(timing.Start)
+			return probe.Success, string(reply), timing, nil
+		}
+		if err != nil {
+			timing.Total = time.Since(timing.Start)
+			glog.V(4).Infof("tcp probe %s read: %v", spec.Addr, err)
+			return probe.Failure, fmt.Sprintf("reply does not match %q: %q", spec.Expect, reply), timing, nil
+		}
+	}
+
+	timing.Total = time.Since(timing.Start)
+	return probe.Failure, fmt.Sprintf("reply does not match %q in %d bytes", spec.Expect, len(reply)), timing, nil
+}
@@ -1,15 +1,20 @@
 package tcp
 
 import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
-	"jiabiao/common/probe"
+	"we.com/jiabiao/common/probe"
 )
 
 func containsAny(s string, substrs []string) bool {
@@ -54,6 +59,7 @@ func TestTcpHealthChecker(t *testing.T) {
 			"Servname not supported for ai_socktype",
 			"nodename nor servname provided, or not known",
 			"dial tcp: invalid port",
+			"dial tcp: address -1: invalid port",
 		}},
 	}
 
@@ -66,12 +72,130 @@ func TestTcpHealthChecker(t *testing.T) {
 		if err != tt.expectedError {
 			t.Errorf("#%d: expected error=%v, get=%v", i, tt.expectedError, err)
 		}
-		dat := ""
-		if output != nil {
-			dat = ioutils.ReadAll(output)
-		}
 		if !containsAny(output, tt.expectedOutputs) {
 			t.Errorf("#%d: expected output=one of %#v, get=%s", i, tt.expectedOutputs, output)
 		}
 	}
 }
+
+func TestTcpSpecProbe(t *testing.T) {
+	// a fake redis replies +PONG to PING, and a banner server
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				buf := make([]byte, 64)
+				n, _ := conn.Read(buf)
+				if strings.HasPrefix(string(buf[:n]), "PING") {
+					conn.Write([]byte("+PO"))
+					time.Sleep(10 * time.Millisecond)
+					conn.Write([]byte("NG\r\n"))
+				}
+			}(conn)
+		}
+	}()
+
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer tlsServer.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(tlsServer.Certificate())
+
+	tests := []struct {
+		name   string
+		spec   Spec
+		result probe.Result
+	}{
+		{"connect", Spec{Addr: ln.Addr().String()}, probe.Success},
+		{"ping", Spec{Addr: ln.Addr().String(), Send: "PING\r\n", Expect: `^\+PONG`}, probe.Success},
+		{"no reply", Spec{Addr: ln.Addr().String(), Send: "HELLO\r\n", Expect: `^\+PONG`}, probe.Failure},
+		{"invalid expect", Spec{Addr: ln.Addr().String(), Expect: `(`}, probe.Unknown},
+		{"tls", Spec{Addr: tlsServer.Listener.Addr().String(), TLS: true, ServerName: "example.com", RootCAs: pool,
+			Send: "GET / HTTP/1.0\r\n\r\n", Expect: `^HTTP/1\.0 200`}, probe.Success},
+		{"tls unknown authority", Spec{Addr: tlsServer.Listener.Addr().String(), TLS: true}, probe.Failure},
+		{"tls insecure", Spec{Addr: tlsServer.Listener.Addr().String(), TLS: true, InsecureSkipVerify: true}, probe.Success},
+	}
+
+	prober := New()
+	for _, tt := range tests {
+		tt.spec.Timeout = 500 * time.Millisecond
+		status, output, timing, err := prober.ProbeSpec(&tt.spec)
+		if status != tt.result {
+			t.Errorf("%s: expected %v, got %v (%s, %v)", tt.name, tt.result, status, output, err)
+		}
+		if status != probe.Success {
+			continue
+		}
+		if timing.Connect <= 0 {
+			t.Errorf("%s: connect time not recorded", tt.name)
+		}
+		if tt.spec.Expect != "" && (timing.FirstByte < timing.Connect || timing.FirstByte > timing.Total) {
+			t.Errorf("%s: unexpected first byte time %v", tt.name, timing.FirstByte)
+		}
+		if tt.spec.TLS && timing.TLSHandshake <= 0 {
+			t.Errorf("%s: tls handshake time not recorded", tt.name)
+		}
+	}
+}
+
+func TestTcpSpecProbeSilent(t *testing.T) {
+	// accepts connections but never replies
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	status, output, _, err := doTCPSpecProbe(ctx, &Spec{Addr: ln.Addr().String(), Expect: "OK"})
+	if status != probe.Failure || err != nil {
+		t.Errorf("expected failure, got %v (%s, %v)", status, output, err)
+	}
+	if d := time.Since(start); d > DefaultTimeout/2 {
+		t.Errorf("deadline of ctx is not used, returned after %v", d)
+	}
+}
+
+func TestTcpSpecProbeCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, data, 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	spec := &Spec{Addr: server.Listener.Addr().String(), TLS: true, ServerName: "example.com",
+		CAFile: caFile, RootCAs: pool, Timeout: time.Second}
+	for i := 0; i < 2; i++ {
+		if status, output, _, err := DoTCPSpecProbe(spec); status != probe.Success {
+			t.Errorf("expected success, got %v (%s, %v)", status, output, err)
+		}
+	}
+	// certificates of the ca file are not added to the pool of spec
+	if !pool.Equal(x509.NewCertPool()) {
+		t.Errorf("RootCAs of spec is changed")
+	}
+}
@@ -0,0 +1,74 @@
+package repo
+
+import (
+	"context"
+	"time"
+)
+
+// Commit info returned by Backend.Log
+type Commit struct {
+	Hash    string
+	Author  string
+	Email   string
+	Date    time.Time
+	Message string
+}
+
+// Revision checked out in a work tree
+type Revision struct {
+	// Branch checked out, empty if HEAD is detached
+	Branch string
+	// Commit of HEAD
+	Commit
+	// Dirty the work tree has uncommitted changes, or untracked files
+	Dirty bool
+}
+
+// Backend does git operations on a local repo, errors returned are *RepoError.
+// Both the git cli backend and the pure go backend behave the same.
+type Backend interface {
+	// Clone url to path, which must not exist or be empty
+	Clone(ctx context.Context, url, path string, opts ...Option) error
+	// Fetch branches and tags from origin, branches deleted in origin are pruned,
+	// only auth and depth options are used
+	Fetch(ctx context.Context, path string, opts ...Option) error
+	// Checkout rev, local changes are discarded. If rev is a branch not exists locally,
+	// but origin has it, a local branch tracking it is created; other revisions,
+	// like tags and commits, are checked out as a detached HEAD
+	Checkout(ctx context.Context, path, rev string) error
+	// Reset current branch, or HEAD if detached, to rev, local changes are discarded
+	Reset(ctx context.Context, path, rev string) error
+	// Log returns at most n commits reachable from rev, the newest first
+	Log(ctx context.Context, path, rev string, n int) ([]Commit, error)
+	// Resolve rev, like a branch, tag, or a full or short hash, to the hash of a commit
+	Resolve(ctx context.Context, path, rev string) (string, error)
+	// Head returns the revision checked out
+	Head(ctx context.Context, path string) (*Revision, error)
+	// Diff returns files changed between commit from and to, sorted, renames are
+	// reported as a deleted and an added file
+	Diff(ctx context.Context, path, from, to string) ([]string, error)
+	// Archive writes files of rev to dir dst, which must exist, without the .git dir.
+	// Sparse checkout is ignored, and submodules are not written
+	Archive(ctx context.Context, path, rev, dst string) error
+}
+
+// DefaultBackend used by InitRepo, UpdateRepo and Switch2branch
+var DefaultBackend = NewCLIBackend()
+
+func newRepoError(code int, op, path string, err error, msg string) *RepoError {
+	return &RepoError{
+		Code: code,
+		Op:   op,
+		Path: path,
+		err:  err,
+		Msg:  msg,
+	}
+}
+
+// ctxError returns a TimeoutError if ctx is done
+func ctxError(ctx context.Context, op, path string) error {
+	if err := ctx.Err(); err != nil {
+		return newRepoError(TimeoutError, op, path, err, "canceled")
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package repo
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var backends = map[string]Backend{
+	"cli":   NewCLIBackend(),
+	"gogit": NewGoGitBackend(),
+}
+
+// runGit runs git in dir, with a fixed identity
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=tester", "GIT_AUTHOR_EMAIL=tester@example.com",
+		"GIT_COMMITTER_NAME=tester", "GIT_COMMITTER_EMAIL=tester@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func commit(t *testing.T, dir, file, content, msg string) string {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(content), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	runGit(t, dir, "add", file)
+	runGit(t, dir, "commit", "-q", "-m", msg)
+	return runGit(t, dir, "rev-parse", "HEAD")
+}
+
+// newRemote creates a bare repo with branch master and dev, and an annotated tag v1 on master,
+// returns path of the bare repo, and a work tree to push more commits
+func newRemote(t *testing.T) (string, string) {
+	dir := t.TempDir()
+	bare := filepath.Join(dir, "remote.git")
+	work := filepath.Join(dir, "work")
+
+	runGit(t, dir, "init", "-q", "--bare", bare)
+	runGit(t, bare, "symbolic-ref", "HEAD", "refs/heads/master")
+	runGit(t, dir, "init", "-q", work)
+	runGit(t, work, "checkout", "-q", "-b", "master")
+	runGit(t, work, "remote", "add", "origin", bare)
+
+	commit(t, work, "a.txt", "v1", "first")
+	runGit(t, work, "tag", "-a", "v1", "-m", "release v1")
+	runGit(t, work, "checkout", "-q", "-b", "dev")
+	commit(t, work, "b.txt", "dev", "dev")
+	runGit(t, work, "checkout", "-q", "master")
+	runGit(t, work, "push", "-q", "origin", "master", "dev", "--tags")
+	return bare, work
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return string(b)
+}
+
+func TestBackend(t *testing.T) {
+	for name, b := range backends {
+		t.Run(name, func(t *testing.T) {
+			testBackend(t, b)
+		})
+	}
+}
+
+func testBackend(t *testing.T, b Backend) {
+	ctx := context.Background()
+	bare, work := newRemote(t)
+	local := filepath.Join(t.TempDir(), "local")
+
+	if err := b.Clone(ctx, bare, local); err != nil {
+		t.Fatalf("clone: %v", err)
+	}
+	if err := b.Clone(ctx, bare, local); ErrorCode(err) != CloneError {
+		t.Errorf("clone into non empty dir: expected CloneError, got %v", err)
+	}
+
+	commits, err := b.Log(ctx, local, "HEAD", 10)
+	if err != nil || len(commits) != 1 {
+		t.Fatalf("log: expected 1 commit, got %v, %v", commits, err)
+	}
+	first := commits[0]
+	if first.Hash != runGit(t, work, "rev-parse", "master") || first.Author != "tester" ||
+		first.Email != "tester@example.com" || first.Message != "first" || first.Date.IsZero() {
+		t.Errorf("log: unexpected commit %+v", first)
+	}
+
+	// remote branch, a local one tracking it is created
+	if err := b.Checkout(ctx, local, "dev"); err != nil {
+		t.Fatalf("checkout dev: %v", err)
+	}
+	if readFile(t, filepath.Join(local, "b.txt")) != "dev" {
+		t.Errorf("checkout dev: b.txt not checked out")
+	}
+	if branch := runGit(t, local, "rev-parse", "--abbrev-ref", "HEAD"); branch != "dev" {
+		t.Errorf("checkout dev: expected on branch dev, got %s", branch)
+	}
+
+	// local changes are discarded
+	if err := b.Checkout(ctx, local, "master"); err != nil {
+		t.Fatalf("checkout master: %v", err)
+	}
+	ioutil.WriteFile(filepath.Join(local, "a.txt"), []byte("dirty"), 0644)
+	if err := b.Checkout(ctx, local, "v1"); err != nil {
+		t.Fatalf("checkout v1: %v", err)
+	}
+	if readFile(t, filepath.Join(local, "a.txt")) != "v1" {
+		t.Errorf("checkout v1: local changes not discarded")
+	}
+	if head := runGit(t, local, "rev-parse", "HEAD"); head != first.Hash {
+		t.Errorf("checkout v1: expected HEAD %s, got %s", first.Hash, head)
+	}
+
+	if err := b.Checkout(ctx, local, "nope"); ErrorCode(err) != BranchNotExists {
+		t.Errorf("checkout nope: expected BranchNotExists, got %v", err)
+	}
+
+	// fetch and reset to the new commit
+	second := commit(t, work, "a.txt", "v2", "second")
+	runGit(t, work, "push", "-q", "origin", "master")
+	if err := b.Fetch(ctx, local); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if err := b.Fetch(ctx, local); err != nil {
+		t.Fatalf("fetch up to date: %v", err)
+	}
+	if err := b.Checkout(ctx, local, "master"); err != nil {
+		t.Fatalf("checkout master: %v", err)
+	}
+	if err := b.Reset(ctx, local, "origin/master"); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if readFile(t, filepath.Join(local, "a.txt")) != "v2" {
+		t.Errorf("reset: a.txt not updated")
+	}
+	if head := runGit(t, local, "rev-parse", "master"); head != second {
+		t.Errorf("reset: expected master at %s, got %s", second, head)
+	}
+	if err := b.Reset(ctx, local, "nope"); ErrorCode(err) != BranchNotExists {
+		t.Errorf("reset nope: expected BranchNotExists, got %v", err)
+	}
+
+	commits, err = b.Log(ctx, local, "HEAD", 0)
+	if err != nil || len(commits) != 2 || commits[0].Hash != second || commits[1].Hash != first.Hash {
+		t.Errorf("log: unexpected commits %v, %v", commits, err)
+	}
+	if commits, err = b.Log(ctx, local, second[:7], 1); err != nil || len(commits) != 1 || commits[0].Hash != second {
+		t.Errorf("log short hash: unexpected commits %v, %v", commits, err)
+	}
+}
+
+func TestBackendErrors(t *testing.T) {
+	bare, _ := newRemote(t)
+	for name, b := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+
+			if _, err := b.Log(ctx, dir, "HEAD", 1); ErrorCode(err) != NotARepo {
+				t.Errorf("log not a repo: expected NotARepo, got %v", err)
+			}
+			if err := b.Fetch(ctx, filepath.Join(dir, "missing")); ErrorCode(err) != NotARepo {
+				t.Errorf("fetch missing: expected NotARepo, got %v", err)
+			}
+			if err := b.Clone(ctx, filepath.Join(dir, "missing.git"), filepath.Join(dir, "a")); ErrorCode(err) != RemoteNotFound {
+				t.Errorf("clone missing remote: expected RemoteNotFound, got %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "a")); !os.IsNotExist(err) {
+				t.Errorf("clone missing remote: destination not cleaned up")
+			}
+
+			canceled, cancel := context.WithCancel(ctx)
+			cancel()
+			if err := b.Clone(canceled, bare, filepath.Join(dir, "b")); ErrorCode(err) != TimeoutError {
+				t.Errorf("clone canceled: expected TimeoutError, got %v", err)
+			}
+		})
+	}
+}
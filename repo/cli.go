@@ -0,0 +1,407 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+// logFormat fields of a commit separated by \x00, and commits by \x1e
+const logFormat = "--format=%H%x00%an%x00%ae%x00%at%x00%B%x1e"
+
+type cliBackend struct {
+	git string
+	// env added to the environment of git, to pass credentials
+	env []string
+}
+
+// NewCLIBackend returns a backend runs the git command
+func NewCLIBackend() Backend {
+	return cliBackend{git: "git"}
+}
+
+// run git with args in dir, stderr is returned as part of the error
+func (b cliBackend) run(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := b.command(ctx, dir, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	log.V(4).Infof("run git %v in %s", args, dir)
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// command returns a git command runs in dir
+func (b cliBackend) command(ctx context.Context, dir string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, b.git, args...)
+	cmd.Dir = dir
+	// never wait for a password from terminal, and keep messages in english, as we match them
+	cmd.Env = append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "LC_ALL=C"), b.env...)
+	return cmd
+}
+
+// error classifies err of git by its message, code is used if none matches
+func (b cliBackend) error(ctx context.Context, code int, op, path string, err error) error {
+	msg := err.Error()
+	switch {
+	case ctx.Err() != nil:
+		return newRepoError(TimeoutError, op, path, ctx.Err(), "canceled")
+	case containsAny(msg, "not a git repository", "cannot change to", "chdir"):
+		code = NotARepo
+	case containsAny(msg, "Authentication failed", "could not read Username", "could not read Password",
+		"Permission denied (publickey", "terminal prompts disabled", "Host key verification failed"):
+		code = AuthError
+	case containsAny(msg, "Remote branch"):
+		code = BranchNotExists
+	case containsAny(msg, "does not appear to be a git repository", "not found", "does not exist"):
+		code = RemoteNotFound
+	case containsAny(msg, "unknown revision", "did not match any", "bad revision", "Needed a single revision"):
+		code = BranchNotExists
+	}
+	return newRepoError(code, op, path, err, op+" failed")
+}
+
+func containsAny(s string, subs ...string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// open checks path is a git repo
+func (b cliBackend) open(ctx context.Context, op, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return newRepoError(NotARepo, op, path, err, "repo not exists")
+	}
+	if _, err := b.run(ctx, path, "rev-parse", "--git-dir"); err != nil {
+		return b.error(ctx, NotARepo, op, path, err)
+	}
+	return nil
+}
+
+// resolve rev to the hash of a commit
+func (b cliBackend) resolve(ctx context.Context, op, path, rev string) (string, error) {
+	out, err := b.run(ctx, path, "rev-parse", "--verify", "-q", rev+"^{commit}")
+	if err != nil {
+		if cerr := ctxError(ctx, op, path); cerr != nil {
+			return "", cerr
+		}
+		return "", newRepoError(BranchNotExists, op, path, err, "revision not exists: "+rev)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// withOptions returns a backend authenticates as o, cleanup must be called after used
+func (b cliBackend) withOptions(o *options) (cliBackend, func(), error) {
+	cleanup := func() {}
+	if err := o.validate(); err != nil {
+		return b, cleanup, err
+	}
+
+	var env []string
+	if o.username != "" || o.password != "" {
+		// passed by environment, so it is neither shown by ps nor saved in .git/config
+		cred := base64.StdEncoding.EncodeToString([]byte(o.username + ":" + o.password))
+		env = append(env, "GIT_CONFIG_COUNT=1", "GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0=Authorization: Basic "+cred)
+	}
+
+	if o.sshKeyFile != "" || o.knownHostsFile != "" || o.knownHosts != KnownHostsStrict {
+		args := []string{"ssh"}
+		switch o.knownHosts {
+		case KnownHostsStrict:
+			args = append(args, "-o", "StrictHostKeyChecking=yes")
+		case KnownHostsAcceptNew:
+			args = append(args, "-o", "StrictHostKeyChecking=accept-new")
+		case KnownHostsIgnore:
+			args = append(args, "-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null")
+		}
+		if o.knownHostsFile != "" && o.knownHosts != KnownHostsIgnore {
+			args = append(args, "-o", "UserKnownHostsFile="+shellQuote(o.knownHostsFile))
+		}
+		if o.sshKeyFile != "" {
+			args = append(args, "-i", shellQuote(o.sshKeyFile), "-o", "IdentitiesOnly=yes")
+		}
+
+		if o.sshPassphrase == "" {
+			args = append(args, "-o", "BatchMode=yes")
+		} else {
+			// ssh reads the passphrase from the askpass program, which prints it from environment
+			dir, err := ioutil.TempDir("", "repo-askpass")
+			if err != nil {
+				return b, cleanup, err
+			}
+			cleanup = func() { os.RemoveAll(dir) }
+			askpass := filepath.Join(dir, "askpass")
+			if err := ioutil.WriteFile(askpass, []byte("#!/bin/sh\nprintf '%s\\n' \"$REPO_SSH_PASSPHRASE\"\n"), 0700); err != nil {
+				cleanup()
+				return b, func() {}, err
+			}
+			env = append(env, "SSH_ASKPASS="+askpass, "SSH_ASKPASS_REQUIRE=force", "REPO_SSH_PASSPHRASE="+o.sshPassphrase)
+		}
+		env = append(env, "GIT_SSH_COMMAND="+strings.Join(args, " "))
+	}
+
+	b.env = append(append([]string(nil), b.env...), env...)
+	return b, cleanup, nil
+}
+
+// shellQuote quotes s for sh
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func (b cliBackend) Clone(ctx context.Context, url, path string, opts ...Option) error {
+	o := newOptions(opts)
+	b, cleanup, err := b.withOptions(o)
+	defer cleanup()
+	if err != nil {
+		return newRepoError(CloneError, "clone", path, err, "invalid options")
+	}
+
+	args := []string{"clone", "-q"}
+	if o.branch != "" {
+		args = append(args, "--branch", o.branch)
+	}
+	if o.depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(o.depth))
+	}
+	if o.singleBranch {
+		args = append(args, "--single-branch")
+	} else if o.depth > 0 {
+		// depth implies single branch
+		args = append(args, "--no-single-branch")
+	}
+	if len(o.sparsePaths) > 0 {
+		args = append(args, "--no-checkout")
+	}
+
+	if _, err := b.run(ctx, "", append(args, "--", url, path)...); err != nil {
+		return b.error(ctx, CloneError, "clone", path, err)
+	}
+	if len(o.sparsePaths) == 0 {
+		return nil
+	}
+
+	_, err = b.run(ctx, path, append([]string{"sparse-checkout", "set", "--no-cone"}, sparsePatterns(o.sparsePaths)...)...)
+	if err == nil {
+		_, err = b.run(ctx, path, "reset", "-q", "--hard", "HEAD")
+	}
+	if err != nil {
+		os.RemoveAll(path)
+		return b.error(ctx, CloneError, "clone", path, err)
+	}
+	return nil
+}
+
+func (b cliBackend) Fetch(ctx context.Context, path string, opts ...Option) error {
+	o := newOptions(opts)
+	b, cleanup, err := b.withOptions(o)
+	defer cleanup()
+	if err != nil {
+		return newRepoError(FetchError, "fetch", path, err, "invalid options")
+	}
+
+	if err := b.open(ctx, "fetch", path); err != nil {
+		return err
+	}
+	args := []string{"fetch", "-q", "--prune"}
+	if o.depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(o.depth))
+	}
+	if _, err := b.run(ctx, path, append(args, "origin")...); err != nil {
+		return b.error(ctx, FetchError, "fetch", path, err)
+	}
+	return nil
+}
+
+func (b cliBackend) Checkout(ctx context.Context, path, rev string) error {
+	if err := b.open(ctx, "checkout", path); err != nil {
+		return err
+	}
+
+	var err error
+	if _, lerr := b.run(ctx, path, "rev-parse", "--verify", "-q", "refs/heads/"+rev); lerr == nil {
+		_, err = b.run(ctx, path, "checkout", "-q", "-f", rev, "--")
+	} else if _, rerr := b.run(ctx, path, "rev-parse", "--verify", "-q", "refs/remotes/origin/"+rev); rerr == nil {
+		_, err = b.run(ctx, path, "checkout", "-q", "-f", "-b", rev, "--track", "origin/"+rev, "--")
+	} else {
+		hash, herr := b.resolve(ctx, "checkout", path, rev)
+		if herr != nil {
+			return herr
+		}
+		_, err = b.run(ctx, path, "checkout", "-q", "-f", "--detach", hash, "--")
+	}
+
+	if err != nil {
+		return b.error(ctx, CheckOutError, "checkout", path, err)
+	}
+	return nil
+}
+
+func (b cliBackend) Reset(ctx context.Context, path, rev string) error {
+	if err := b.open(ctx, "reset", path); err != nil {
+		return err
+	}
+	hash, err := b.resolve(ctx, "reset", path, rev)
+	if err != nil {
+		return err
+	}
+	if _, err := b.run(ctx, path, "reset", "-q", "--hard", hash, "--"); err != nil {
+		return b.error(ctx, ResetError, "reset", path, err)
+	}
+	return nil
+}
+
+func (b cliBackend) Log(ctx context.Context, path, rev string, n int) ([]Commit, error) {
+	if err := b.open(ctx, "log", path); err != nil {
+		return nil, err
+	}
+	hash, err := b.resolve(ctx, "log", path, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"log", logFormat}
+	if n > 0 {
+		args = append(args, "-n", strconv.Itoa(n))
+	}
+	out, err := b.run(ctx, path, append(args, hash, "--")...)
+	if err != nil {
+		return nil, b.error(ctx, UnknownError, "log", path, err)
+	}
+
+	var commits []Commit
+	for _, record := range strings.Split(out, "\x1e") {
+		fields := strings.SplitN(strings.TrimLeft(record, "\n"), "\x00", 5)
+		if len(fields) != 5 {
+			continue
+		}
+		sec, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return nil, newRepoError(UnknownError, "log", path, err, "invalid commit time: "+fields[3])
+		}
+		commits = append(commits, Commit{
+			Hash:    fields[0],
+			Author:  fields[1],
+			Email:   fields[2],
+			Date:    time.Unix(sec, 0),
+			Message: strings.TrimSpace(fields[4]),
+		})
+	}
+	return commits, nil
+}
+
+func (b cliBackend) Resolve(ctx context.Context, path, rev string) (string, error) {
+	if err := b.open(ctx, "resolve", path); err != nil {
+		return "", err
+	}
+	return b.resolve(ctx, "resolve", path, rev)
+}
+
+func (b cliBackend) Head(ctx context.Context, path string) (*Revision, error) {
+	commits, err := b.Log(ctx, path, "HEAD", 1)
+	if err != nil {
+		return nil, err
+	}
+	rev := &Revision{Commit: commits[0]}
+
+	// fails if HEAD is detached
+	if out, err := b.run(ctx, path, "symbolic-ref", "-q", "--short", "HEAD"); err == nil {
+		rev.Branch = strings.TrimSpace(out)
+	}
+	out, err := b.run(ctx, path, "status", "--porcelain")
+	if err != nil {
+		return nil, b.error(ctx, UnknownError, "status", path, err)
+	}
+	rev.Dirty = strings.TrimSpace(out) != ""
+	return rev, nil
+}
+
+func (b cliBackend) Diff(ctx context.Context, path, from, to string) ([]string, error) {
+	if err := b.open(ctx, "diff", path); err != nil {
+		return nil, err
+	}
+	fromHash, err := b.resolve(ctx, "diff", path, from)
+	if err != nil {
+		return nil, err
+	}
+	toHash, err := b.resolve(ctx, "diff", path, to)
+	if err != nil {
+		return nil, err
+	}
+	out, err := b.run(ctx, path, "diff", "--name-only", "--no-renames", "-z", fromHash, toHash, "--")
+	if err != nil {
+		return nil, b.error(ctx, UnknownError, "diff", path, err)
+	}
+
+	var files []string
+	for _, f := range strings.Split(out, "\x00") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (b cliBackend) Archive(ctx context.Context, path, rev, dst string) error {
+	if err := b.open(ctx, "archive", path); err != nil {
+		return err
+	}
+	hash, err := b.resolve(ctx, "archive", path, rev)
+	if err != nil {
+		return err
+	}
+
+	// the archive is extracted while read, git is killed if extracting failed
+	actx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd := b.command(actx, path, "archive", "--format=tar", hash)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return newRepoError(UnknownError, "archive", path, err, "archive failed")
+	}
+	log.V(4).Infof("run git archive %s in %s", hash, path)
+	if err := cmd.Start(); err != nil {
+		return b.error(ctx, UnknownError, "archive", path, err)
+	}
+	xerr := extractTar(stdout, dst)
+	if xerr != nil {
+		cancel()
+	} else {
+		// padding after the end of the archive
+		io.Copy(ioutil.Discard, stdout)
+	}
+	werr := cmd.Wait()
+	if xerr != nil {
+		if cerr := ctxError(ctx, "archive", path); cerr != nil {
+			return cerr
+		}
+		return newRepoError(PermissionError, "archive", path, xerr, "write files to "+dst)
+	}
+	if werr != nil {
+		return b.error(ctx, UnknownError, "archive", path,
+			fmt.Errorf("git archive: %v: %s", werr, strings.TrimSpace(stderr.String())))
+	}
+	return nil
+}
@@ -0,0 +1,382 @@
+package repo
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/golang/glog"
+)
+
+const (
+	// DefaultKeepRevisions number of revisions kept by a Deployer for rollback
+	DefaultKeepRevisions = 5
+
+	// currentLink points to the revision dir deployed
+	currentLink = "current"
+	// revisionsDir holds a dir of each revision, named by its hash
+	revisionsDir = "revisions"
+	// historyFile hashes of revisions deployed, newest first
+	historyFile = "history"
+	// tmpPrefix of dirs being written, removed by gc if left by a crash
+	tmpPrefix = ".tmp-"
+)
+
+// Deployer checks out each revision of a repo into its own dir under root,
+// and atomically flips the symlink root/current to it, so readers of root/current
+// never see a half updated tree, and a failed checkout leaves it unchanged.
+//
+// Layout of root:
+//
+//	current -> revisions/<hash>
+//	history
+//	revisions/<hash>/...
+type Deployer struct {
+	repo *Repo
+	root string
+	keep int
+}
+
+// NewDeployer deploys revisions of r under root, the last keep revisions are kept
+// for rollback, DefaultKeepRevisions if keep is not positive
+func NewDeployer(r *Repo, root string, keep int) *Deployer {
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	if keep <= 0 {
+		keep = DefaultKeepRevisions
+	}
+	return &Deployer{repo: r, root: filepath.Clean(root), keep: keep}
+}
+
+// Current returns path of the current link, which readers should use
+func (d *Deployer) Current() string {
+	return filepath.Join(d.root, currentLink)
+}
+
+// CurrentRevision returns hash of the revision deployed, empty if none
+func (d *Deployer) CurrentRevision() (string, error) {
+	target, err := os.Readlink(d.Current())
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return filepath.Base(target), nil
+}
+
+// History returns hashes of revisions kept, newest first, the first one is current
+func (d *Deployer) History() ([]string, error) {
+	f, err := os.Open(filepath.Join(d.root, historyFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ret []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if line := strings.TrimSpace(s.Text()); line != "" {
+			ret = append(ret, line)
+		}
+	}
+	return ret, s.Err()
+}
+
+// Deploy checks out rev into its own dir, if not yet, and makes it current,
+// rev is resolved as Repo.Resolve. Returns hash of the revision
+func (d *Deployer) Deploy(ctx context.Context, rev string) (string, error) {
+	unlock, err := d.repo.lock(ctx, "deploy")
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	hash, err := d.repo.resolve(ctx, "deploy", rev)
+	if err != nil {
+		return "", err
+	}
+	if err := d.checkout(ctx, hash); err != nil {
+		return "", err
+	}
+
+	history, err := d.History()
+	if err != nil {
+		return "", newRepoError(UnknownError, "deploy", d.root, err, "read history")
+	}
+	history = append([]string{hash}, remove(history, hash)...)
+	if err := d.activate(hash, history); err != nil {
+		return "", err
+	}
+	log.Infof("deploy %s: %s is current", d.root, hash)
+	return hash, nil
+}
+
+// Rollback makes the previous revision in history current, the current one is removed.
+// Returns hash of the revision
+func (d *Deployer) Rollback(ctx context.Context) (string, error) {
+	unlock, err := d.repo.lock(ctx, "rollback")
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	history, err := d.History()
+	if err != nil {
+		return "", newRepoError(UnknownError, "rollback", d.root, err, "read history")
+	}
+	if len(history) < 2 {
+		return "", newRepoError(BranchNotExists, "rollback", d.root, nil, "no previous revision")
+	}
+	prev := history[1]
+	if _, err := os.Stat(d.revisionDir(prev)); err != nil {
+		return "", newRepoError(BranchNotExists, "rollback", d.root, err, "previous revision removed: "+prev)
+	}
+	if err := d.activate(prev, history[1:]); err != nil {
+		return "", err
+	}
+	log.Infof("rollback %s: %s -> %s", d.root, history[0], prev)
+	return prev, nil
+}
+
+func (d *Deployer) revisionDir(hash string) string {
+	return filepath.Join(d.root, revisionsDir, hash)
+}
+
+// checkout writes files of hash to its dir, through a tmp dir renamed when finished
+func (d *Deployer) checkout(ctx context.Context, hash string) error {
+	dir := d.revisionDir(hash)
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return newRepoError(PermissionError, "deploy", d.root, err, "don't have permission to create dir")
+	}
+	tmp, err := ioutil.TempDir(filepath.Dir(dir), tmpPrefix+hash+"-")
+	if err != nil {
+		return newRepoError(PermissionError, "deploy", d.root, err, "don't have permission to create dir")
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		os.RemoveAll(tmp)
+		return newRepoError(PermissionError, "deploy", d.root, err, "chmod dir")
+	}
+	if err := d.repo.backend.Archive(ctx, d.repo.path, hash, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		os.RemoveAll(tmp)
+		return newRepoError(CheckOutError, "deploy", d.root, err, "rename dir")
+	}
+	return nil
+}
+
+// activate points the current link to hash, saves history, and removes revisions not in
+// the first keep of history
+func (d *Deployer) activate(hash string, history []string) error {
+	link := d.Current()
+	tmp := fmt.Sprintf("%s%s.%d", tmpPrefix, currentLink, os.Getpid())
+	tmp = filepath.Join(d.root, tmp)
+	os.Remove(tmp)
+	if err := os.Symlink(filepath.Join(revisionsDir, hash), tmp); err != nil {
+		return newRepoError(CheckOutError, "deploy", d.root, err, "create link")
+	}
+	// rename is atomic, where removing and creating the link is not
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return newRepoError(CheckOutError, "deploy", d.root, err, "replace link")
+	}
+
+	if len(history) > d.keep {
+		history = history[:d.keep]
+	}
+	if err := writeFileAtomic(filepath.Join(d.root, historyFile), []byte(strings.Join(history, "\n")+"\n")); err != nil {
+		return newRepoError(UnknownError, "deploy", d.root, err, "write history")
+	}
+	if err := d.gc(history); err != nil {
+		// revisions are deployed, old ones will be removed next time
+		log.Warningf("deploy %s: gc: %v", d.root, err)
+	}
+	return nil
+}
+
+// gc removes revision dirs not in history, and tmp dirs left
+func (d *Deployer) gc(history []string) error {
+	infos, err := ioutil.ReadDir(filepath.Join(d.root, revisionsDir))
+	if err != nil {
+		return err
+	}
+	kept := map[string]bool{}
+	for _, h := range history {
+		kept[h] = true
+	}
+	for _, fi := range infos {
+		if kept[fi.Name()] {
+			continue
+		}
+		log.V(4).Infof("deploy %s: remove revision %s", d.root, fi.Name())
+		if err := os.RemoveAll(filepath.Join(d.root, revisionsDir, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func remove(list []string, s string) []string {
+	var ret []string
+	for _, v := range list {
+		if v != s {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// writeFileAtomic writes a tmp file and renames it to name
+func writeFileAtomic(name string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(name), tmpPrefix+filepath.Base(name)+"-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// extractTar writes regular files, dirs and symlinks of the tar stream r to dir dst.
+// Files and targets of symlinks out of dst are rejected, as the content is from the remote
+func extractTar(r io.Reader, dst string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return checkLinks(dst)
+		} else if err != nil {
+			return err
+		}
+
+		name, err := archivePath(dst, hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(name, 0755)
+		case tar.TypeSymlink:
+			if err = checkLink(dst, name, hdr.Linkname); err == nil {
+				if err = os.MkdirAll(filepath.Dir(name), 0755); err == nil {
+					err = os.Symlink(hdr.Linkname, name)
+				}
+			}
+		case tar.TypeReg:
+			if err = os.MkdirAll(filepath.Dir(name), 0755); err == nil {
+				err = writeTarFile(tr, name, os.FileMode(hdr.Mode).Perm())
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// archivePath returns path of file name of an archive in dst, an error if it's out of dst,
+// or it's written through a symlink, as a relative target resolves from the real location
+// of the link, a symlink in dst, like a -> ., may lead a later entry, like a/b -> .., out of dst
+func archivePath(dst, name string) (string, error) {
+	dst = filepath.Clean(dst)
+	p := filepath.Join(dst, filepath.FromSlash(name))
+	if !within(dst, p) {
+		return "", fmt.Errorf("invalid file name in archive: %s", name)
+	}
+	// p itself, and its parents under dst
+	for q := p; q != dst; q = filepath.Dir(q) {
+		fi, err := os.Lstat(q)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("invalid file name in archive: %s, written through symlink %s", name, q)
+		}
+	}
+	return p, nil
+}
+
+// checkLink returns an error if target of symlink p is absolute, or out of dst as a path.
+// It's checked again by checkLinks after all the symlinks are written
+func checkLink(dst, p, target string) error {
+	if filepath.IsAbs(target) {
+		return fmt.Errorf("invalid symlink in archive: %s -> %s, target is absolute", p, target)
+	}
+	if !within(dst, filepath.Join(filepath.Dir(p), target)) {
+		return fmt.Errorf("invalid symlink in archive: %s -> %s, target is out of %s", p, target, dst)
+	}
+	return nil
+}
+
+// checkLinks returns an error if any symlink in dst resolves to a file out of dst,
+// e.g. through another symlink
+func checkLinks(dst string) error {
+	root, err := filepath.EvalSymlinks(dst)
+	if os.IsNotExist(err) {
+		// nothing written
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return filepath.Walk(dst, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			return err
+		}
+		real, err := filepath.EvalSymlinks(p)
+		if os.IsNotExist(err) {
+			// dangling, it leads nowhere
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("invalid symlink in archive: %s: %v", p, err)
+		}
+		if !within(root, real) {
+			return fmt.Errorf("invalid symlink in archive: %s, resolves to %s out of %s", p, real, dst)
+		}
+		return nil
+	})
+}
+
+// within whether the cleaned path p is dir or under it
+func within(dir, p string) bool {
+	dir = filepath.Clean(dir)
+	return p == dir || strings.HasPrefix(p, dir+string(filepath.Separator))
+}
+
+func writeTarFile(r io.Reader, name string, perm os.FileMode) error {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
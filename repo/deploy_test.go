@@ -0,0 +1,180 @@
+package repo
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	for name, b := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			bare, work := newRemote(t)
+			os.MkdirAll(filepath.Join(work, "bin"), 0755)
+			ioutil.WriteFile(filepath.Join(work, "bin", "run.sh"), []byte("#!/bin/sh\n"), 0755)
+			os.Symlink("../a.txt", filepath.Join(work, "bin", "a"))
+			runGit(t, work, "add", "bin")
+			runGit(t, work, "commit", "-q", "-m", "bin")
+			runGit(t, work, "push", "-q", "origin", "master")
+
+			local := filepath.Join(t.TempDir(), "local")
+			if err := b.Clone(ctx, bare, local); err != nil {
+				t.Fatalf("clone: %v", err)
+			}
+			dst := t.TempDir()
+			if err := b.Archive(ctx, local, "master", dst); err != nil {
+				t.Fatalf("archive: %v", err)
+			}
+			if readFile(t, filepath.Join(dst, "bin", "a")) != "v1" {
+				t.Errorf("archive: symlink bin/a not written")
+			}
+			if fi, err := os.Stat(filepath.Join(dst, "bin", "run.sh")); err != nil || fi.Mode().Perm()&0100 == 0 {
+				t.Errorf("archive: bin/run.sh not executable, %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(dst, ".git")); !os.IsNotExist(err) {
+				t.Errorf("archive: .git written")
+			}
+			if err := b.Archive(ctx, local, "nope", dst); ErrorCode(err) != BranchNotExists {
+				t.Errorf("archive nope: expected BranchNotExists, got %v", err)
+			}
+		})
+	}
+}
+
+func TestArchiveUnsafeLink(t *testing.T) {
+	for name, b := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			bare, work := newRemote(t)
+			os.Symlink("../../outside", filepath.Join(work, "escape"))
+			runGit(t, work, "add", "escape")
+			runGit(t, work, "commit", "-q", "-m", "escape")
+			runGit(t, work, "push", "-q", "origin", "master")
+
+			local := filepath.Join(t.TempDir(), "local")
+			if err := b.Clone(ctx, bare, local); err != nil {
+				t.Fatalf("clone: %v", err)
+			}
+			dst := t.TempDir()
+			if err := b.Archive(ctx, local, "master", dst); err == nil {
+				t.Errorf("archive: expected error for symlink out of dst")
+			}
+			if _, err := os.Lstat(filepath.Join(dst, "escape")); !os.IsNotExist(err) {
+				t.Errorf("archive: symlink out of dst written")
+			}
+		})
+	}
+}
+
+func TestExtractTarUnsafe(t *testing.T) {
+	link := func(name, target string) tar.Header {
+		return tar.Header{Name: name, Typeflag: tar.TypeSymlink, Linkname: target}
+	}
+	file := func(name string) tar.Header {
+		return tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: 3}
+	}
+	cases := []struct {
+		name string
+		hdrs []tar.Header
+	}{
+		{"parent", []tar.Header{file("../pwn")}},
+		{"absolute link", []tar.Header{link("a", "/etc/passwd")}},
+		{"parent link", []tar.Header{link("d/a", "../../x")}},
+		// a/b is written to dst, and b to the parent of dst through a
+		{"through link", []tar.Header{link("a", "."), link("a/b", ".."), file("b/pwn")}},
+		// the target is in dst as a path, but .. is of the real dir of a
+		{"link through link", []tar.Header{link("d/a", "."), link("c", "d/a/../..")}},
+	}
+	for _, c := range cases {
+		parent := t.TempDir()
+		dst := filepath.Join(parent, "dst")
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for i := range c.hdrs {
+			tw.WriteHeader(&c.hdrs[i])
+			if c.hdrs[i].Typeflag == tar.TypeReg {
+				tw.Write([]byte("pwn"))
+			}
+		}
+		tw.Close()
+		if err := extractTar(&buf, dst); err == nil {
+			t.Errorf("%s: expected error", c.name)
+		}
+		if _, err := os.Lstat(filepath.Join(parent, "pwn")); !os.IsNotExist(err) {
+			t.Errorf("%s: file written out of dst", c.name)
+		}
+	}
+
+	// links in dst are fine
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "d/a", Typeflag: tar.TypeSymlink, Linkname: "../b"})
+	tw.Close()
+	if err := extractTar(&buf, t.TempDir()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDeployer(t *testing.T) {
+	ctx := context.Background()
+	bare, work := newRemote(t)
+	r := New(filepath.Join(t.TempDir(), "local"), bare)
+	if err := r.Update(ctx); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	first := runGit(t, work, "rev-parse", "master")
+	dev := runGit(t, work, "rev-parse", "dev")
+	second := commit(t, work, "a.txt", "v2", "second")
+	runGit(t, work, "push", "-q", "origin", "master")
+	if err := r.Fetch(ctx); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+
+	d := NewDeployer(r, t.TempDir(), 2)
+	if hash, err := d.CurrentRevision(); err != nil || hash != "" {
+		t.Errorf("current before deploy: expected none, got %s, %v", hash, err)
+	}
+	for _, rev := range []string{"v1", "origin/dev", "origin/master"} {
+		if _, err := d.Deploy(ctx, rev); err != nil {
+			t.Fatalf("deploy %s: %v", rev, err)
+		}
+	}
+	if readFile(t, filepath.Join(d.Current(), "a.txt")) != "v2" {
+		t.Errorf("deploy: current is not the last deployed")
+	}
+	if history, err := d.History(); err != nil || !reflect.DeepEqual(history, []string{second, dev}) {
+		t.Errorf("history: unexpected %v, %v", history, err)
+	}
+	if _, err := os.Stat(d.revisionDir(first)); !os.IsNotExist(err) {
+		t.Errorf("gc: revision %s not removed", first)
+	}
+
+	// failed deploy leaves current unchanged
+	if _, err := d.Deploy(ctx, "nope"); ErrorCode(err) != BranchNotExists {
+		t.Errorf("deploy nope: expected BranchNotExists, got %v", err)
+	}
+	if hash, err := d.CurrentRevision(); err != nil || hash != second {
+		t.Errorf("current: expected %s, got %s, %v", second, hash, err)
+	}
+
+	if hash, err := d.Rollback(ctx); err != nil || hash != dev {
+		t.Fatalf("rollback: expected %s, got %s, %v", dev, hash, err)
+	}
+	if readFile(t, filepath.Join(d.Current(), "b.txt")) != "dev" {
+		t.Errorf("rollback: current is not dev")
+	}
+	if _, err := d.Rollback(ctx); ErrorCode(err) != BranchNotExists {
+		t.Errorf("rollback without previous: expected BranchNotExists, got %v", err)
+	}
+
+	infos, _ := ioutil.ReadDir(filepath.Join(d.root, revisionsDir))
+	if len(infos) != 1 || infos[0].Name() != dev {
+		t.Errorf("gc: unexpected revision dirs %v", infos)
+	}
+}
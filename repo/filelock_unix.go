@@ -0,0 +1,45 @@
+//go:build !windows
+// +build !windows
+
+package repo
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+)
+
+// lockPollInterval how often a held file lock is retried
+const lockPollInterval = 50 * time.Millisecond
+
+// lockFile takes an exclusive flock of name, waits until it's released or ctx is done
+func lockFile(ctx context.Context, name string) (func(), error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return func() {
+				syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+				f.Close()
+			}, nil
+		}
+		if err != syscall.EWOULDBLOCK {
+			f.Close()
+			return nil, err
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package repo
+
+import (
+	"context"
+	"errors"
+)
+
+// lockFile is not supported on windows
+func lockFile(ctx context.Context, name string) (func(), error) {
+	return nil, errors.New("file lock is not supported on windows")
+}
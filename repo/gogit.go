@@ -0,0 +1,580 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+)
+
+type goGitBackend struct{}
+
+// NewGoGitBackend returns a backend implemented in pure go, no git command is required
+func NewGoGitBackend() Backend {
+	return goGitBackend{}
+}
+
+// error classifies err of go-git, code is used if none matches
+func (b goGitBackend) error(ctx context.Context, code int, op, path string, err error) error {
+	switch {
+	case ctx.Err() != nil:
+		return newRepoError(TimeoutError, op, path, ctx.Err(), "canceled")
+	case errors.Is(err, git.ErrRepositoryNotExists):
+		code = NotARepo
+	case errors.Is(err, transport.ErrAuthenticationRequired), errors.Is(err, transport.ErrAuthorizationFailed):
+		code = AuthError
+	case errors.Is(err, transport.ErrRepositoryNotFound):
+		code = RemoteNotFound
+	case errors.Is(err, plumbing.ErrReferenceNotFound), errors.Is(err, plumbing.ErrObjectNotFound):
+		code = BranchNotExists
+	}
+	return newRepoError(code, op, path, err, op+" failed")
+}
+
+func (b goGitBackend) open(ctx context.Context, op, path string) (*git.Repository, error) {
+	if err := ctxError(ctx, op, path); err != nil {
+		return nil, err
+	}
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return nil, b.error(ctx, NotARepo, op, path, err)
+	}
+	return r, nil
+}
+
+func (b goGitBackend) resolve(ctx context.Context, r *git.Repository, op, path, rev string) (plumbing.Hash, error) {
+	h, err := r.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return plumbing.ZeroHash, newRepoError(BranchNotExists, op, path, err, "revision not exists: "+rev)
+	}
+	return *h, nil
+}
+
+// goGitAuth returns the auth method of url as o, nil to use the default of go-git
+func (o *options) goGitAuth(url string) (transport.AuthMethod, error) {
+	ep, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ep.Protocol {
+	case "http", "https":
+		if o.username == "" && o.password == "" {
+			return nil, nil
+		}
+		return &githttp.BasicAuth{Username: o.username, Password: o.password}, nil
+	case "ssh":
+		if o.sshKeyFile == "" && o.knownHostsFile == "" && o.knownHosts == KnownHostsStrict {
+			return nil, nil
+		}
+		user := ep.User
+		if user == "" {
+			user = gitssh.DefaultUsername
+		}
+		callback, err := o.hostKeyCallback()
+		if err != nil {
+			return nil, err
+		}
+		if o.sshKeyFile == "" {
+			a, err := gitssh.NewSSHAgentAuth(user)
+			if err != nil {
+				return nil, err
+			}
+			a.HostKeyCallback = callback
+			return a, nil
+		}
+		a, err := gitssh.NewPublicKeysFromFile(user, o.sshKeyFile, o.sshPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		a.HostKeyCallback = callback
+		return a, nil
+	}
+	return nil, nil
+}
+
+func (b goGitBackend) Clone(ctx context.Context, url, path string, opts ...Option) error {
+	o := newOptions(opts)
+	if err := o.validate(); err != nil {
+		return newRepoError(CloneError, "clone", path, err, "invalid options")
+	}
+	auth, err := o.goGitAuth(url)
+	if err != nil {
+		return newRepoError(AuthError, "clone", path, err, "invalid auth")
+	}
+
+	// go-git clones into a non empty dir, while git refuses
+	if entries, err := ioutil.ReadDir(path); err == nil && len(entries) > 0 {
+		return newRepoError(CloneError, "clone", path, os.ErrExist, "destination path already exists and is not an empty directory")
+	}
+
+	co := &git.CloneOptions{
+		URL:          url,
+		Auth:         auth,
+		Depth:        o.depth,
+		SingleBranch: o.singleBranch,
+		NoCheckout:   len(o.sparsePaths) > 0,
+	}
+	if o.branch != "" {
+		co.ReferenceName = plumbing.NewBranchReferenceName(o.branch)
+	}
+	r, err := git.PlainCloneContext(ctx, path, false, co)
+	if err != nil {
+		return b.error(ctx, CloneError, "clone", path, err)
+	}
+	if len(o.sparsePaths) == 0 {
+		return nil
+	}
+
+	if err := b.sparseCheckout(r, path, o.sparsePaths); err != nil {
+		os.RemoveAll(path)
+		return b.error(ctx, CloneError, "clone", path, err)
+	}
+	return nil
+}
+
+// sparseCheckout saves the sparse paths as git sparse-checkout does in non cone mode,
+// so later checkouts by both backends keep it, and resets the work tree to HEAD
+func (b goGitBackend) sparseCheckout(r *git.Repository, path string, paths []string) error {
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+	cfg.Raw.Section("core").SetOption("sparseCheckout", "true")
+	if err := r.SetConfig(cfg); err != nil {
+		return err
+	}
+	info := filepath.Join(path, git.GitDirName, "info")
+	if err := os.MkdirAll(info, 0755); err != nil {
+		return err
+	}
+	patterns := strings.Join(sparsePatterns(paths), "\n") + "\n"
+	if err := ioutil.WriteFile(filepath.Join(info, "sparse-checkout"), []byte(patterns), 0644); err != nil {
+		return err
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	if err := wt.Reset(&git.ResetOptions{Commit: head.Hash(), Mode: git.HardReset}); err != nil {
+		return err
+	}
+	return applySparse(r, path)
+}
+
+// applySparse marks files not under the sparse dirs skip-worktree and removes them,
+// as Worktree.ResetSparsely of go-git skips entries in the index only, not the ones added by the reset
+func applySparse(r *git.Repository, path string) error {
+	dirs := sparseDirs(path)
+	if len(dirs) == 0 {
+		return nil
+	}
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range idx.Entries {
+		included := false
+		for _, dir := range dirs {
+			if strings.HasPrefix(e.Name, dir) {
+				included = true
+				break
+			}
+		}
+		if included || e.SkipWorktree {
+			continue
+		}
+		e.SkipWorktree = true
+		if err := os.Remove(filepath.Join(path, e.Name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		// remove the dirs left empty, Remove fails on non empty ones
+		for dir := filepath.Dir(e.Name); dir != "."; dir = filepath.Dir(dir) {
+			if os.Remove(filepath.Join(path, dir)) != nil {
+				break
+			}
+		}
+	}
+
+	// skip-worktree is an extended flag, which requires version 3
+	if idx.Version < 3 {
+		idx.Version = 3
+	}
+	return r.Storer.SetIndex(idx)
+}
+
+// sparseDirs reads the dirs saved by sparseCheckout, nil if it's not a sparse checkout
+func sparseDirs(path string) []string {
+	data, err := ioutil.ReadFile(filepath.Join(path, git.GitDirName, "info", "sparse-checkout"))
+	if err != nil {
+		return nil
+	}
+	var dirs []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/") && len(line) > 1 {
+			dirs = append(dirs, line[1:])
+		}
+	}
+	return dirs
+}
+
+func (b goGitBackend) Fetch(ctx context.Context, path string, opts ...Option) error {
+	o := newOptions(opts)
+	if err := o.validate(); err != nil {
+		return newRepoError(FetchError, "fetch", path, err, "invalid options")
+	}
+	r, err := b.open(ctx, "fetch", path)
+	if err != nil {
+		return err
+	}
+	remote, err := r.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return b.error(ctx, FetchError, "fetch", path, err)
+	}
+	auth, err := o.goGitAuth(remote.Config().URLs[0])
+	if err != nil {
+		return newRepoError(AuthError, "fetch", path, err, "invalid auth")
+	}
+
+	err = r.FetchContext(ctx, &git.FetchOptions{RemoteName: git.DefaultRemoteName, Auth: auth, Depth: o.depth})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return b.error(ctx, FetchError, "fetch", path, err)
+	}
+	if err := b.prune(ctx, r, remote, auth); err != nil {
+		return b.error(ctx, FetchError, "fetch", path, err)
+	}
+	return nil
+}
+
+// prune removes remote tracking branches deleted in the remote, like git fetch --prune
+func (b goGitBackend) prune(ctx context.Context, r *git.Repository, remote *git.Remote, auth transport.AuthMethod) error {
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+	if err != nil {
+		return err
+	}
+	exists := map[string]bool{}
+	for _, ref := range refs {
+		if ref.Name().IsBranch() {
+			exists[ref.Name().Short()] = true
+		}
+	}
+
+	iter, err := r.References()
+	if err != nil {
+		return err
+	}
+	var stale []plumbing.ReferenceName
+	prefix := "refs/remotes/" + git.DefaultRemoteName + "/"
+	iter.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().String()
+		if strings.HasPrefix(name, prefix) && name != prefix+"HEAD" && !exists[strings.TrimPrefix(name, prefix)] {
+			stale = append(stale, ref.Name())
+		}
+		return nil
+	})
+	for _, name := range stale {
+		if err := r.Storer.RemoveReference(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b goGitBackend) Checkout(ctx context.Context, path, rev string) error {
+	r, err := b.open(ctx, "checkout", path)
+	if err != nil {
+		return err
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		return b.error(ctx, NotARepo, "checkout", path, err)
+	}
+
+	branch := plumbing.NewBranchReferenceName(rev)
+	if _, lerr := r.Reference(branch, false); lerr == nil {
+		err = wt.Checkout(&git.CheckoutOptions{Branch: branch, Force: true})
+	} else if ref, rerr := r.Reference(plumbing.NewRemoteReferenceName(git.DefaultRemoteName, rev), true); rerr == nil {
+		err = wt.Checkout(&git.CheckoutOptions{Branch: branch, Hash: ref.Hash(), Create: true, Force: true})
+		if err == nil {
+			err = r.CreateBranch(&config.Branch{Name: rev, Remote: git.DefaultRemoteName, Merge: branch})
+		}
+	} else {
+		hash, herr := b.resolve(ctx, r, "checkout", path, rev)
+		if herr != nil {
+			return herr
+		}
+		err = wt.Checkout(&git.CheckoutOptions{Hash: hash, Force: true})
+	}
+
+	if err == nil {
+		err = applySparse(r, path)
+	}
+	if err != nil {
+		return b.error(ctx, CheckOutError, "checkout", path, err)
+	}
+	return nil
+}
+
+func (b goGitBackend) Reset(ctx context.Context, path, rev string) error {
+	r, err := b.open(ctx, "reset", path)
+	if err != nil {
+		return err
+	}
+	hash, err := b.resolve(ctx, r, "reset", path, rev)
+	if err != nil {
+		return err
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		return b.error(ctx, NotARepo, "reset", path, err)
+	}
+	if err := wt.Reset(&git.ResetOptions{Commit: hash, Mode: git.HardReset}); err != nil {
+		return b.error(ctx, ResetError, "reset", path, err)
+	}
+	if err := applySparse(r, path); err != nil {
+		return b.error(ctx, ResetError, "reset", path, err)
+	}
+	return nil
+}
+
+func (b goGitBackend) Log(ctx context.Context, path, rev string, n int) ([]Commit, error) {
+	r, err := b.open(ctx, "log", path)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := b.resolve(ctx, r, "log", path, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	// walk the history newest first, as Repository.Log of go-git does not stop at
+	// the boundary of shallow clones, where git log does
+	shallow := map[plumbing.Hash]bool{}
+	hashes, err := r.Storer.Shallow()
+	if err != nil {
+		return nil, b.error(ctx, UnknownError, "log", path, err)
+	}
+	for _, h := range hashes {
+		shallow[h] = true
+	}
+
+	start, err := r.CommitObject(hash)
+	if err != nil {
+		return nil, b.error(ctx, UnknownError, "log", path, err)
+	}
+	queue := []*object.Commit{start}
+	seen := map[plumbing.Hash]bool{hash: true}
+	var commits []Commit
+	for len(queue) > 0 && (n <= 0 || len(commits) < n) {
+		sort.SliceStable(queue, func(i, j int) bool {
+			return queue[i].Committer.When.After(queue[j].Committer.When)
+		})
+		c := queue[0]
+		queue = queue[1:]
+		commits = append(commits, newCommit(c))
+		if shallow[c.Hash] {
+			continue
+		}
+
+		for _, p := range c.ParentHashes {
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			parent, err := r.CommitObject(p)
+			if err != nil {
+				return nil, b.error(ctx, UnknownError, "log", path, err)
+			}
+			queue = append(queue, parent)
+		}
+	}
+	return commits, nil
+}
+
+func (b goGitBackend) Resolve(ctx context.Context, path, rev string) (string, error) {
+	r, err := b.open(ctx, "resolve", path)
+	if err != nil {
+		return "", err
+	}
+	hash, err := b.resolve(ctx, r, "resolve", path, rev)
+	if err != nil {
+		return "", err
+	}
+	return hash.String(), nil
+}
+
+func (b goGitBackend) Head(ctx context.Context, path string) (*Revision, error) {
+	commits, err := b.Log(ctx, path, "HEAD", 1)
+	if err != nil {
+		return nil, err
+	}
+	rev := &Revision{Commit: commits[0]}
+
+	r, err := b.open(ctx, "status", path)
+	if err != nil {
+		return nil, err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return nil, b.error(ctx, UnknownError, "status", path, err)
+	}
+	if head.Name().IsBranch() {
+		rev.Branch = head.Name().Short()
+	}
+
+	wt, err := r.Worktree()
+	if err != nil {
+		return nil, b.error(ctx, NotARepo, "status", path, err)
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return nil, b.error(ctx, UnknownError, "status", path, err)
+	}
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return nil, b.error(ctx, UnknownError, "status", path, err)
+	}
+	skipped := map[string]bool{}
+	for _, e := range idx.Entries {
+		if e.SkipWorktree {
+			skipped[e.Name] = true
+		}
+	}
+	for file, s := range status {
+		// files not checked out by a sparse checkout are reported deleted by go-git
+		if skipped[file] && s.Staging == git.Unmodified && s.Worktree == git.Deleted {
+			continue
+		}
+		if s.Staging != git.Unmodified || s.Worktree != git.Unmodified {
+			rev.Dirty = true
+			break
+		}
+	}
+	return rev, nil
+}
+
+func (b goGitBackend) Diff(ctx context.Context, path, from, to string) ([]string, error) {
+	r, err := b.open(ctx, "diff", path)
+	if err != nil {
+		return nil, err
+	}
+	var trees [2]*object.Tree
+	for i, rev := range []string{from, to} {
+		hash, err := b.resolve(ctx, r, "diff", path, rev)
+		if err != nil {
+			return nil, err
+		}
+		c, err := r.CommitObject(hash)
+		if err != nil {
+			return nil, b.error(ctx, UnknownError, "diff", path, err)
+		}
+		if trees[i], err = c.Tree(); err != nil {
+			return nil, b.error(ctx, UnknownError, "diff", path, err)
+		}
+	}
+
+	changes, err := object.DiffTreeWithOptions(ctx, trees[0], trees[1], &object.DiffTreeOptions{})
+	if err != nil {
+		return nil, b.error(ctx, UnknownError, "diff", path, err)
+	}
+	set := map[string]bool{}
+	for _, c := range changes {
+		for _, name := range []string{c.From.Name, c.To.Name} {
+			if name != "" {
+				set[name] = true
+			}
+		}
+	}
+	var files []string
+	for f := range set {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (b goGitBackend) Archive(ctx context.Context, path, rev, dst string) error {
+	r, err := b.open(ctx, "archive", path)
+	if err != nil {
+		return err
+	}
+	hash, err := b.resolve(ctx, r, "archive", path, rev)
+	if err != nil {
+		return err
+	}
+	c, err := r.CommitObject(hash)
+	if err != nil {
+		return b.error(ctx, UnknownError, "archive", path, err)
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return b.error(ctx, UnknownError, "archive", path, err)
+	}
+
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name, err := archivePath(dst, f.Name)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return err
+		}
+		content, err := f.Contents()
+		if err != nil {
+			return err
+		}
+		switch f.Mode {
+		case filemode.Symlink:
+			if err := checkLink(dst, name, content); err != nil {
+				return err
+			}
+			return os.Symlink(content, name)
+		case filemode.Executable:
+			return ioutil.WriteFile(name, []byte(content), 0755)
+		default:
+			return ioutil.WriteFile(name, []byte(content), 0644)
+		}
+	})
+	if err == nil {
+		err = checkLinks(dst)
+	}
+	if err != nil {
+		if cerr := ctxError(ctx, "archive", path); cerr != nil {
+			return cerr
+		}
+		return newRepoError(PermissionError, "archive", path, err, "write files to "+dst)
+	}
+	return nil
+}
+
+func newCommit(c *object.Commit) Commit {
+	return Commit{
+		Hash:    c.Hash.String(),
+		Author:  c.Author.Name,
+		Email:   c.Author.Email,
+		Date:    c.Author.When,
+		Message: strings.TrimSpace(c.Message),
+	}
+}
@@ -0,0 +1,202 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// KnownHostsPolicy how host keys of ssh remotes are verified
+type KnownHostsPolicy string
+
+const (
+	// KnownHostsStrict host key must be in the known_hosts file, default
+	KnownHostsStrict KnownHostsPolicy = "strict"
+	// KnownHostsAcceptNew unknown hosts are added to the known_hosts file,
+	// but a changed key is rejected
+	KnownHostsAcceptNew KnownHostsPolicy = "accept-new"
+	// KnownHostsIgnore do not verify host keys, insecure
+	KnownHostsIgnore KnownHostsPolicy = "ignore"
+)
+
+// tokenUser username sent with a https token, most git servers accept any non empty one
+const tokenUser = "oauth2"
+
+type options struct {
+	username string
+	password string
+
+	sshKeyFile     string
+	sshPassphrase  string
+	knownHosts     KnownHostsPolicy
+	knownHostsFile string
+
+	branch       string
+	depth        int
+	singleBranch bool
+	sparsePaths  []string
+}
+
+// Option of clone and fetch, options not applicable to an operation are ignored
+type Option func(*options)
+
+// WithToken access token for https remotes
+func WithToken(token string) Option {
+	return func(o *options) {
+		o.username = tokenUser
+		o.password = token
+	}
+}
+
+// WithBasicAuth username and password for https remotes
+func WithBasicAuth(username, password string) Option {
+	return func(o *options) {
+		o.username = username
+		o.password = password
+	}
+}
+
+// WithSSHKey private key for ssh remotes, passphrase is required if the key is encrypted
+func WithSSHKey(keyFile, passphrase string) Option {
+	return func(o *options) {
+		o.sshKeyFile = keyFile
+		o.sshPassphrase = passphrase
+	}
+}
+
+// WithKnownHosts how host keys of ssh remotes are verified, file default to ~/.ssh/known_hosts
+func WithKnownHosts(policy KnownHostsPolicy, file string) Option {
+	return func(o *options) {
+		o.knownHosts = policy
+		o.knownHostsFile = file
+	}
+}
+
+// WithBranch branch checked out after clone, default to HEAD of the remote
+func WithBranch(branch string) Option {
+	return func(o *options) {
+		o.branch = branch
+	}
+}
+
+// WithDepth clone or fetch at most depth commits of history.
+// Use a file:// url for local remotes, as git ignores depth of local clones
+func WithDepth(depth int) Option {
+	return func(o *options) {
+		o.depth = depth
+	}
+}
+
+// WithSingleBranch only clone and fetch the branch checked out
+func WithSingleBranch() Option {
+	return func(o *options) {
+		o.singleBranch = true
+	}
+}
+
+// WithSparsePaths only check out files under these dirs, e.g. "conf" or "deploy/prod",
+// files at the top level are not checked out either
+func WithSparsePaths(paths ...string) Option {
+	return func(o *options) {
+		o.sparsePaths = append(o.sparsePaths, paths...)
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{knownHosts: KnownHostsStrict}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (o *options) validate() error {
+	switch o.knownHosts {
+	case KnownHostsStrict, KnownHostsAcceptNew, KnownHostsIgnore:
+	default:
+		return fmt.Errorf("unknown known hosts policy: %s", o.knownHosts)
+	}
+	if o.depth < 0 {
+		return fmt.Errorf("invalid depth: %d", o.depth)
+	}
+	for _, p := range o.sparsePaths {
+		if p = strings.Trim(p, "/"); p == "" || strings.Contains(p, "..") {
+			return fmt.Errorf("invalid sparse path: %q", p)
+		}
+	}
+	return nil
+}
+
+func (o *options) knownHostsPath() (string, error) {
+	if o.knownHostsFile != "" {
+		return o.knownHostsFile, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".ssh", "known_hosts"), nil
+}
+
+// sparsePatterns of the sparse-checkout file in non cone mode, "conf" is "/conf/"
+func sparsePatterns(paths []string) []string {
+	var ret []string
+	for _, p := range paths {
+		ret = append(ret, "/"+strings.Trim(p, "/")+"/")
+	}
+	return ret
+}
+
+// hostKeyCallback verifies host keys as the policy of o
+func (o *options) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	if o.knownHosts == KnownHostsIgnore {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	file, err := o.knownHostsPath()
+	if err != nil {
+		return nil, err
+	}
+	if o.knownHosts == KnownHostsAcceptNew {
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+	}
+
+	if o.knownHosts == KnownHostsStrict {
+		return knownhosts.New(file)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		// read the file every time, so hosts added are known
+		check, err := knownhosts.New(file)
+		if err != nil {
+			return err
+		}
+		err = check(hostname, remote, key)
+		var ke *knownhosts.KeyError
+		if !errors.As(err, &ke) || len(ke.Want) > 0 {
+			// known, or key changed
+			return err
+		}
+
+		f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+		return err
+	}, nil
+}
@@ -0,0 +1,249 @@
+package repo
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCloneShallow(t *testing.T) {
+	bare, work := newRemote(t)
+	commit(t, work, "a.txt", "v2", "second")
+	runGit(t, work, "push", "-q", "origin", "master")
+	url := "file://" + bare
+
+	for name, b := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+
+			local := filepath.Join(dir, "shallow")
+			if err := b.Clone(ctx, url, local, WithDepth(1)); err != nil {
+				t.Fatalf("clone: %v", err)
+			}
+			if commits, err := b.Log(ctx, local, "HEAD", 0); err != nil || len(commits) != 1 {
+				t.Errorf("expected 1 commit, got %v, %v", commits, err)
+			}
+			if err := b.Checkout(ctx, local, "dev"); err != nil {
+				t.Errorf("checkout dev of shallow clone: %v", err)
+			}
+
+			local = filepath.Join(dir, "single")
+			if err := b.Clone(ctx, url, local, WithBranch("dev"), WithSingleBranch()); err != nil {
+				t.Fatalf("clone: %v", err)
+			}
+			if branch := runGit(t, local, "rev-parse", "--abbrev-ref", "HEAD"); branch != "dev" {
+				t.Errorf("expected on branch dev, got %s", branch)
+			}
+			if _, err := b.Log(ctx, local, "origin/master", 1); ErrorCode(err) != BranchNotExists {
+				t.Errorf("expected master not cloned, got %v", err)
+			}
+
+			err := b.Clone(ctx, url, filepath.Join(dir, "nope"), WithBranch("nope"))
+			if ErrorCode(err) != BranchNotExists {
+				t.Errorf("clone missing branch: expected BranchNotExists, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCloneSparse(t *testing.T) {
+	bare, work := newRemote(t)
+	os.MkdirAll(filepath.Join(work, "conf"), 0755)
+	os.MkdirAll(filepath.Join(work, "other"), 0755)
+	commit(t, work, "conf/app.yaml", "v1", "add conf")
+	commit(t, work, "other/data", "v1", "add other")
+	runGit(t, work, "push", "-q", "origin", "master")
+
+	for name, b := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			local := filepath.Join(t.TempDir(), "local")
+			if err := b.Clone(ctx, bare, local, WithSparsePaths("conf")); err != nil {
+				t.Fatalf("clone: %v", err)
+			}
+
+			exists := func(name string) bool {
+				_, err := os.Stat(filepath.Join(local, name))
+				return err == nil
+			}
+			if !exists("conf/app.yaml") || exists("other/data") || exists("a.txt") {
+				t.Errorf("unexpected files checked out: %s", runGit(t, local, "ls-files", "-t"))
+			}
+
+			commit(t, work, "conf/app.yaml", name, "update conf")
+			runGit(t, work, "push", "-q", "origin", "master")
+			if err := b.Fetch(ctx, local); err != nil {
+				t.Fatalf("fetch: %v", err)
+			}
+			if err := b.Reset(ctx, local, "origin/master"); err != nil {
+				t.Fatalf("reset: %v", err)
+			}
+			if readFile(t, filepath.Join(local, "conf/app.yaml")) != name || exists("other/data") {
+				t.Errorf("sparse checkout not kept after reset")
+			}
+			if err := b.Checkout(ctx, local, "v1"); err != nil || exists("a.txt") {
+				t.Errorf("sparse checkout not kept after checkout: %v", err)
+			}
+		})
+	}
+
+	if err := NewCLIBackend().Clone(context.Background(), bare, t.TempDir(), WithSparsePaths("../etc")); ErrorCode(err) != CloneError {
+		t.Errorf("expected invalid sparse path rejected, got %v", err)
+	}
+}
+
+// gitHTTPServer serves repos under root by git http-backend, with basic auth
+func gitHTTPServer(t *testing.T, root, username, password string) *httptest.Server {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git not found")
+	}
+	backend := &cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Root: "/",
+		Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != username || p != password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		backend.ServeHTTP(w, r)
+	}))
+}
+
+func TestCloneAuth(t *testing.T) {
+	bare, work := newRemote(t)
+	tokenServer := gitHTTPServer(t, filepath.Dir(bare), tokenUser, "secret")
+	defer tokenServer.Close()
+	basicServer := gitHTTPServer(t, filepath.Dir(bare), "alice", "pw")
+	defer basicServer.Close()
+
+	for name, b := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+
+			cases := []struct {
+				name string
+				url  string
+				opts []Option
+				code int
+			}{
+				{"token", tokenServer.URL + "/remote.git", []Option{WithToken("secret")}, -1},
+				{"basic", basicServer.URL + "/remote.git", []Option{WithBasicAuth("alice", "pw")}, -1},
+				{"no credential", tokenServer.URL + "/remote.git", nil, AuthError},
+				{"wrong token", tokenServer.URL + "/remote.git", []Option{WithToken("wrong")}, AuthError},
+			}
+			for _, c := range cases {
+				err := b.Clone(ctx, c.url, filepath.Join(dir, strings.Replace(c.name, " ", "-", -1)), c.opts...)
+				if c.code < 0 && err != nil {
+					t.Errorf("%s: unexpected error: %v", c.name, err)
+				}
+				if c.code >= 0 && ErrorCode(err) != c.code {
+					t.Errorf("%s: expected code %d, got %v", c.name, c.code, err)
+				}
+			}
+
+			// credential is not saved
+			local := filepath.Join(dir, "token")
+			if cfg := readFile(t, filepath.Join(local, ".git", "config")); strings.Contains(cfg, "secret") {
+				t.Errorf("credential saved in config: %s", cfg)
+			}
+			commit(t, work, "a.txt", name, "update by "+name)
+			runGit(t, work, "push", "-q", "origin", "master")
+			if err := b.Fetch(ctx, local); ErrorCode(err) != AuthError {
+				t.Errorf("fetch without token: expected AuthError, got %v", err)
+			}
+			if err := b.Fetch(ctx, local, WithToken("secret")); err != nil {
+				t.Errorf("fetch: %v", err)
+			}
+			if commits, err := b.Log(ctx, local, "origin/master", 1); err != nil || commits[0].Message != "update by "+name {
+				t.Errorf("fetch: unexpected commits %v, %v", commits, err)
+			}
+		})
+	}
+}
+
+func TestCLISSHCommand(t *testing.T) {
+	b, cleanup, err := cliBackend{git: "git"}.withOptions(newOptions([]Option{
+		WithSSHKey("/keys/it's", "pass"),
+		WithKnownHosts(KnownHostsAcceptNew, "/tmp/known_hosts"),
+	}))
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	env := strings.Join(b.env, "\n")
+	for _, s := range []string{
+		`GIT_SSH_COMMAND=ssh -o StrictHostKeyChecking=accept-new -o UserKnownHostsFile='/tmp/known_hosts' -i '/keys/it'\''s' -o IdentitiesOnly=yes`,
+		"SSH_ASKPASS_REQUIRE=force",
+		"REPO_SSH_PASSPHRASE=pass",
+	} {
+		if !strings.Contains(env, s) {
+			t.Errorf("expected %q in env:\n%s", s, env)
+		}
+	}
+
+	if _, _, err := (cliBackend{}).withOptions(newOptions([]Option{WithKnownHosts("trust-me", "")})); err == nil {
+		t.Errorf("expected unknown policy rejected")
+	}
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	newKey := func() ssh.PublicKey {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		key, err := ssh.NewPublicKey(pub)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return key
+	}
+	key, other := newKey(), newKey()
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+	file := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+
+	strict, err := newOptions([]Option{WithKnownHosts(KnownHostsStrict, file)}).hostKeyCallback()
+	if err == nil {
+		if err := strict("git.example.com:22", addr, key); err == nil {
+			t.Errorf("strict: expected unknown host rejected")
+		}
+	}
+
+	acceptNew, err := newOptions([]Option{WithKnownHosts(KnownHostsAcceptNew, file)}).hostKeyCallback()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := acceptNew("git.example.com:22", addr, key); err != nil {
+		t.Errorf("accept-new: expected unknown host accepted, got %v", err)
+	}
+	if err := acceptNew("git.example.com:22", addr, other); err == nil {
+		t.Errorf("accept-new: expected changed key rejected")
+	}
+
+	strict, err = newOptions([]Option{WithKnownHosts(KnownHostsStrict, file)}).hostKeyCallback()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := strict("git.example.com:22", addr, key); err != nil {
+		t.Errorf("strict: expected host added by accept-new known, got %v", err)
+	}
+}
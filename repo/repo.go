@@ -1,20 +1,16 @@
 package repo
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"os"
-	"os/exec"
-	"path"
 	"regexp"
-	"sync"
 	"time"
 
 	log "github.com/golang/glog"
 )
 
 var (
-	repolock             sync.Mutex
 	GitPrexHistoryCommit = regexp.MustCompile("^0{1,}$")
 )
 
@@ -30,136 +26,120 @@ const (
 	FetchError
 	WaitTimeout
 	UnknownError
+	// AuthError remote requires authentication, or the credential is rejected
+	AuthError
+	// RemoteNotFound remote repo does not exist
+	RemoteNotFound
+	// ResetError git reset failed
+	ResetError
+	// TimeoutError the operation is canceled or its deadline exceeded
+	TimeoutError
 )
 
 type RepoError struct {
 	Code int
+	// Op is the git operation failed, e.g. clone, fetch, checkout
+	Op string
+	// Path of the local repo
+	Path string
 	err  error
 	Msg  string
 }
 
 func (re *RepoError) ErrorOrNil() error {
 	if re != nil {
-		return fmt.Errorf("Err code: %d, %s, %s", re.Code, re.Msg, re.err.Error())
+		return re
 	}
 
 	return nil
 }
 
 func (re *RepoError) Error() string {
-	if re != nil {
-		return fmt.Sprintf("Err code: %d, %s, %s", re.Code, re.Msg, re.err.Error())
-	} else {
+	if re == nil {
 		return "RepoError is nil"
 	}
+	msg := fmt.Sprintf("Err code: %d, %s", re.Code, re.Msg)
+	if re.Op != "" {
+		msg = fmt.Sprintf("Err code: %d, %s %s: %s", re.Code, re.Op, re.Path, re.Msg)
+	}
+	if re.err != nil {
+		msg += ", " + re.err.Error()
+	}
+	return msg
 }
 
-//InitRepo clone a remote repo to localpath
-// release repolock before call this function
-// command execute at most timeout, and this function will return before wait
-func InitRepo(repourl, localPath string, timeout time.Duration, wait time.Duration) *RepoError {
-	dir := path.Dir(localPath)
-	repolock.Lock()
-	defer repolock.Unlock()
+// Unwrap returns the underlying error
+func (re *RepoError) Unwrap() error {
+	return re.err
+}
 
-	err := os.MkdirAll(dir, 0755)
-	if err != nil {
-		return &RepoError{
-			Code: PermissionError,
-			err:  err,
-			Msg:  "don't have permission to create dir",
-		}
+// ErrorCode returns code of err if it is a *RepoError, UnknownError otherwise
+func ErrorCode(err error) int {
+	var re *RepoError
+	if errors.As(err, &re) && re != nil {
+		return re.Code
 	}
+	return UnknownError
+}
 
-	cmd := exec.Command("git", "clone", repourl, localPath)
-	log.Infof("start init repo:%s, %s", localPath, repourl)
-	output, err := execCmd(cmd, timeout, wait)
-	// output, err := cmd.Output()
-
-	if err != nil {
-		log.Warningf("error init repo: %v", err)
-		return &RepoError{
-			Code: CloneError,
-			err:  err,
-			Msg:  fmt.Sprintf("failed to clone repo %s to %s", repourl, localPath),
-		}
+// InitRepo clone a remote repo to localpath
+// command execute at most timeout, and this function will return before wait,
+// the lock of localPath is held until the clone finishes
+// opts like WithToken and WithDepth are passed to the backend
+func InitRepo(repourl, localPath string, timeout time.Duration, wait time.Duration, opts ...Option) *RepoError {
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
 	}
+	log.Infof("start init repo:%s, %s", localPath, repourl)
+	r := New(localPath, repourl, WithCloneOptions(opts...))
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		done <- r.Clone(ctx)
+	}()
 
-	if string(output) == "waitTimeout" {
-		log.Warningf("wait timeout init repo: %v", output)
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(wait):
+		log.Warningf("wait timeout init repo: %s", localPath)
 		return &RepoError{
 			Code: WaitTimeout,
-			err:  err,
+			Op:   "clone",
+			Path: localPath,
+			err:  fmt.Errorf("clone not finished after %v", wait),
 			Msg:  fmt.Sprintf("wait timeout for clone repo %s to %s", repourl, localPath),
 		}
 	}
 
-	log.Info(string(output))
+	if err != nil {
+		log.Warningf("error init repo: %v", err)
+		return asRepoError(err)
+	}
 	return nil
 }
 
-// UpdateRepo update a local git repo, if not exists, create one
-func UpdateRepo(localPath, gitRepo string, timeout time.Duration) *RepoError {
-	cpath := path.Clean(localPath)
+// asRepoError converts err returned by backends to *RepoError
+func asRepoError(err error) *RepoError {
+	var re *RepoError
+	if errors.As(err, &re) {
+		return re
+	}
+	return &RepoError{Code: UnknownError, err: err, Msg: "unexpected error"}
+}
 
-	_, err := os.Stat(localPath)
-	var wait time.Duration
+// UpdateRepo update a local git repo, if not exists, create one
+func UpdateRepo(localPath, gitRepo string, timeout time.Duration, opts ...Option) *RepoError {
 	if timeout <= 0 {
 		timeout = 2 * time.Minute
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	wait = timeout + 1*time.Minute
-
-	// mkdir if not exists
-	if err != nil && os.IsNotExist(err) {
-		nerr := InitRepo(gitRepo, cpath, timeout, wait)
-		if nerr != nil {
-			return nerr
-		}
-		return nil
-	} else if err != nil {
-		return &RepoError{
-			Code: UnknownError,
-			err:  err,
-			Msg:  "during stat  dir: " + localPath,
-		}
-	}
-
-	// dir exists but not a git repo
-	cmd := exec.Command("git", "branch")
-	cmd.Dir = cpath
-	if _, err := cmd.Output(); err != nil {
-		log.Warning(err)
-		// if dir is empty, rmdir and clone
-		if nerr := os.Remove(cpath); nerr != nil {
-			return &RepoError{
-				Code: RemoveDirError,
-				err:  nerr,
-				Msg:  "err to remove dir: " + cpath,
-			}
-		}
-		if nerr := InitRepo(gitRepo, cpath, timeout, wait); nerr != nil {
-			return nerr
-		}
-		return nil
-	}
-
-	// here we can sure, git repo exists,
-	// so just cd, git git  fetch
-	repolock.Lock()
-	defer repolock.Unlock()
-	cmd = exec.Command("git", "fetch", "origin")
-	cmd.Dir = cpath
-	// execute it
-	out, err := execCmd(cmd, timeout, wait)
-
-	log.Info("fetch origin: ", string(out))
-	if err != nil {
-		return &RepoError{
-			Code: FetchError,
-			err:  err,
-			Msg:  "error occured when fetch origin",
-		}
+	if err := New(localPath, gitRepo, WithCloneOptions(opts...)).Update(ctx); err != nil {
+		log.Warningf("update repo %s: %v", localPath, err)
+		return asRepoError(err)
 	}
 	return nil
 }
@@ -168,14 +148,12 @@ func UpdateRepo(localPath, gitRepo string, timeout time.Duration) *RepoError {
 // else return a RepoError
 func BranchExists(repo, branch string) *RepoError {
 	// check if branch/tag/commit-id exists
-	cmd := exec.Command("git", "log", "-1", branch)
-	cmd.Dir = repo
-	if _, err := cmd.Output(); err != nil {
-		return &RepoError{
-			Code: BranchNotExists,
-			err:  err,
-			Msg:  "branch not exists: " + branch,
+	if _, err := New(repo, "").Log(context.Background(), branch, 1); err != nil {
+		re := asRepoError(err)
+		if re.Code != NotARepo {
+			re.Code = BranchNotExists
 		}
+		return re
 	}
 
 	return nil
@@ -190,139 +168,13 @@ func Switch2branch(repo, branch string) *RepoError {
 	return Switch2branch0(repo, branch)
 }
 
-// since this will acquire the loc,
-// so before call this function, release it
 // branch better refer a remote branch
+// the work tree is updated in place, use Deployer if readers must not see a half updated tree
 func Switch2branch0(repo, branch string) *RepoError {
-	repolock.Lock()
-	defer repolock.Unlock()
-
-	// git rev-parse --abbrev-ref HEAD
-	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
-	cmd.Dir = repo
-
-	out, err := cmd.Output()
-
-	if err != nil {
-		log.Warning(out)
-		return &RepoError{
-			Code: NotARepo,
-			err:  err,
-			Msg:  "error when rev-parse --abbrev-ref HEAD",
-		}
-	}
-
-	// if current branch is not anonymous
-	// or we can just reset --hard, without loss someting
-	if string(out) != "HEAD" {
-		// reset HEAD
-		cmd = exec.Command("git", "reset", "--hard", "HEAD")
-		cmd.Dir = repo
-		_, err = cmd.Output()
-		if err != nil {
-			return &RepoError{
-				Code: UnknownError,
-				err:  err,
-				Msg:  "when reset --hard HEAD",
-			}
-		}
-
-		// checkout branch
-		cmd = exec.Command("git", "checkout", branch)
-		cmd.Dir = repo
-		_, err = cmd.Output()
-
-		if err != nil {
-			return &RepoError{
-				Code: UnknownError,
-				err:  err,
-				Msg:  "when checkout  " + branch,
-			}
-		}
-	} else {
-		cmd = exec.Command("git", "reset", "--hard", branch)
-		cmd.Dir = repo
-		_, err = cmd.Output()
-		if err != nil {
-			return &RepoError{
-				Code: UnknownError,
-				err:  err,
-				Msg:  "when reset --hard " + branch,
-			}
-		}
+	// local changes are discarded, a local branch is created if only origin has it
+	if err := New(repo, "").Checkout(context.Background(), branch); err != nil {
+		return asRepoError(err)
 	}
 
 	return nil
 }
-
-func execCmd(cmd *exec.Cmd, timeout time.Duration, wait time.Duration) (string, error) {
-	if cmd == nil {
-		return "", fmt.Errorf("cmd is nil")
-	}
-
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Start()
-	// here we actually do not need to check the error
-	if err != nil {
-		log.Warning(err)
-		return "", err
-	}
-
-	done := make(chan error, 1)
-	go func() {
-		done <- cmd.Wait()
-	}()
-
-	if timeout <= 0 {
-		timeout = 5 * time.Minute
-	}
-
-	var remains time.Duration
-	if wait > timeout {
-		remains = 0
-	} else {
-		remains = timeout - wait
-	}
-
-	outstr := ""
-
-	select {
-	case <-time.After(timeout):
-		if err = cmd.Process.Kill(); err != nil {
-			log.Fatal("failed to kill: ", err)
-		}
-		log.Warning("process killed as timeout reached")
-		err = fmt.Errorf("process killed as timeout reached")
-	case <-time.After(wait):
-		log.Warning("wait timeout, process will continue be exceucte timeout")
-		outstr = "waitTimeout"
-		go func() {
-			select {
-			case <-time.After(remains):
-				if err = cmd.Process.Kill(); err != nil {
-					log.Errorf("failed to kill: ", err)
-				}
-				log.Warningf("process killed as timeout reached")
-			case err = <-done:
-				if err != nil {
-					log.Warning(err)
-				}
-			}
-		}()
-	case err = <-done:
-		if err != nil {
-			log.Warningf("process done with error = %v", err)
-		} else {
-			log.V(10).Infof("process done gracefully without error")
-		}
-	}
-
-	if outstr != "" {
-		return outstr, nil
-	}
-
-	output := out.String()
-	log.V(10).Infof("output: %s, err: %v", output, err)
-	return output, err
-}
@@ -0,0 +1,206 @@
+package repo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// pathLocks in process lock of each repo path, a buffered chan of size 1,
+// so acquiring it can be canceled
+var pathLocks sync.Map
+
+// Repo a local git repo, operations on it are serialized by a lock of its path,
+// so different repos can be updated in parallel
+type Repo struct {
+	path     string
+	url      string
+	backend  Backend
+	opts     []Option
+	fileLock bool
+}
+
+// RepoOption of a Repo
+type RepoOption func(*Repo)
+
+// WithBackend backend does the git operations, default to DefaultBackend
+func WithBackend(b Backend) RepoOption {
+	return func(r *Repo) {
+		r.backend = b
+	}
+}
+
+// WithCloneOptions options used when clone and fetch, e.g. WithToken and WithDepth
+func WithCloneOptions(opts ...Option) RepoOption {
+	return func(r *Repo) {
+		r.opts = append(r.opts, opts...)
+	}
+}
+
+// WithFileLock also holds a lock of file path.lock during operations,
+// so other processes using the same repo are serialized too
+func WithFileLock() RepoOption {
+	return func(r *Repo) {
+		r.fileLock = true
+	}
+}
+
+// New returns a Repo at path, url is the remote it's cloned from, which can be
+// empty if the repo exists already
+func New(path, url string, opts ...RepoOption) *Repo {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	r := &Repo{
+		path:    filepath.Clean(path),
+		url:     url,
+		backend: DefaultBackend,
+	}
+	for _, o := range opts {
+		o(r)
+	}
+	return r
+}
+
+// Path of the local repo
+func (r *Repo) Path() string {
+	return r.path
+}
+
+// lock the repo path, returns func to unlock it
+func (r *Repo) lock(ctx context.Context, op string) (func(), error) {
+	v, _ := pathLocks.LoadOrStore(r.path, make(chan struct{}, 1))
+	ch := v.(chan struct{})
+	select {
+	case ch <- struct{}{}:
+	case <-ctx.Done():
+		return nil, newRepoError(TimeoutError, op, r.path, ctx.Err(), "wait for lock of the repo")
+	}
+	if !r.fileLock {
+		return func() { <-ch }, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		<-ch
+		return nil, newRepoError(PermissionError, op, r.path, err, "don't have permission to create dir")
+	}
+	unlock, err := lockFile(ctx, r.path+".lock")
+	if err != nil {
+		<-ch
+		if ctx.Err() != nil {
+			return nil, newRepoError(TimeoutError, op, r.path, err, "wait for file lock of the repo")
+		}
+		return nil, newRepoError(UnknownError, op, r.path, err, "lock file")
+	}
+	return func() {
+		unlock()
+		<-ch
+	}, nil
+}
+
+// Update clones the repo if not exists, or fetches from origin
+func (r *Repo) Update(ctx context.Context) error {
+	unlock, err := r.lock(ctx, "update")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := os.Stat(r.path); os.IsNotExist(err) {
+		return r.clone(ctx)
+	} else if err != nil {
+		return newRepoError(UnknownError, "update", r.path, err, "stat dir")
+	}
+
+	// dir exists but not a git repo, remove it if empty and clone
+	if _, err := r.backend.Log(ctx, r.path, "HEAD", 1); ErrorCode(err) == NotARepo {
+		if rerr := os.Remove(r.path); rerr != nil {
+			return newRepoError(RemoveDirError, "update", r.path, rerr, "err to remove dir")
+		}
+		return r.clone(ctx)
+	}
+
+	return r.backend.Fetch(ctx, r.path, r.opts...)
+}
+
+// Clone the repo, path must not exist or be empty
+func (r *Repo) Clone(ctx context.Context) error {
+	unlock, err := r.lock(ctx, "clone")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return r.clone(ctx)
+}
+
+func (r *Repo) clone(ctx context.Context) error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return newRepoError(PermissionError, "clone", r.path, err, "don't have permission to create dir")
+	}
+	return r.backend.Clone(ctx, r.url, r.path, r.opts...)
+}
+
+// Fetch from origin
+func (r *Repo) Fetch(ctx context.Context) error {
+	unlock, err := r.lock(ctx, "fetch")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return r.backend.Fetch(ctx, r.path, r.opts...)
+}
+
+// Checkout rev, see Backend.Checkout, rev can also be branch@{date}
+func (r *Repo) Checkout(ctx context.Context, rev string) error {
+	unlock, err := r.lock(ctx, "checkout")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if rev, err = r.dateRev(ctx, "checkout", rev); err != nil {
+		return err
+	}
+	return r.backend.Checkout(ctx, r.path, rev)
+}
+
+// Reset to rev, see Backend.Reset, rev can also be branch@{date}
+func (r *Repo) Reset(ctx context.Context, rev string) error {
+	unlock, err := r.lock(ctx, "reset")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if rev, err = r.dateRev(ctx, "reset", rev); err != nil {
+		return err
+	}
+	return r.backend.Reset(ctx, r.path, rev)
+}
+
+// dateRev resolves rev like branch@{date} to a hash, other revs are returned as is
+func (r *Repo) dateRev(ctx context.Context, op, rev string) (string, error) {
+	if _, _, ok := parseDateRev(rev); !ok {
+		return rev, nil
+	}
+	return r.resolve(ctx, op, rev)
+}
+
+// Log returns at most n commits reachable from rev, see Backend.Log
+func (r *Repo) Log(ctx context.Context, rev string, n int) ([]Commit, error) {
+	unlock, err := r.lock(ctx, "log")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return r.backend.Log(ctx, r.path, rev, n)
+}
+
+// Diff returns files changed between from and to, see Backend.Diff
+func (r *Repo) Diff(ctx context.Context, from, to string) ([]string, error) {
+	unlock, err := r.lock(ctx, "diff")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return r.backend.Diff(ctx, r.path, from, to)
+}
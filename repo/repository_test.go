@@ -0,0 +1,126 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRepoUpdateConcurrently(t *testing.T) {
+	bare, work := newRemote(t)
+	dir := t.TempDir()
+
+	// the same repo from many goroutines, only one of them clones
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- New(filepath.Join(dir, "same"), bare).Update(context.Background())
+		}()
+	}
+	// independent repos in parallel
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- New(filepath.Join(dir, fmt.Sprint(i)), bare).Update(context.Background())
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	second := commit(t, work, "a.txt", "v2", "second")
+	runGit(t, work, "push", "-q", "origin", "master")
+	r := New(filepath.Join(dir, "same"), bare)
+	if err := r.Update(context.Background()); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if commits, err := r.Log(context.Background(), "origin/master", 1); err != nil || commits[0].Hash != second {
+		t.Errorf("expected origin/master at %s, got %v, %v", second, commits, err)
+	}
+}
+
+func TestRepoUpdateEmptyDir(t *testing.T) {
+	bare, _ := newRemote(t)
+	local := filepath.Join(t.TempDir(), "local")
+	os.Mkdir(local, 0755)
+
+	if err := New(local, bare).Update(context.Background()); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if readFile(t, filepath.Join(local, "a.txt")) != "v1" {
+		t.Errorf("expected repo cloned into the empty dir")
+	}
+
+	notEmpty := filepath.Join(t.TempDir(), "dir")
+	os.Mkdir(notEmpty, 0755)
+	ioutil.WriteFile(filepath.Join(notEmpty, "file"), []byte("data"), 0644)
+	if err := New(notEmpty, bare).Update(context.Background()); ErrorCode(err) != RemoveDirError {
+		t.Errorf("expected RemoveDirError for non empty dir, got %v", err)
+	}
+}
+
+func TestRepoLock(t *testing.T) {
+	bare, _ := newRemote(t)
+	local := filepath.Join(t.TempDir(), "local")
+	r := New(local, bare)
+
+	unlock, err := r.lock(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	// relative and absolute path share the lock
+	if err := New(local+"/.", bare).Update(ctx); ErrorCode(err) != TimeoutError {
+		t.Errorf("expected TimeoutError waiting for lock, got %v", err)
+	}
+	if err := New(local+"-other", bare).Update(context.Background()); err != nil {
+		t.Errorf("other repo should not be blocked: %v", err)
+	}
+
+	done := make(chan error)
+	go func() { done <- r.Update(context.Background()) }()
+	time.Sleep(20 * time.Millisecond)
+	unlock()
+	if err := <-done; err != nil {
+		t.Errorf("update after unlock: %v", err)
+	}
+}
+
+func TestRepoFileLock(t *testing.T) {
+	bare, _ := newRemote(t)
+	local := filepath.Join(t.TempDir(), "local")
+
+	// held by another process
+	unlock, err := lockFile(context.Background(), local+".lock")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := New(local, bare, WithFileLock()).Update(ctx); ErrorCode(err) != TimeoutError {
+		t.Errorf("expected TimeoutError waiting for file lock, got %v", err)
+	}
+	if err := New(local, bare).Update(context.Background()); err != nil {
+		t.Errorf("update without file lock: %v", err)
+	}
+
+	unlock()
+	if err := New(local, bare, WithFileLock()).Update(context.Background()); err != nil {
+		t.Errorf("update with file lock: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// dateRev rev like master@{2020-01-02}
+var dateRev = regexp.MustCompile(`^(.+)@\{(.+)\}$`)
+
+// dateLayouts of branch@{date}, in local time if no zone
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// parseDateRev splits rev like branch@{date}, ok is false if rev is not of this form
+func parseDateRev(rev string) (branch string, date time.Time, ok bool) {
+	m := dateRev.FindStringSubmatch(rev)
+	if m == nil {
+		return "", time.Time{}, false
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, m[2], time.Local); err == nil {
+			return m[1], t, true
+		}
+	}
+	return "", time.Time{}, false
+}
+
+// Resolve rev to the hash of a commit, rev can be a branch, tag, full or short hash,
+// or branch@{date}, which is the last commit of branch at the date
+func (r *Repo) Resolve(ctx context.Context, rev string) (string, error) {
+	unlock, err := r.lock(ctx, "resolve")
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	return r.resolve(ctx, "resolve", rev)
+}
+
+func (r *Repo) resolve(ctx context.Context, op, rev string) (string, error) {
+	if rev == "" || GitPrexHistoryCommit.MatchString(rev) {
+		return "", newRepoError(BranchNotExists, op, r.path, nil, fmt.Sprintf("invalid revision: %q", rev))
+	}
+
+	branch, date, ok := parseDateRev(rev)
+	if !ok {
+		return r.backend.Resolve(ctx, r.path, rev)
+	}
+	commits, err := r.backend.Log(ctx, r.path, branch, 0)
+	if err != nil {
+		return "", err
+	}
+	for _, c := range commits {
+		if !c.Date.After(date) {
+			return c.Hash, nil
+		}
+	}
+	return "", newRepoError(BranchNotExists, op, r.path, nil, "no commit before the date: "+rev)
+}
+
+// CurrentRevision returns the branch and commit checked out, and whether the work tree is dirty
+func (r *Repo) CurrentRevision(ctx context.Context) (*Revision, error) {
+	unlock, err := r.lock(ctx, "status")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return r.backend.Head(ctx, r.path)
+}
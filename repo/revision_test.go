@@ -0,0 +1,114 @@
+package repo
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseDateRev(t *testing.T) {
+	tests := []struct {
+		rev    string
+		branch string
+		date   time.Time
+		ok     bool
+	}{
+		{"master@{2020-01-02}", "master", time.Date(2020, 1, 2, 0, 0, 0, 0, time.Local), true},
+		{"dev@{2020-01-02 03:04:05}", "dev", time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local), true},
+		{"dev@{2020-01-02T03:04:05Z}", "dev", time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), true},
+		{"master@{yesterday}", "", time.Time{}, false},
+		{"master", "", time.Time{}, false},
+	}
+	for _, test := range tests {
+		branch, date, ok := parseDateRev(test.rev)
+		if branch != test.branch || !date.Equal(test.date) || ok != test.ok {
+			t.Errorf("%s: expected %s %v %v, got %s %v %v", test.rev, test.branch, test.date, test.ok, branch, date, ok)
+		}
+	}
+}
+
+func TestResolveAndHead(t *testing.T) {
+	for name, b := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			bare, work := newRemote(t)
+			local := filepath.Join(t.TempDir(), "local")
+			if err := b.Clone(ctx, bare, local); err != nil {
+				t.Fatalf("clone: %v", err)
+			}
+			master := runGit(t, work, "rev-parse", "master")
+			dev := runGit(t, work, "rev-parse", "dev")
+
+			for rev, want := range map[string]string{
+				"v1":         master,
+				master[:7]:   master,
+				master:       master,
+				"origin/dev": dev,
+			} {
+				if hash, err := b.Resolve(ctx, local, rev); err != nil || hash != want {
+					t.Errorf("resolve %s: expected %s, got %s, %v", rev, want, hash, err)
+				}
+			}
+			if _, err := b.Resolve(ctx, local, "nope"); ErrorCode(err) != BranchNotExists {
+				t.Errorf("resolve nope: expected BranchNotExists, got %v", err)
+			}
+
+			rev, err := b.Head(ctx, local)
+			if err != nil {
+				t.Fatalf("head: %v", err)
+			}
+			if rev.Branch != "master" || rev.Hash != master || rev.Author != "tester" || rev.Date.IsZero() || rev.Dirty {
+				t.Errorf("head: unexpected revision %+v", rev)
+			}
+
+			ioutil.WriteFile(filepath.Join(local, "a.txt"), []byte("dirty"), 0644)
+			if rev, err = b.Head(ctx, local); err != nil || !rev.Dirty {
+				t.Errorf("head modified: expected dirty, got %+v, %v", rev, err)
+			}
+
+			if err := b.Checkout(ctx, local, "v1"); err != nil {
+				t.Fatalf("checkout v1: %v", err)
+			}
+			if rev, err = b.Head(ctx, local); err != nil || rev.Branch != "" || rev.Hash != master || rev.Dirty {
+				t.Errorf("head detached: unexpected revision %+v, %v", rev, err)
+			}
+		})
+	}
+}
+
+func TestRepoCheckoutDate(t *testing.T) {
+	ctx := context.Background()
+	bare, work := newRemote(t)
+	t.Setenv("GIT_AUTHOR_DATE", "2020-01-01T12:00:00Z")
+	jan := commit(t, work, "a.txt", "jan", "jan")
+	t.Setenv("GIT_AUTHOR_DATE", "2020-02-01T12:00:00Z")
+	commit(t, work, "a.txt", "feb", "feb")
+	runGit(t, work, "push", "-q", "origin", "master")
+
+	r := New(filepath.Join(t.TempDir(), "local"), bare)
+	if err := r.Update(ctx); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if hash, err := r.Resolve(ctx, "master@{2020-01-15}"); err != nil || hash != jan {
+		t.Errorf("resolve: expected %s, got %s, %v", jan, hash, err)
+	}
+	if _, err := r.Resolve(ctx, "master@{2000-01-01}"); ErrorCode(err) != BranchNotExists {
+		t.Errorf("resolve before first commit: expected BranchNotExists, got %v", err)
+	}
+	if _, err := r.Resolve(ctx, "0000000"); ErrorCode(err) != BranchNotExists {
+		t.Errorf("resolve zero hash: expected BranchNotExists, got %v", err)
+	}
+
+	if err := r.Checkout(ctx, "master@{2020-01-15}"); err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+	if readFile(t, filepath.Join(r.Path(), "a.txt")) != "jan" {
+		t.Errorf("checkout: expected a.txt of jan")
+	}
+	rev, err := r.CurrentRevision(ctx)
+	if err != nil || rev.Hash != jan || rev.Branch != "" {
+		t.Errorf("current revision: unexpected %+v, %v", rev, err)
+	}
+}
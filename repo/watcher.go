@@ -0,0 +1,269 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"we.com/jiabiao/common/wait"
+)
+
+const (
+	// DefaultWatchInterval how often the remote is fetched
+	DefaultWatchInterval = time.Minute
+	// DefaultWatchJitter max factor the interval is jittered
+	DefaultWatchJitter = 0.1
+	// DefaultWatchTimeout of each fetch
+	DefaultWatchTimeout = 2 * time.Minute
+)
+
+// Event is sent to subscribers when a tracked ref changes
+type Event struct {
+	// Ref as passed to NewWatcher
+	Ref string
+	// Old is empty if the ref is created, New is empty if it's deleted
+	Old string
+	New string
+	// Files changed between Old and New, matching the path globs if any.
+	// Empty if the ref is created or deleted
+	Files []string
+	Time  time.Time
+}
+
+type watchOptions struct {
+	interval time.Duration
+	jitter   float64
+	timeout  time.Duration
+	globs    []string
+}
+
+// WatchOption of a Watcher
+type WatchOption func(*watchOptions)
+
+// WithInterval how often the remote is fetched, and max factor the interval is jittered
+func WithInterval(interval time.Duration, jitter float64) WatchOption {
+	return func(o *watchOptions) {
+		o.interval = interval
+		o.jitter = jitter
+	}
+}
+
+// WithWatchTimeout of each fetch
+func WithWatchTimeout(timeout time.Duration) WatchOption {
+	return func(o *watchOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithPathGlobs only changes of files matching one of the globs are reported, patterns are
+// of path.Match, and a file also matches if one of its parent dirs does, e.g. "conf" or "conf/*.yaml".
+// Changes of refs not touching these files are not sent
+func WithPathGlobs(globs ...string) WatchOption {
+	return func(o *watchOptions) {
+		o.globs = append(o.globs, globs...)
+	}
+}
+
+// Watcher fetches a repo periodically, and sends events when tracked refs change
+type Watcher struct {
+	repo *Repo
+	refs []string
+	opts watchOptions
+
+	// pollMu serializes polls
+	pollMu sync.Mutex
+
+	mu      sync.Mutex
+	heads   map[string]string
+	synced  bool
+	subs    map[int]chan Event
+	nextSub int
+}
+
+// NewWatcher watches refs of r, which are branches of origin like "master", or full refs
+// like "refs/tags/v1". The repo is cloned on the first poll if not exists
+func NewWatcher(r *Repo, refs []string, opts ...WatchOption) (*Watcher, error) {
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("no ref to watch")
+	}
+	o := watchOptions{
+		interval: DefaultWatchInterval,
+		jitter:   DefaultWatchJitter,
+		timeout:  DefaultWatchTimeout,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.interval <= 0 {
+		return nil, fmt.Errorf("invalid watch interval: %v", o.interval)
+	}
+	for _, g := range o.globs {
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("invalid path glob %q: %v", g, err)
+		}
+	}
+
+	return &Watcher{
+		repo:  r,
+		refs:  refs,
+		opts:  o,
+		heads: map[string]string{},
+		subs:  map[int]chan Event{},
+	}, nil
+}
+
+// Run polls the remote every interval until stopCh is closed, it blocks.
+// The first poll records heads of the refs, events are sent for changes after it
+func (w *Watcher) Run(stopCh <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	wait.JitterUntil(func() {
+		pctx, pcancel := context.WithTimeout(ctx, w.opts.timeout)
+		defer pcancel()
+		if err := w.Poll(pctx); err != nil {
+			glog.Warningf("watch repo %s: %v", w.repo.Path(), err)
+		}
+	}, w.opts.interval, w.opts.jitter, true, stopCh)
+}
+
+// Poll fetches the remote once and sends events of changed refs
+func (w *Watcher) Poll(ctx context.Context) error {
+	w.pollMu.Lock()
+	defer w.pollMu.Unlock()
+
+	if err := w.repo.Update(ctx); err != nil {
+		return err
+	}
+
+	heads := map[string]string{}
+	for _, ref := range w.refs {
+		hash, err := w.repo.Resolve(ctx, w.refName(ref))
+		if ErrorCode(err) == BranchNotExists {
+			continue
+		} else if err != nil {
+			return err
+		}
+		heads[ref] = hash
+	}
+
+	w.mu.Lock()
+	old, synced := w.heads, w.synced
+	w.mu.Unlock()
+
+	var events []Event
+	if synced {
+		now := time.Now()
+		for _, ref := range w.refs {
+			e := Event{Ref: ref, Old: old[ref], New: heads[ref], Time: now}
+			if e.Old == e.New {
+				continue
+			}
+			if e.Old != "" && e.New != "" {
+				files, err := w.repo.Diff(ctx, e.Old, e.New)
+				if err != nil {
+					return err
+				}
+				if e.Files = w.filter(files); len(e.Files) == 0 {
+					glog.V(4).Infof("watch repo %s: %s changed, no file matches", w.repo.Path(), ref)
+					continue
+				}
+			}
+			events = append(events, e)
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.heads = heads
+	w.synced = true
+	for _, e := range events {
+		glog.Infof("watch repo %s: %s %s -> %s, %d files changed", w.repo.Path(), e.Ref, e.Old, e.New, len(e.Files))
+		for id, ch := range w.subs {
+			select {
+			case ch <- e:
+			default:
+				glog.Warningf("repo watcher subscriber %d is full, drop event of %s", id, e.Ref)
+			}
+		}
+	}
+	return nil
+}
+
+// Heads returns the last known commit of the refs, refs not exist are absent
+func (w *Watcher) Heads() map[string]string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ret := make(map[string]string, len(w.heads))
+	for k, v := range w.heads {
+		ret[k] = v
+	}
+	return ret
+}
+
+// Subscribe to changes, events are dropped if the channel is full.
+// cancel must be called when the subscriber is done, which closes the channel
+func (w *Watcher) Subscribe(buffer int) (events <-chan Event, cancel func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ch := make(chan Event, buffer)
+	id := w.nextSub
+	w.nextSub++
+	w.subs[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			delete(w.subs, id)
+			close(ch)
+		})
+	}
+}
+
+// refName of ref in the local repo, branches are those of origin
+func (w *Watcher) refName(ref string) string {
+	if strings.HasPrefix(ref, "refs/") {
+		return ref
+	}
+	return "refs/remotes/origin/" + ref
+}
+
+// filter returns files matching the globs, all of them if no glob
+func (w *Watcher) filter(files []string) []string {
+	if len(w.opts.globs) == 0 {
+		return files
+	}
+	var ret []string
+	for _, f := range files {
+		if matchGlobs(w.opts.globs, f) {
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
+
+// matchGlobs whether file or one of its parent dirs matches one of globs
+func matchGlobs(globs []string, file string) bool {
+	for p := file; p != "." && p != "/"; p = path.Dir(p) {
+		for _, g := range globs {
+			if ok, _ := path.Match(g, p); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
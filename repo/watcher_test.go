@@ -0,0 +1,187 @@
+package repo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"we.com/jiabiao/common/wait"
+)
+
+func TestDiff(t *testing.T) {
+	for name, b := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			bare, work := newRemote(t)
+			local := filepath.Join(t.TempDir(), "local")
+			if err := b.Clone(ctx, bare, local); err != nil {
+				t.Fatalf("clone: %v", err)
+			}
+
+			files, err := b.Diff(ctx, local, "origin/master", "origin/dev")
+			if err != nil || !reflect.DeepEqual(files, []string{"b.txt"}) {
+				t.Errorf("diff: expected [b.txt], got %v, %v", files, err)
+			}
+			if files, err = b.Diff(ctx, local, "v1", "master"); err != nil || len(files) != 0 {
+				t.Errorf("diff same tree: expected no file, got %v, %v", files, err)
+			}
+
+			os.MkdirAll(filepath.Join(work, "conf"), 0755)
+			commit(t, work, "conf/c.yaml", "c", "conf")
+			runGit(t, work, "mv", "a.txt", "conf/a.txt")
+			runGit(t, work, "commit", "-q", "-m", "move")
+			runGit(t, work, "push", "-q", "origin", "master")
+			if err := b.Fetch(ctx, local); err != nil {
+				t.Fatalf("fetch: %v", err)
+			}
+			files, err = b.Diff(ctx, local, "master", "origin/master")
+			if want := []string{"a.txt", "conf/a.txt", "conf/c.yaml"}; err != nil || !reflect.DeepEqual(files, want) {
+				t.Errorf("diff: expected %v, got %v, %v", want, files, err)
+			}
+			if _, err := b.Diff(ctx, local, "master", "nope"); ErrorCode(err) != BranchNotExists {
+				t.Errorf("diff nope: expected BranchNotExists, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMatchGlobs(t *testing.T) {
+	tests := []struct {
+		file  string
+		globs []string
+		match bool
+	}{
+		{"conf/a.yaml", []string{"conf"}, true},
+		{"conf/prod/a.yaml", []string{"conf"}, true},
+		{"conf/a.yaml", []string{"conf/*.yaml"}, true},
+		{"conf/a.json", []string{"conf/*.yaml"}, false},
+		{"a.yaml", []string{"*.yaml"}, true},
+		{"conf/a.yaml", []string{"*.yaml"}, false},
+		{"config/a.yaml", []string{"conf"}, false},
+	}
+	for _, test := range tests {
+		if match := matchGlobs(test.globs, test.file); match != test.match {
+			t.Errorf("%s %v: expected %v, got %v", test.file, test.globs, test.match, match)
+		}
+	}
+}
+
+func TestWatcherPoll(t *testing.T) {
+	for name, b := range backends {
+		t.Run(name, func(t *testing.T) {
+			testWatcherPoll(t, b)
+		})
+	}
+}
+
+func testWatcherPoll(t *testing.T, b Backend) {
+	ctx := context.Background()
+	bare, work := newRemote(t)
+	r := New(filepath.Join(t.TempDir(), "local"), bare, WithBackend(b))
+	w, err := NewWatcher(r, []string{"master", "dev", "feature"}, WithPathGlobs("conf"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	events, cancel := w.Subscribe(10)
+	defer cancel()
+
+	// clones the repo, and no event
+	if err := w.Poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	master := runGit(t, work, "rev-parse", "master")
+	if heads := w.Heads(); len(heads) != 2 || heads["master"] != master {
+		t.Errorf("heads: unexpected %v", heads)
+	}
+
+	// a.txt is filtered out
+	commit(t, work, "a.txt", "v2", "second")
+	os.MkdirAll(filepath.Join(work, "conf"), 0755)
+	third := commit(t, work, "conf/c.yaml", "c", "conf")
+	runGit(t, work, "push", "-q", "origin", "master")
+	runGit(t, work, "push", "-q", "origin", "master:feature", ":dev")
+	if err := w.Poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+
+	got := map[string]Event{}
+	for len(events) > 0 {
+		e := <-events
+		got[e.Ref] = e
+	}
+	if e := got["master"]; e.Old != master || e.New != third || !reflect.DeepEqual(e.Files, []string{"conf/c.yaml"}) {
+		t.Errorf("master: unexpected event %+v", e)
+	}
+	if e, ok := got["dev"]; !ok || e.Old == "" || e.New != "" {
+		t.Errorf("dev deleted: unexpected event %+v", e)
+	}
+	if e, ok := got["feature"]; !ok || e.Old != "" || e.New != third {
+		t.Errorf("feature created: unexpected event %+v", e)
+	}
+	if len(got) != 3 {
+		t.Errorf("expected 3 events, got %v", got)
+	}
+
+	// not matching the globs
+	commit(t, work, "a.txt", "v3", "third")
+	runGit(t, work, "push", "-q", "origin", "master")
+	if err := w.Poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("expected no event, got %+v", <-events)
+	}
+}
+
+func TestWatcherRun(t *testing.T) {
+	bare, work := newRemote(t)
+	r := New(filepath.Join(t.TempDir(), "local"), bare)
+	w, err := NewWatcher(r, []string{"master"}, WithInterval(50*time.Millisecond, 0.1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	events, cancel := w.Subscribe(1)
+	defer cancel()
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		w.Run(stopCh)
+		close(done)
+	}()
+	defer func() {
+		close(stopCh)
+		<-done
+	}()
+
+	for len(w.Heads()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	second := commit(t, work, "a.txt", "v2", "second")
+	runGit(t, work, "push", "-q", "origin", "master")
+
+	select {
+	case e := <-events:
+		if e.Ref != "master" || e.New != second || !reflect.DeepEqual(e.Files, []string{"a.txt"}) {
+			t.Errorf("unexpected event %+v", e)
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("timeout waiting for event")
+	}
+}
+
+func TestNewWatcherErrors(t *testing.T) {
+	r := New(t.TempDir(), "")
+	if _, err := NewWatcher(r, nil); err == nil {
+		t.Errorf("no refs: expected error")
+	}
+	if _, err := NewWatcher(r, []string{"master"}, WithInterval(0, 0)); err == nil {
+		t.Errorf("zero interval: expected error")
+	}
+	if _, err := NewWatcher(r, []string{"master"}, WithPathGlobs("[")); err == nil {
+		t.Errorf("bad glob: expected error")
+	}
+}
@@ -0,0 +1,123 @@
+package zk
+
+import (
+	"context"
+	"errors"
+	"path"
+)
+
+// readyNode is created under the dir of a DoubleBarrier when enough participants entered
+const readyNode = "ready"
+
+// DoubleBarrier lets count participants enter a computation together, and leave it together.
+// Each participant creates an ephemeral sequential node under dir when entering, and deletes
+// it when leaving. Each participant must use its own DoubleBarrier
+type DoubleBarrier struct {
+	c     *Client
+	dir   string
+	name  string
+	count int
+	node  string
+}
+
+// NewDoubleBarrier returns a barrier waits for count participants, name is prefix of the node
+// of this participant
+func NewDoubleBarrier(c *Client, dir, name string, count int) *DoubleBarrier {
+	return &DoubleBarrier{c: c, dir: dir, name: name, count: count}
+}
+
+// Enter waits until count participants entered, or ctx is done
+func (b *DoubleBarrier) Enter(ctx context.Context) error {
+	if b.node != "" {
+		return newError("enter", b.dir, ErrNodeExists)
+	}
+	node, err := b.c.CreateAll(path.Join(b.dir, b.name+"-"), nil, EphemeralSequential)
+	if err != nil {
+		return err
+	}
+
+	ready := path.Join(b.dir, readyNode)
+	for {
+		ok, _, ch, err := b.c.client.ExistsW(ready)
+		if err != nil {
+			b.c.client.Delete(node, AnyVersion)
+			return newError("enter", ready, err)
+		}
+		if ok {
+			b.node = node
+			return nil
+		}
+
+		children, _, err := b.c.client.Children(b.dir)
+		if err != nil {
+			b.c.client.Delete(node, AnyVersion)
+			return newError("enter", b.dir, err)
+		}
+		if len(sequenceNodes(children)) >= b.count {
+			if _, err := b.c.client.Create(ready, nil, 0, b.c.acl); err != nil && !errors.Is(err, ErrNodeExists) {
+				b.c.client.Delete(node, AnyVersion)
+				return newError("enter", ready, err)
+			}
+			continue
+		}
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			b.c.client.Delete(node, AnyVersion)
+			return newError("enter", b.dir, ctx.Err())
+		}
+	}
+}
+
+// Leave deletes the node of this participant, and waits until all participants left,
+// or ctx is done. The last one leaving deletes the ready node, so the barrier can be used again
+func (b *DoubleBarrier) Leave(ctx context.Context) error {
+	if b.node == "" {
+		return newError("leave", b.dir, ErrNoNode)
+	}
+	name := path.Base(b.node)
+	for {
+		children, _, err := b.c.client.Children(b.dir)
+		if err != nil {
+			return newError("leave", b.dir, err)
+		}
+		children = sequenceNodes(children)
+		i := indexOf(children, name)
+		if len(children) == 0 || (len(children) == 1 && i == 0) {
+			if i == 0 {
+				if err := b.c.client.Delete(b.node, AnyVersion); err != nil && !errors.Is(err, ErrNoNode) {
+					return newError("leave", b.node, err)
+				}
+			}
+			if err := b.c.client.Delete(path.Join(b.dir, readyNode), AnyVersion); err != nil && !errors.Is(err, ErrNoNode) {
+				return newError("leave", b.dir, err)
+			}
+			b.node = ""
+			return nil
+		}
+
+		// the lowest waits for the highest to leave, others leave and wait for the lowest
+		wait := children[len(children)-1]
+		if i != 0 {
+			if i > 0 {
+				if err := b.c.client.Delete(b.node, AnyVersion); err != nil && !errors.Is(err, ErrNoNode) {
+					return newError("leave", b.node, err)
+				}
+			}
+			wait = children[0]
+		}
+		ok, _, ch, err := b.c.client.ExistsW(path.Join(b.dir, wait))
+		if err != nil {
+			return newError("leave", b.dir, err)
+		}
+		if !ok {
+			continue
+		}
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return newError("leave", b.dir, ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package zk
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDoubleBarrier(t *testing.T) {
+	const n = 3
+	s := newFakeServer()
+	obs, _ := newFakeClient(t, s)
+	var (
+		wg      sync.WaitGroup
+		entered int32
+		left    int32
+	)
+	for round := 0; round < 2; round++ {
+		for i := 0; i < n; i++ {
+			c, _ := newFakeClient(t, s)
+			b := NewDoubleBarrier(c, "/barrier", "p", n)
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				// arrive at different times
+				time.Sleep(time.Duration(i) * 10 * time.Millisecond)
+				if err := b.Enter(context.Background()); err != nil {
+					t.Errorf("enter: %v", err)
+					return
+				}
+				if got := atomic.AddInt32(&entered, 1); got > n*int32(round+1) {
+					t.Errorf("entered more than %d", n)
+				}
+				time.Sleep(time.Duration(n-i) * 10 * time.Millisecond)
+				if err := b.Leave(context.Background()); err != nil {
+					t.Errorf("leave: %v", err)
+					return
+				}
+				// all entered before any left
+				if got := atomic.LoadInt32(&entered); got != n*int32(round+1) {
+					t.Errorf("left when %d entered", got)
+				}
+				atomic.AddInt32(&left, 1)
+			}(i)
+		}
+		wg.Wait()
+		if left != n*int32(round+1) {
+			t.Fatalf("round %d: expected %d left, got %d", round, n, left)
+		}
+		// ready is deleted by the last one left, so the barrier can be used again
+		if children, _, err := obs.Children("/barrier"); err != nil || len(children) != 0 {
+			t.Errorf("round %d: expected no node left, got %v, %v", round, children, err)
+		}
+	}
+}
+
+func TestDoubleBarrierTimeout(t *testing.T) {
+	s := newFakeServer()
+	c, _ := newFakeClient(t, s)
+	b := NewDoubleBarrier(c, "/barrier", "p", 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := b.Enter(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("enter alone: expected DeadlineExceeded, got %v", err)
+	}
+	if children, _, err := c.Children("/barrier"); err != nil || len(children) != 0 {
+		t.Errorf("node of canceled enter not deleted: %v, %v", children, err)
+	}
+	if err := b.Leave(context.Background()); !errors.Is(err, ErrNoNode) {
+		t.Errorf("leave not entered: expected ErrNoNode, got %v", err)
+	}
+}
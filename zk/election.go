@@ -0,0 +1,143 @@
+package zk
+
+import (
+	"context"
+	"errors"
+	"path"
+	"time"
+
+	log "github.com/golang/glog"
+
+	zk "github.com/samuel/go-zookeeper/zk"
+)
+
+// ElectionCallbacks are called when leadership changes
+type ElectionCallbacks struct {
+	// OnGained is called in a goroutine when elected, ctx is canceled when leadership is
+	// lost, or may be lost as the connection is lost. It must return soon after ctx is canceled
+	OnGained func(ctx context.Context)
+	// OnLost is called after OnGained returned
+	OnLost func()
+}
+
+// Election elects a leader among the candidates, the session created the first ephemeral
+// sequential node under dir is the leader. If its session expires, the next one is elected
+type Election struct {
+	c         *Client
+	dir       string
+	id        string
+	callbacks ElectionCallbacks
+}
+
+// NewElection returns a candidate with id, which is value of its node
+func NewElection(c *Client, dir, id string, callbacks ElectionCallbacks) *Election {
+	return &Election{c: c, dir: dir, id: id, callbacks: callbacks}
+}
+
+// Leader returns id of the leader, empty if none
+func (e *Election) Leader() (string, error) {
+	children, _, err := e.c.client.Children(e.dir)
+	if errors.Is(err, ErrNoNode) {
+		return "", nil
+	} else if err != nil {
+		return "", newError("leader", e.dir, err)
+	}
+	for _, name := range sequenceNodes(children) {
+		id, _, err := e.c.client.Get(path.Join(e.dir, name))
+		if errors.Is(err, ErrNoNode) {
+			// deleted meanwhile
+			continue
+		} else if err != nil {
+			return "", newError("leader", e.dir, err)
+		}
+		return string(id), nil
+	}
+	return "", nil
+}
+
+// Run campaigns until ctx is done, it campaigns again after leadership is lost. It blocks,
+// and returns the error of ctx, or an error if the client is closed
+func (e *Election) Run(ctx context.Context) error {
+	// stale node of the last term, which may still exist if the connection is lost but
+	// the session is not expired, it must be deleted, or the next term waits for it
+	var stale string
+	for {
+		err := e.c.deleteStale(&stale)
+		if err == nil {
+			stale, err = e.c.acquire(ctx, e.dir, "n-", []byte(e.id))
+			if err == nil {
+				err = e.lead(ctx, stale)
+			}
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if closed(err) {
+			return err
+		}
+		if err != nil {
+			log.Warningf("zk election %s: %v, retry in %v", e.dir, err, retryInterval)
+			select {
+			case <-time.After(retryInterval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// lead calls the callbacks, and watches node until it's deleted or ctx is done.
+// Leadership is also lost if node can't be watched, or the connection is lost, as the
+// session may have expired on the server, and another candidate elected
+func (e *Election) lead(ctx context.Context, node string) error {
+	// node is checked after watching the session, so a lost connection is never missed
+	lost := e.c.sessionLost()
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var gained chan struct{}
+	defer func() {
+		if gained == nil {
+			return
+		}
+		cancel()
+		<-gained
+		if e.callbacks.OnLost != nil {
+			e.callbacks.OnLost()
+		}
+	}()
+
+	for {
+		ok, _, ch, err := e.c.client.ExistsW(node)
+		if err != nil {
+			return newError("lead", node, err)
+		}
+		if !ok {
+			log.Warningf("zk election %s: %s lost leadership, node is deleted", e.dir, e.id)
+			return nil
+		}
+		if gained == nil {
+			log.Infof("zk election %s: %s is elected", e.dir, e.id)
+			gained = make(chan struct{})
+			go func() {
+				defer close(gained)
+				if e.callbacks.OnGained != nil {
+					e.callbacks.OnGained(lctx)
+				}
+			}()
+		}
+
+		select {
+		case ev := <-ch:
+			if ev.Type == zk.EventNotWatching && closed(ev.Err) {
+				return newError("lead", node, ev.Err)
+			}
+		case <-lost:
+			log.Warningf("zk election %s: %s lost leadership, connection is lost", e.dir, e.id)
+			return newError("lead", node, ErrSessionLost)
+		case <-ctx.Done():
+			e.c.client.Delete(node, AnyVersion)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package zk
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"we.com/jiabiao/common/wait"
+)
+
+type candidate struct {
+	e      *Election
+	conn   *fakeConn
+	gained chan struct{}
+	lost   chan struct{}
+	// leading is canceled when leadership is lost
+	leading chan context.Context
+	// active while OnGained is running
+	active int32
+}
+
+func newCandidate(t *testing.T, s *fakeServer, id string) *candidate {
+	c, conn := newFakeClient(t, s)
+	cand := &candidate{
+		conn:    conn,
+		gained:  make(chan struct{}, 10),
+		lost:    make(chan struct{}, 10),
+		leading: make(chan context.Context, 10),
+	}
+	cand.e = NewElection(c, "/election", id, ElectionCallbacks{
+		OnGained: func(ctx context.Context) {
+			atomic.StoreInt32(&cand.active, 1)
+			cand.leading <- ctx
+			cand.gained <- struct{}{}
+			<-ctx.Done()
+			time.Sleep(10 * time.Millisecond)
+			atomic.StoreInt32(&cand.active, 0)
+		},
+		OnLost: func() {
+			if atomic.LoadInt32(&cand.active) != 0 {
+				t.Errorf("%s: OnLost is called before OnGained returned", id)
+			}
+			cand.lost <- struct{}{}
+		},
+	})
+	return cand
+}
+
+func expectSignal(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("timeout waiting for %s", what)
+	}
+}
+
+func expectNoSignal(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+		t.Fatalf("unexpected %s", what)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestElection(t *testing.T) {
+	s := newFakeServer()
+	a := newCandidate(t, s, "a")
+	b := newCandidate(t, s, "b")
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	doneA := make(chan error, 1)
+	go func() { doneA <- a.e.Run(ctxA) }()
+	expectSignal(t, a.gained, "a gained")
+	leaderCtx := <-a.leading
+
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+	go b.e.Run(ctxB)
+	expectNoSignal(t, b.gained, "b gained")
+	if leader, err := b.e.Leader(); err != nil || leader != "a" {
+		t.Errorf("leader: expected a, got %s, %v", leader, err)
+	}
+
+	// a loses leadership as its session expires, and campaigns again
+	a.conn.expire()
+	expectSignal(t, a.lost, "a lost")
+	if leaderCtx.Err() == nil {
+		t.Errorf("ctx of leader a is not canceled")
+	}
+	expectSignal(t, b.gained, "b gained")
+	expectNoSignal(t, a.gained, "a gained")
+	if leader, err := a.e.Leader(); err != nil || leader != "b" {
+		t.Errorf("leader: expected b, got %s, %v", leader, err)
+	}
+
+	// b resigns
+	cancelB()
+	expectSignal(t, b.lost, "b lost")
+	expectSignal(t, a.gained, "a gained")
+
+	cancelA()
+	if err := <-doneA; err != context.Canceled {
+		t.Errorf("run: expected Canceled, got %v", err)
+	}
+	expectSignal(t, a.lost, "a lost")
+	if leader, err := a.e.Leader(); err != nil || leader != "" {
+		t.Errorf("leader: expected none, got %s, %v", leader, err)
+	}
+}
+
+func TestElectionConnectionLost(t *testing.T) {
+	defer func(d time.Duration) { retryInterval = d }(retryInterval)
+	retryInterval = 10 * time.Millisecond
+
+	s := newFakeServer()
+	a := newCandidate(t, s, "a")
+	b := newCandidate(t, s, "b")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go a.e.Run(ctx)
+	expectSignal(t, a.gained, "a gained")
+	leaderCtx := <-a.leading
+	go b.e.Run(ctx)
+	expectNoSignal(t, b.gained, "b gained")
+
+	// a stops leading as soon as the connection is lost, before its session expires
+	// and b is elected, the session expired event is only received after reconnected
+	a.conn.setDown(true)
+	expectSignal(t, a.lost, "a lost")
+	if leaderCtx.Err() == nil {
+		t.Errorf("ctx of leader a is not canceled")
+	}
+	expectNoSignal(t, b.gained, "b gained")
+	a.conn.expire()
+	expectSignal(t, b.gained, "b gained")
+
+	// a campaigns again after reconnected
+	a.conn.setDown(false)
+	expectNoSignal(t, a.gained, "a gained")
+	if leader, err := a.e.Leader(); err != nil || leader != "b" {
+		t.Errorf("leader: expected b, got %s, %v", leader, err)
+	}
+	if children, _, _ := a.e.c.Children("/election"); len(children) != 2 {
+		t.Errorf("expected a campaigns again, got %v", children)
+	}
+
+	// the connection is lost but the session does not expire, a is still a candidate
+	b.conn.setDown(true)
+	expectSignal(t, b.lost, "b lost")
+	b.conn.setDown(false)
+	expectSignal(t, a.gained, "a gained")
+}
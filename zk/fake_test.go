@@ -0,0 +1,469 @@
+package zk
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	zk "github.com/samuel/go-zookeeper/zk"
+)
+
+// fakeServer is an in-process stand-in of a zookeeper server, shared by fakeConns
+type fakeServer struct {
+	mu          sync.Mutex
+	nodes       map[string]*fakeNode
+	zxid        int64
+	nextSession int64
+	// watches of path, of type data (GetW, ExistsW of an existing node),
+	// exist (ExistsW of a missing node) and child (ChildrenW)
+	watches map[fakeWatchKey][]*fakeWatch
+}
+
+type fakeNode struct {
+	data     []byte
+	stat     zk.Stat
+	acl      []zk.ACL
+	children map[string]bool
+	seq      int32
+}
+
+type fakeWatchKey struct {
+	path string
+	typ  string
+}
+
+type fakeWatch struct {
+	ch   chan zk.Event
+	conn *fakeConn
+}
+
+// fakeConn is a session of a fakeServer
+type fakeConn struct {
+	srv     *fakeServer
+	session int64
+	auths   []string
+	closed  bool
+	// down requests fail with ErrNoServer, as the server is not reachable
+	down bool
+	// expired while down, watches are invalidated after reconnected, like *zk.Conn
+	expired bool
+	// events of the session
+	events chan zk.Event
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{
+		nodes: map[string]*fakeNode{
+			"/": {children: map[string]bool{}, acl: zk.WorldACL(zk.PermAll)},
+		},
+		watches: map[fakeWatchKey][]*fakeWatch{},
+	}
+}
+
+// connect a new session
+func (s *fakeServer) connect() *fakeConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextSession++
+	return &fakeConn{srv: s, session: s.nextSession, events: make(chan zk.Event, 16)}
+}
+
+// newFakeClient returns a client of a new session of s
+func newFakeClient(t *testing.T, s *fakeServer, opts ...Option) (*Client, *fakeConn) {
+	t.Helper()
+	conn := s.connect()
+	c, err := newClient(conn, conn.events, newOptions(opts))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return c, conn
+}
+
+func (s *fakeServer) trigger(p, typ string, e zk.Event) {
+	key := fakeWatchKey{p, typ}
+	for _, w := range s.watches[key] {
+		w.ch <- e
+		close(w.ch)
+	}
+	delete(s.watches, key)
+}
+
+func (s *fakeServer) addWatch(c *fakeConn, p, typ string) <-chan zk.Event {
+	w := &fakeWatch{ch: make(chan zk.Event, 1), conn: c}
+	key := fakeWatchKey{p, typ}
+	s.watches[key] = append(s.watches[key], w)
+	return w.ch
+}
+
+// invalidate watches of c, as a session ends
+func (s *fakeServer) invalidate(c *fakeConn, err error) {
+	for key, watches := range s.watches {
+		var kept []*fakeWatch
+		for _, w := range watches {
+			if w.conn != c {
+				kept = append(kept, w)
+				continue
+			}
+			w.ch <- zk.Event{Type: zk.EventNotWatching, State: zk.StateDisconnected, Path: key.path, Err: err}
+			close(w.ch)
+		}
+		s.watches[key] = kept
+	}
+}
+
+// removeEphemerals of session, as it ends
+func (s *fakeServer) removeEphemerals(session int64) {
+	var paths []string
+	for p, n := range s.nodes {
+		if n.stat.EphemeralOwner == session {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		s.remove(p)
+	}
+}
+
+func (s *fakeServer) remove(p string) {
+	parent := s.nodes[path.Dir(p)]
+	delete(parent.children, path.Base(p))
+	s.zxid++
+	parent.stat.Cversion++
+	parent.stat.NumChildren--
+	parent.stat.Pzxid = s.zxid
+	delete(s.nodes, p)
+
+	e := zk.Event{Type: zk.EventNodeDeleted, State: zk.StateHasSession, Path: p}
+	s.trigger(p, "data", e)
+	s.trigger(p, "exist", e)
+	s.trigger(p, "child", e)
+	s.trigger(path.Dir(p), "child", zk.Event{Type: zk.EventNodeChildrenChanged, State: zk.StateHasSession, Path: path.Dir(p)})
+}
+
+// expire the session of c, its ephemeral nodes are removed by the server. Like *zk.Conn,
+// c reconnects with a new session, and its watches are invalidated, which is delayed
+// until c is up again if it's down
+func (c *fakeConn) expire() {
+	s := c.srv
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeEphemerals(c.session)
+	s.nextSession++
+	c.session = s.nextSession
+	if c.down {
+		c.expired = true
+		return
+	}
+	c.reconnect()
+}
+
+func (c *fakeConn) reconnect() {
+	c.srv.invalidate(c, zk.ErrSessionExpired)
+	c.sendEvent(zk.StateExpired)
+	c.sendEvent(zk.StateHasSession)
+}
+
+// setDown makes requests of c fail or succeed again, with session events sent
+func (c *fakeConn) setDown(down bool) {
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+	if c.closed || c.down == down {
+		return
+	}
+	c.down = down
+	if down {
+		c.sendEvent(zk.StateDisconnected)
+		return
+	}
+	if c.expired {
+		c.expired = false
+		c.reconnect()
+		return
+	}
+	c.sendEvent(zk.StateHasSession)
+}
+
+// sendEvent of the session, dropped if events is full, like *zk.Conn
+func (c *fakeConn) sendEvent(state zk.State) {
+	select {
+	case c.events <- zk.Event{Type: zk.EventSession, State: state}:
+	default:
+	}
+}
+
+func checkPath(p string) error {
+	if p == "" || p[0] != '/' || (p != "/" && strings.HasSuffix(p, "/")) || strings.Contains(p, "//") {
+		return zk.ErrInvalidPath
+	}
+	return nil
+}
+
+// allowed whether c has perm on the node
+func (c *fakeConn) allowed(n *fakeNode, perm int32) bool {
+	for _, acl := range n.acl {
+		if acl.Perms&perm == 0 {
+			continue
+		}
+		if acl.Scheme == "world" && acl.ID == "anyone" {
+			return true
+		}
+		for _, a := range c.auths {
+			kv := strings.SplitN(a, ":", 2)
+			if acl.Scheme == "digest" && zk.DigestACL(perm, kv[0], kv[1])[0].ID == acl.ID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// node returns the node at p, checks the session and perm
+func (c *fakeConn) node(p string, perm int32) (*fakeNode, error) {
+	if c.closed {
+		return nil, zk.ErrClosing
+	}
+	if c.down {
+		return nil, zk.ErrNoServer
+	}
+	if err := checkPath(p); err != nil {
+		return nil, err
+	}
+	n, ok := c.srv.nodes[p]
+	if !ok {
+		return nil, zk.ErrNoNode
+	}
+	if perm != 0 && !c.allowed(n, perm) {
+		return nil, zk.ErrNoAuth
+	}
+	return n, nil
+}
+
+func (c *fakeConn) AddAuth(scheme string, auth []byte) error {
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+	if scheme != "digest" || !strings.Contains(string(auth), ":") {
+		return zk.ErrAuthFailed
+	}
+	c.auths = append(c.auths, string(auth))
+	return nil
+}
+
+func (c *fakeConn) Children(p string) ([]string, *zk.Stat, error) {
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+	return c.children(p)
+}
+
+func (c *fakeConn) children(p string) ([]string, *zk.Stat, error) {
+	n, err := c.node(p, zk.PermRead)
+	if err != nil {
+		return nil, nil, err
+	}
+	var ret []string
+	for name := range n.children {
+		ret = append(ret, name)
+	}
+	stat := n.stat
+	return ret, &stat, nil
+}
+
+func (c *fakeConn) ChildrenW(p string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+	children, stat, err := c.children(p)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return children, stat, c.srv.addWatch(c, p, "child"), nil
+}
+
+func (c *fakeConn) Get(p string) ([]byte, *zk.Stat, error) {
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+	return c.get(p)
+}
+
+func (c *fakeConn) get(p string) ([]byte, *zk.Stat, error) {
+	n, err := c.node(p, zk.PermRead)
+	if err != nil {
+		return nil, nil, err
+	}
+	stat := n.stat
+	return append([]byte(nil), n.data...), &stat, nil
+}
+
+func (c *fakeConn) GetW(p string) ([]byte, *zk.Stat, <-chan zk.Event, error) {
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+	data, stat, err := c.get(p)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return data, stat, c.srv.addWatch(c, p, "data"), nil
+}
+
+func (c *fakeConn) Set(p string, data []byte, version int32) (*zk.Stat, error) {
+	s := c.srv
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := c.node(p, zk.PermWrite)
+	if err != nil {
+		return nil, err
+	}
+	if version != -1 && version != n.stat.Version {
+		return nil, zk.ErrBadVersion
+	}
+	s.zxid++
+	n.data = append([]byte(nil), data...)
+	n.stat.Version++
+	n.stat.Mzxid = s.zxid
+	n.stat.Mtime = time.Now().UnixNano() / int64(time.Millisecond)
+	n.stat.DataLength = int32(len(data))
+	s.trigger(p, "data", zk.Event{Type: zk.EventNodeDataChanged, State: zk.StateHasSession, Path: p})
+	stat := n.stat
+	return &stat, nil
+}
+
+func (c *fakeConn) Create(p string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	s := c.srv
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.closed {
+		return "", zk.ErrClosing
+	}
+	if err := checkPath(p); err != nil || p == "/" {
+		return "", zk.ErrInvalidPath
+	}
+	parent, err := c.node(path.Dir(p), zk.PermCreate)
+	if err != nil {
+		return "", err
+	}
+	if parent.stat.EphemeralOwner != 0 {
+		return "", zk.ErrNoChildrenForEphemerals
+	}
+
+	if flags&zk.FlagSequence != 0 {
+		p = fmt.Sprintf("%s%010d", p, parent.seq)
+		parent.seq++
+	}
+	if _, ok := s.nodes[p]; ok {
+		return "", zk.ErrNodeExists
+	}
+
+	// the auth scheme is the ids the session authenticated as
+	var nodeACL []zk.ACL
+	for _, a := range acl {
+		if a.Scheme != "auth" {
+			nodeACL = append(nodeACL, a)
+			continue
+		}
+		if len(c.auths) == 0 {
+			return "", zk.ErrInvalidACL
+		}
+		for _, auth := range c.auths {
+			kv := strings.SplitN(auth, ":", 2)
+			nodeACL = append(nodeACL, zk.DigestACL(a.Perms, kv[0], kv[1])...)
+		}
+	}
+	if len(nodeACL) == 0 {
+		return "", zk.ErrInvalidACL
+	}
+
+	s.zxid++
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	n := &fakeNode{
+		data:     append([]byte(nil), data...),
+		acl:      nodeACL,
+		children: map[string]bool{},
+		stat: zk.Stat{
+			Czxid: s.zxid, Mzxid: s.zxid, Pzxid: s.zxid,
+			Ctime: now, Mtime: now,
+			DataLength: int32(len(data)),
+		},
+	}
+	if flags&zk.FlagEphemeral != 0 {
+		n.stat.EphemeralOwner = c.session
+	}
+	s.nodes[p] = n
+	parent.children[path.Base(p)] = true
+	parent.stat.Cversion++
+	parent.stat.NumChildren++
+	parent.stat.Pzxid = s.zxid
+
+	s.trigger(p, "exist", zk.Event{Type: zk.EventNodeCreated, State: zk.StateHasSession, Path: p})
+	s.trigger(path.Dir(p), "child", zk.Event{Type: zk.EventNodeChildrenChanged, State: zk.StateHasSession, Path: path.Dir(p)})
+	return p, nil
+}
+
+func (c *fakeConn) Delete(p string, version int32) error {
+	s := c.srv
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := c.node(p, 0)
+	if err != nil {
+		return err
+	}
+	if p == "/" {
+		return zk.ErrAPIError
+	}
+	if parent := s.nodes[path.Dir(p)]; !c.allowed(parent, zk.PermDelete) {
+		return zk.ErrNoAuth
+	}
+	if version != -1 && version != n.stat.Version {
+		return zk.ErrBadVersion
+	}
+	if len(n.children) > 0 {
+		return zk.ErrNotEmpty
+	}
+	s.remove(p)
+	return nil
+}
+
+func (c *fakeConn) Exists(p string) (bool, *zk.Stat, error) {
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+	return c.exists(p)
+}
+
+func (c *fakeConn) exists(p string) (bool, *zk.Stat, error) {
+	n, err := c.node(p, 0)
+	if err == zk.ErrNoNode {
+		return false, &zk.Stat{}, nil
+	} else if err != nil {
+		return false, nil, err
+	}
+	stat := n.stat
+	return true, &stat, nil
+}
+
+func (c *fakeConn) ExistsW(p string) (bool, *zk.Stat, <-chan zk.Event, error) {
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+	ok, stat, err := c.exists(p)
+	if err != nil {
+		return false, nil, nil, err
+	}
+	typ := "exist"
+	if ok {
+		typ = "data"
+	}
+	return ok, stat, c.srv.addWatch(c, p, typ), nil
+}
+
+func (c *fakeConn) Close() {
+	s := c.srv
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	s.removeEphemerals(c.session)
+	s.invalidate(c, zk.ErrClosing)
+	close(c.events)
+}
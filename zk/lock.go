@@ -0,0 +1,212 @@
+package zk
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sort"
+	"strconv"
+
+	log "github.com/golang/glog"
+)
+
+// seqLen length of the sequence number appended to sequential nodes
+const seqLen = 10
+
+var (
+	// ErrNotLocked Unlock a lock not held
+	ErrNotLocked = errors.New("zk: lock is not held")
+	// ErrSessionLost the connection is lost or the session expired, ephemeral nodes may be removed
+	ErrSessionLost = errors.New("zk: connection lost or session expired")
+)
+
+// Lock is a distributed lock, the holder is the session created the first ephemeral
+// sequential node under dir. It's reentrant, Lock again when held only counts, and it's
+// released after Unlock as many times. Each process, or goroutine, contending for the lock
+// must use its own Lock
+type Lock struct {
+	c   *Client
+	dir string
+	// sem is held while acquiring or releasing, a chan so waiting can be canceled
+	sem   chan struct{}
+	node  string
+	count int
+	// stale node left by a failed Lock or Unlock
+	stale string
+	// lost of the session when acquired
+	lost <-chan struct{}
+}
+
+// NewLock returns a lock using nodes under dir, which is created if not exists
+func NewLock(c *Client, dir string) *Lock {
+	return &Lock{c: c, dir: dir, sem: make(chan struct{}, 1)}
+}
+
+// Lock waits until the lock is acquired, or ctx is done
+func (l *Lock) Lock(ctx context.Context) error {
+	select {
+	case l.sem <- struct{}{}:
+	case <-ctx.Done():
+		return newError("lock", l.dir, ctx.Err())
+	}
+	defer func() { <-l.sem }()
+
+	if l.count > 0 {
+		l.count++
+		return nil
+	}
+	if err := l.c.deleteStale(&l.stale); err != nil {
+		return err
+	}
+	node, err := l.c.acquire(ctx, l.dir, "lock-", nil)
+	if err != nil {
+		l.stale = node
+		return err
+	}
+	// node is checked after watching the session, so a lost connection is never missed
+	lost := l.c.sessionLost()
+	if ok, _, err := l.c.client.Exists(node); err != nil || !ok {
+		l.stale = node
+		return newError("lock", node, ErrSessionLost)
+	}
+	l.node = node
+	l.count = 1
+	l.lost = lost
+	return nil
+}
+
+// Lost returns a chan closed when the lock may be lost, as the connection is lost or the
+// session expired, the server may have removed the node, and granted the lock to another
+// session. The holder must stop the work protected by the lock, and Unlock.
+// It's nil if the lock is not held
+func (l *Lock) Lost() <-chan struct{} {
+	l.sem <- struct{}{}
+	defer func() { <-l.sem }()
+	return l.lost
+}
+
+// Unlock releases the lock if it's unlocked as many times as locked
+func (l *Lock) Unlock() error {
+	l.sem <- struct{}{}
+	defer func() { <-l.sem }()
+
+	if l.count == 0 {
+		return newError("unlock", l.dir, ErrNotLocked)
+	}
+	l.count--
+	if l.count > 0 {
+		return nil
+	}
+	node := l.node
+	l.node = ""
+	l.lost = nil
+	if err := l.c.client.Delete(node, AnyVersion); err != nil && !errors.Is(err, ErrNoNode) {
+		// deleted by the next Lock
+		l.stale = node
+		return newError("unlock", node, err)
+	}
+	return nil
+}
+
+// acquire creates an ephemeral sequential node dir/prefix..., and waits until it's the first
+// one under dir. The node is created again if it's removed as the session expires.
+// Returns path of the node, which is deleted if failed. If it can't be deleted, e.g. the
+// connection is lost, its path is returned with the error, the caller must delete it later
+func (c *Client) acquire(ctx context.Context, dir, prefix string, data []byte) (string, error) {
+	for {
+		node, err := c.CreateAll(path.Join(dir, prefix), data, EphemeralSequential)
+		if err != nil {
+			return "", err
+		}
+		err = c.waitFirst(ctx, dir, node)
+		if err == nil {
+			return node, nil
+		}
+		if derr := c.client.Delete(node, AnyVersion); derr != nil && !errors.Is(derr, ErrNoNode) {
+			log.Warningf("zk delete %s: %v", node, derr)
+			return node, err
+		}
+		if !errors.Is(err, ErrNoNode) {
+			return "", err
+		}
+	}
+}
+
+// deleteStale deletes *node left by a failed acquire, if any
+func (c *Client) deleteStale(node *string) error {
+	if *node == "" {
+		return nil
+	}
+	if err := c.client.Delete(*node, AnyVersion); err != nil && !errors.Is(err, ErrNoNode) {
+		return newError("delete", *node, err)
+	}
+	*node = ""
+	return nil
+}
+
+// waitFirst waits until node is the first sequential node under dir, it watches
+// the one just before node, so only one waiter is woken up when a node is deleted.
+// Returns an error wraps ErrNoNode if node does not exist
+func (c *Client) waitFirst(ctx context.Context, dir, node string) error {
+	for {
+		children, _, err := c.client.Children(dir)
+		if err != nil {
+			return newError("wait", dir, err)
+		}
+		children = sequenceNodes(children)
+		i := indexOf(children, path.Base(node))
+		if i < 0 {
+			return newError("wait", node, ErrNoNode)
+		}
+		if i == 0 {
+			return nil
+		}
+
+		prev := path.Join(dir, children[i-1])
+		ok, _, ch, err := c.client.ExistsW(prev)
+		if err != nil {
+			return newError("wait", prev, err)
+		}
+		if !ok {
+			continue
+		}
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return newError("wait", node, ctx.Err())
+		}
+	}
+}
+
+// sequenceNodes returns names of sequential nodes, sorted by sequence numbers
+func sequenceNodes(names []string) []string {
+	var ret []string
+	for _, name := range names {
+		if sequence(name) >= 0 {
+			ret = append(ret, name)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return sequence(ret[i]) < sequence(ret[j]) })
+	return ret
+}
+
+// sequence number of a sequential node, -1 if name has none
+func sequence(name string) int64 {
+	if len(name) < seqLen {
+		return -1
+	}
+	seq, err := strconv.ParseInt(name[len(name)-seqLen:], 10, 64)
+	if err != nil || seq < 0 {
+		return -1
+	}
+	return seq
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,192 @@
+package zk
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"we.com/jiabiao/common/wait"
+)
+
+func TestSequenceNodes(t *testing.T) {
+	got := sequenceNodes([]string{"lock-0000000010", "ready", "n-0000000002", "x-00000000a1", "lock-0000000001"})
+	if want := []string{"lock-0000000001", "n-0000000002", "lock-0000000010"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestLock(t *testing.T) {
+	s := newFakeServer()
+	c1, _ := newFakeClient(t, s)
+	c2, _ := newFakeClient(t, s)
+	l1 := NewLock(c1, "/locks/job")
+	l2 := NewLock(c2, "/locks/job")
+	ctx := context.Background()
+
+	if err := l1.Lock(ctx); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	// reentrant
+	if err := l1.Lock(ctx); err != nil {
+		t.Fatalf("lock again: %v", err)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := l2.Lock(timeout); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("lock held by others: expected DeadlineExceeded, got %v", err)
+	}
+	if children, _, _ := c1.Children("/locks/job"); len(children) != 1 {
+		t.Errorf("node of canceled lock not deleted: %v", children)
+	}
+
+	locked := make(chan error)
+	go func() { locked <- l2.Lock(ctx) }()
+
+	if err := l1.Unlock(); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+	select {
+	case err := <-locked:
+		t.Fatalf("locked before unlocked as many times as locked: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := l1.Unlock(); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+	if err := <-locked; err != nil {
+		t.Fatalf("lock after released: %v", err)
+	}
+	if err := l1.Unlock(); !errors.Is(err, ErrNotLocked) {
+		t.Errorf("unlock not held: expected ErrNotLocked, got %v", err)
+	}
+	l2.Unlock()
+}
+
+func TestLockMutualExclusion(t *testing.T) {
+	s := newFakeServer()
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		holders int
+	)
+	for i := 0; i < 5; i++ {
+		c, _ := newFakeClient(t, s)
+		l := NewLock(c, "/lock")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if err := l.Lock(context.Background()); err != nil {
+					t.Errorf("lock: %v", err)
+					return
+				}
+				mu.Lock()
+				holders++
+				if holders != 1 {
+					t.Errorf("expected 1 holder, got %d", holders)
+				}
+				mu.Unlock()
+				time.Sleep(time.Millisecond)
+				mu.Lock()
+				holders--
+				mu.Unlock()
+				if err := l.Unlock(); err != nil {
+					t.Errorf("unlock: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestLockSessionExpiredWhileWaiting(t *testing.T) {
+	s := newFakeServer()
+	c1, _ := newFakeClient(t, s)
+	c2, conn2 := newFakeClient(t, s)
+	l1 := NewLock(c1, "/lock")
+	l2 := NewLock(c2, "/lock")
+	ctx := context.Background()
+
+	if err := l1.Lock(ctx); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	locked := make(chan error)
+	go func() { locked <- l2.Lock(ctx) }()
+	for {
+		if children, _, _ := c1.Children("/lock"); len(children) == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// the node of l2 is removed, and created again
+	conn2.expire()
+	time.Sleep(20 * time.Millisecond)
+	if children, _, _ := c1.Children("/lock"); len(children) != 2 {
+		t.Errorf("expected node of the waiter created again, got %v", children)
+	}
+	l1.Unlock()
+	if err := <-locked; err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+}
+
+func TestLockLost(t *testing.T) {
+	s := newFakeServer()
+	c1, conn1 := newFakeClient(t, s)
+	c2, _ := newFakeClient(t, s)
+	l1 := NewLock(c1, "/lock")
+	l2 := NewLock(c2, "/lock")
+	ctx := context.Background()
+
+	if l1.Lost() != nil {
+		t.Errorf("expected nil Lost of a lock not held")
+	}
+	if err := l1.Lock(ctx); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	lost := l1.Lost()
+	select {
+	case <-lost:
+		t.Fatalf("lost before the connection is lost")
+	default:
+	}
+
+	locked := make(chan error)
+	go func() { locked <- l2.Lock(ctx) }()
+
+	// the holder knows it before the session expires, and the lock is granted to l2
+	conn1.setDown(true)
+	select {
+	case <-lost:
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("timeout waiting for lost")
+	}
+	select {
+	case err := <-locked:
+		t.Fatalf("locked before the session expired: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	conn1.expire()
+	if err := <-locked; err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+
+	// the node can't be deleted while down, it's deleted by the next Lock
+	if err := l1.Unlock(); err == nil {
+		t.Errorf("unlock: expected error while down")
+	}
+	conn1.setDown(false)
+	l2.Unlock()
+	if err := l1.Lock(ctx); err != nil {
+		t.Fatalf("lock again: %v", err)
+	}
+	if children, _, _ := c1.Children("/lock"); len(children) != 1 {
+		t.Errorf("expected only the node of l1, got %v", children)
+	}
+	l1.Unlock()
+}
@@ -0,0 +1,157 @@
+package zk
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	zk "github.com/samuel/go-zookeeper/zk"
+)
+
+// Stat of a node
+type Stat = zk.Stat
+
+// AnyVersion matches any version of a node, for Set and Delete
+const AnyVersion int32 = -1
+
+// Errors of zookeeper, returned wrapped in *Error, test them with errors.Is
+var (
+	ErrNoNode         = zk.ErrNoNode
+	ErrNodeExists     = zk.ErrNodeExists
+	ErrBadVersion     = zk.ErrBadVersion
+	ErrNotEmpty       = zk.ErrNotEmpty
+	ErrNoAuth         = zk.ErrNoAuth
+	ErrAuthFailed     = zk.ErrAuthFailed
+	ErrInvalidACL     = zk.ErrInvalidACL
+	ErrSessionExpired = zk.ErrSessionExpired
+	ErrClosing        = zk.ErrClosing
+	ErrNoServer       = zk.ErrNoServer
+	ErrInvalidPath    = zk.ErrInvalidPath
+
+	// ErrNoChildrenForEphemerals ephemeral nodes may not have children
+	ErrNoChildrenForEphemerals = zk.ErrNoChildrenForEphemerals
+)
+
+// Error of an operation on a node
+type Error struct {
+	// Op e.g. create, delete, set
+	Op   string
+	Path string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("zk %s %s: %v", e.Op, e.Path, strings.TrimPrefix(e.Err.Error(), "zk: "))
+}
+
+// Unwrap returns the zookeeper error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func newError(op, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Op: op, Path: path, Err: err}
+}
+
+// CreateMode of a node
+type CreateMode int32
+
+const (
+	// Persistent node is kept until deleted
+	Persistent CreateMode = 0
+	// Ephemeral node is deleted when the session creates it ends, it can't have children
+	Ephemeral CreateMode = zk.FlagEphemeral
+	// PersistentSequential a monotonically increasing counter of the parent is appended to the name
+	PersistentSequential CreateMode = zk.FlagSequence
+	// EphemeralSequential both Ephemeral and sequential
+	EphemeralSequential CreateMode = zk.FlagEphemeral | zk.FlagSequence
+)
+
+// Create a node with data, returns the path created, which has the sequence number
+// appended if mode is sequential. The parent must exist
+func (c *Client) Create(p string, data []byte, mode CreateMode) (string, error) {
+	created, err := c.client.Create(p, data, int32(mode), c.acl)
+	return created, newError("create", p, err)
+}
+
+// CreateAll creates a node like Create, and its parents not exist as empty persistent nodes
+func (c *Client) CreateAll(p string, data []byte, mode CreateMode) (string, error) {
+	created, err := c.client.Create(p, data, int32(mode), c.acl)
+	if !errors.Is(err, ErrNoNode) {
+		return created, newError("create", p, err)
+	}
+
+	for _, parent := range parents(p) {
+		if _, err := c.client.Create(parent, nil, 0, c.acl); err != nil && !errors.Is(err, ErrNodeExists) {
+			return "", newError("create", parent, err)
+		}
+	}
+	created, err = c.client.Create(p, data, int32(mode), c.acl)
+	return created, newError("create", p, err)
+}
+
+// parents of p, the top first, "/" excluded
+func parents(p string) []string {
+	var ret []string
+	for dir := path.Dir(p); dir != "/" && dir != "."; dir = path.Dir(dir) {
+		ret = append([]string{dir}, ret...)
+	}
+	return ret
+}
+
+// Get value and stat of a node
+func (c *Client) Get(p string) ([]byte, *Stat, error) {
+	data, stat, err := c.client.Get(p)
+	return data, stat, newError("get", p, err)
+}
+
+// Set value of a node if its version is version, or AnyVersion.
+// Returns an error wraps ErrBadVersion if the node is changed by others
+func (c *Client) Set(p string, data []byte, version int32) (*Stat, error) {
+	stat, err := c.client.Set(p, data, version)
+	return stat, newError("set", p, err)
+}
+
+// Exists returns whether a node exists, and its stat if it does
+func (c *Client) Exists(p string) (bool, *Stat, error) {
+	ok, stat, err := c.client.Exists(p)
+	if !ok {
+		stat = nil
+	}
+	return ok, stat, newError("exists", p, err)
+}
+
+// Children returns names of children of a node, unsorted
+func (c *Client) Children(p string) ([]string, *Stat, error) {
+	children, stat, err := c.client.Children(p)
+	return children, stat, newError("children", p, err)
+}
+
+// Delete a node if its version is version, or AnyVersion, it must have no child
+func (c *Client) Delete(p string, version int32) error {
+	return newError("delete", p, c.client.Delete(p, version))
+}
+
+// DeleteAll deletes a node and all its descendants, regardless of versions.
+// It's not atomic, nodes created meanwhile may fail it with ErrNotEmpty
+func (c *Client) DeleteAll(p string) error {
+	children, _, err := c.client.Children(p)
+	if errors.Is(err, ErrNoNode) {
+		return nil
+	} else if err != nil {
+		return newError("delete", p, err)
+	}
+	for _, child := range children {
+		if err := c.DeleteAll(path.Join(p, child)); err != nil {
+			return err
+		}
+	}
+	if err := c.client.Delete(p, AnyVersion); err != nil && !errors.Is(err, ErrNoNode) {
+		return newError("delete", p, err)
+	}
+	return nil
+}
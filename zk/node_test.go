@@ -0,0 +1,163 @@
+package zk
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCreate(t *testing.T) {
+	c, _ := newFakeClient(t, newFakeServer())
+
+	if p, err := c.Create("/a", []byte("1"), Persistent); err != nil || p != "/a" {
+		t.Fatalf("create: expected /a, got %s, %v", p, err)
+	}
+	if _, err := c.Create("/a", nil, Persistent); !errors.Is(err, ErrNodeExists) {
+		t.Errorf("create existing: expected ErrNodeExists, got %v", err)
+	}
+	if _, err := c.Create("/x/y", nil, Persistent); !errors.Is(err, ErrNoNode) {
+		t.Errorf("create without parent: expected ErrNoNode, got %v", err)
+	}
+	var zerr *Error
+	if _, err := c.Create("/x/y", nil, Persistent); !errors.As(err, &zerr) || zerr.Op != "create" || zerr.Path != "/x/y" {
+		t.Errorf("create without parent: expected *Error, got %#v", err)
+	}
+
+	if p, err := c.CreateAll("/x/y/z", []byte("z"), Persistent); err != nil || p != "/x/y/z" {
+		t.Fatalf("create all: expected /x/y/z, got %s, %v", p, err)
+	}
+	if value, _, err := c.Get("/x/y/z"); err != nil || string(value) != "z" {
+		t.Errorf("get: expected z, got %s, %v", value, err)
+	}
+
+	var seqs []string
+	for i := 0; i < 2; i++ {
+		p, err := c.Create("/a/seq-", nil, PersistentSequential)
+		if err != nil || !strings.HasPrefix(p, "/a/seq-") || len(p) != len("/a/seq-")+10 {
+			t.Fatalf("create sequential: unexpected %s, %v", p, err)
+		}
+		seqs = append(seqs, p)
+	}
+	if !sort.StringsAreSorted(seqs) || seqs[0] == seqs[1] {
+		t.Errorf("create sequential: expected increasing, got %v", seqs)
+	}
+
+	p, err := c.Create("/a/e", nil, Ephemeral)
+	if err != nil {
+		t.Fatalf("create ephemeral: %v", err)
+	}
+	if _, stat, err := c.Exists(p); err != nil || stat == nil || stat.EphemeralOwner == 0 {
+		t.Errorf("exists ephemeral: unexpected %+v, %v", stat, err)
+	}
+	if _, err := c.Create(p+"/child", nil, Persistent); !errors.Is(err, ErrNoChildrenForEphemerals) {
+		t.Errorf("create child of ephemeral: expected ErrNoChildrenForEphemerals, got %v", err)
+	}
+}
+
+func TestEphemeralRemovedOnClose(t *testing.T) {
+	s := newFakeServer()
+	c1, _ := newFakeClient(t, s)
+	c2, _ := newFakeClient(t, s)
+
+	if _, err := c1.Create("/e", nil, EphemeralSequential); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if children, _, err := c2.Children("/"); err != nil || len(children) != 1 {
+		t.Fatalf("children: expected 1, got %v, %v", children, err)
+	}
+	c1.Close()
+	if children, _, err := c2.Children("/"); err != nil || len(children) != 0 {
+		t.Errorf("children after close: expected none, got %v, %v", children, err)
+	}
+}
+
+func TestSetAndDelete(t *testing.T) {
+	c, _ := newFakeClient(t, newFakeServer())
+	if _, err := c.Create("/a", []byte("1"), Persistent); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	_, stat, err := c.Get("/a")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if stat, err = c.Set("/a", []byte("2"), stat.Version); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	// compare and set with a stale version
+	if _, err := c.Set("/a", []byte("3"), stat.Version-1); !errors.Is(err, ErrBadVersion) {
+		t.Errorf("set stale version: expected ErrBadVersion, got %v", err)
+	}
+	if _, err := c.Set("/a", []byte("3"), AnyVersion); err != nil {
+		t.Errorf("set any version: %v", err)
+	}
+	if value, err := c.GetNodeValue("/a"); err != nil || value != "3" {
+		t.Errorf("get: expected 3, got %s, %v", value, err)
+	}
+	if _, err := c.Set("/missing", nil, AnyVersion); !errors.Is(err, ErrNoNode) {
+		t.Errorf("set missing: expected ErrNoNode, got %v", err)
+	}
+
+	if err := c.Delete("/a", 0); !errors.Is(err, ErrBadVersion) {
+		t.Errorf("delete stale version: expected ErrBadVersion, got %v", err)
+	}
+	if _, err := c.CreateAll("/a/b/c", nil, Persistent); err != nil {
+		t.Fatalf("create all: %v", err)
+	}
+	if err := c.Delete("/a", AnyVersion); !errors.Is(err, ErrNotEmpty) {
+		t.Errorf("delete not empty: expected ErrNotEmpty, got %v", err)
+	}
+	if err := c.DeleteAll("/a"); err != nil {
+		t.Fatalf("delete all: %v", err)
+	}
+	if ok, stat, err := c.Exists("/a"); err != nil || ok || stat != nil {
+		t.Errorf("exists deleted: unexpected %v, %+v, %v", ok, stat, err)
+	}
+	if err := c.DeleteAll("/a"); err != nil {
+		t.Errorf("delete all missing: %v", err)
+	}
+	if err := c.Delete("/a", AnyVersion); !errors.Is(err, ErrNoNode) {
+		t.Errorf("delete missing: expected ErrNoNode, got %v", err)
+	}
+}
+
+func TestACL(t *testing.T) {
+	s := newFakeServer()
+	owner, _ := newFakeClient(t, s, WithDigestAuth("admin", "secret"), WithACL(DigestACL(PermAll, "admin", "secret")))
+	other, _ := newFakeClient(t, s, WithDigestAuth("admin", "wrong"))
+	anonymous, _ := newFakeClient(t, s)
+
+	if _, err := owner.Create("/secret", []byte("s"), Persistent); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if value, _, err := owner.Get("/secret"); err != nil || string(value) != "s" {
+		t.Errorf("get by owner: expected s, got %s, %v", value, err)
+	}
+	for _, c := range []*Client{other, anonymous} {
+		if _, _, err := c.Get("/secret"); !errors.Is(err, ErrNoAuth) {
+			t.Errorf("get: expected ErrNoAuth, got %v", err)
+		}
+		if _, err := c.Set("/secret", nil, AnyVersion); !errors.Is(err, ErrNoAuth) {
+			t.Errorf("set: expected ErrNoAuth, got %v", err)
+		}
+		if _, err := c.Create("/secret/child", nil, Persistent); !errors.Is(err, ErrNoAuth) {
+			t.Errorf("create child: expected ErrNoAuth, got %v", err)
+		}
+	}
+	// exists does not check ACL
+	if ok, _, err := anonymous.Exists("/secret"); err != nil || !ok {
+		t.Errorf("exists: expected true, got %v, %v", ok, err)
+	}
+
+	creator, _ := newFakeClient(t, s, WithDigestAuth("bob", "pw"), WithACL(AuthACL(PermAll)))
+	if _, err := creator.Create("/bob", nil, Persistent); err != nil {
+		t.Fatalf("create with auth acl: %v", err)
+	}
+	if _, _, err := anonymous.Get("/bob"); !errors.Is(err, ErrNoAuth) {
+		t.Errorf("get: expected ErrNoAuth, got %v", err)
+	}
+	if _, _, err := anonymous.Children("/"); err != nil {
+		t.Errorf("children of root: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package zk
+
+import (
+	"time"
+
+	zk "github.com/samuel/go-zookeeper/zk"
+)
+
+// DefaultSessionTimeout of the zookeeper session
+const DefaultSessionTimeout = time.Second
+
+// ACL of a node
+type ACL = zk.ACL
+
+// Permissions of an ACL
+const (
+	PermRead   = zk.PermRead
+	PermWrite  = zk.PermWrite
+	PermCreate = zk.PermCreate
+	PermDelete = zk.PermDelete
+	PermAdmin  = zk.PermAdmin
+	PermAll    = zk.PermAll
+)
+
+// WorldACL anyone has perms
+func WorldACL(perms int32) []ACL {
+	return zk.WorldACL(perms)
+}
+
+// DigestACL user with password has perms, the session authenticates with WithDigestAuth
+func DigestACL(perms int32, user, password string) []ACL {
+	return zk.DigestACL(perms, user, password)
+}
+
+// AuthACL any user the session authenticated as has perms, it's the creator of the node
+func AuthACL(perms int32) []ACL {
+	return zk.AuthACL(perms)
+}
+
+type auth struct {
+	scheme string
+	auth   []byte
+}
+
+type options struct {
+	sessionTimeout time.Duration
+	auths          []auth
+	acl            []ACL
+}
+
+// Option of a Client
+type Option func(*options)
+
+// WithSessionTimeout ephemeral nodes are removed and watches lost if the client is
+// disconnected longer than timeout, default to DefaultSessionTimeout
+func WithSessionTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.sessionTimeout = timeout
+	}
+}
+
+// WithDigestAuth authenticates the session as user, it's sent again after reconnecting
+func WithDigestAuth(user, password string) Option {
+	return func(o *options) {
+		o.auths = append(o.auths, auth{scheme: "digest", auth: []byte(user + ":" + password)})
+	}
+}
+
+// WithACL of nodes created, default to WorldACL(PermAll)
+func WithACL(acl []ACL) Option {
+	return func(o *options) {
+		o.acl = acl
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		sessionTimeout: DefaultSessionTimeout,
+		acl:            WorldACL(PermAll),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
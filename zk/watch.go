@@ -0,0 +1,356 @@
+package zk
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/golang/glog"
+
+	zk "github.com/samuel/go-zookeeper/zk"
+)
+
+// retryInterval how long to wait before retrying after an error
+var retryInterval = time.Second
+
+// EventType of a watch event
+type EventType int
+
+const (
+	// EventCreated a node is created
+	EventCreated EventType = iota + 1
+	// EventChanged value of a node is changed
+	EventChanged
+	// EventDeleted a node is deleted
+	EventDeleted
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventCreated:
+		return "created"
+	case EventChanged:
+		return "changed"
+	case EventDeleted:
+		return "deleted"
+	}
+	return "unknown"
+}
+
+// Event of a node under the watched prefix
+type Event struct {
+	Type EventType
+	Path string
+	// Value of the node, the last known one if it's deleted
+	Value []byte
+}
+
+// kinds of zookeeper watches
+const (
+	dataWatch  = "data"
+	childWatch = "child"
+	existWatch = "exist"
+)
+
+// firedWatch is sent when a zookeeper watch fires
+type firedWatch struct {
+	// gen of the watcher when the watch is set, watches of older generations are ignored
+	gen   int
+	kind  string
+	event zk.Event
+}
+
+type watchedNode struct {
+	value    []byte
+	mzxid    int64
+	children map[string]bool
+}
+
+type watcher struct {
+	c      *Client
+	prefix string
+	events chan Event
+	fired  chan firedWatch
+
+	gen   int
+	nodes map[string]*watchedNode
+	// quiet no event is sent, during the first sync
+	quiet bool
+}
+
+// Watch prefix and all its descendants until ctx is done, changes after Watch returns
+// are sent to the returned channel, which is closed when ctx is done or the client is closed.
+// Watches are set again after they fire, and after the session expires or the connection
+// is lost, changes missed meanwhile are sent as events by comparing with the known tree.
+// Sending blocks until the event is received
+func (c *Client) Watch(ctx context.Context, prefix string) (<-chan Event, error) {
+	if prefix != "/" {
+		prefix = strings.TrimSuffix(prefix, "/")
+	}
+	w := &watcher{
+		c:      c,
+		prefix: prefix,
+		events: make(chan Event, 64),
+		fired:  make(chan firedWatch, 64),
+		nodes:  map[string]*watchedNode{},
+		quiet:  true,
+	}
+	if err := w.sync(ctx, prefix, true); err != nil {
+		return nil, err
+	}
+	w.quiet = false
+	go w.run(ctx)
+	return w.events, nil
+}
+
+func (w *watcher) run(ctx context.Context) {
+	defer close(w.events)
+
+	resync := false
+	for {
+		if resync {
+			w.gen++
+			if err := w.sync(ctx, w.prefix, true); err != nil {
+				if ctx.Err() != nil || closed(err) {
+					return
+				}
+				log.Warningf("zk watch %s: %v, retry in %v", w.prefix, err, retryInterval)
+				select {
+				case <-time.After(retryInterval):
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			resync = false
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case f := <-w.fired:
+			if f.gen != w.gen {
+				continue
+			}
+			if f.event.Type == zk.EventNotWatching {
+				if closed(f.event.Err) {
+					log.V(4).Infof("zk watch %s: client closed", w.prefix)
+					return
+				}
+				log.Infof("zk watch %s: watches lost: %v, sync again", w.prefix, f.event.Err)
+				resync = true
+				continue
+			}
+			if err := w.handle(ctx, f); err != nil {
+				if ctx.Err() != nil || closed(err) {
+					return
+				}
+				log.Warningf("zk watch %s: %v, sync again", w.prefix, err)
+				resync = true
+			}
+		}
+	}
+}
+
+// closed whether err is returned as the client is closed
+func closed(err error) bool {
+	return errors.Is(err, ErrClosing) || errors.Is(err, zk.ErrConnectionClosed)
+}
+
+func (w *watcher) handle(ctx context.Context, f firedWatch) error {
+	p := f.event.Path
+	log.V(6).Infof("zk watch %s: %s %s", w.prefix, f.event.Type, p)
+	switch f.event.Type {
+	case zk.EventNodeDataChanged:
+		return w.syncData(ctx, p)
+	case zk.EventNodeChildrenChanged:
+		return w.syncChildren(ctx, p)
+	case zk.EventNodeCreated:
+		return w.sync(ctx, p, false)
+	case zk.EventNodeDeleted:
+		w.remove(ctx, p)
+		if p == w.prefix && f.kind == dataWatch {
+			return w.watchPrefixCreated(ctx)
+		}
+	}
+	return nil
+}
+
+// arm forwards the event of watch ch to w.fired, until ctx is done
+func (w *watcher) arm(ctx context.Context, ch <-chan zk.Event, kind string) {
+	gen := w.gen
+	go func() {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			select {
+			case w.fired <- firedWatch{gen: gen, kind: kind, event: e}:
+			case <-ctx.Done():
+			}
+		case <-ctx.Done():
+		}
+	}()
+}
+
+func (w *watcher) send(ctx context.Context, t EventType, p string, value []byte) {
+	if w.quiet {
+		return
+	}
+	select {
+	case w.events <- Event{Type: t, Path: p, Value: value}:
+	case <-ctx.Done():
+	}
+}
+
+// sync node p, and its children not known yet, or all descendants if full. Watches are set
+// for them, and changes compared with the known ones are sent
+func (w *watcher) sync(ctx context.Context, p string, full bool) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	data, stat, dataCh, err := w.c.client.GetW(p)
+	if errors.Is(err, ErrNoNode) {
+		w.remove(ctx, p)
+		if p == w.prefix {
+			return w.watchPrefixCreated(ctx)
+		}
+		return nil
+	} else if err != nil {
+		return newError("watch", p, err)
+	}
+	w.arm(ctx, dataCh, dataWatch)
+
+	children, _, childCh, err := w.c.client.ChildrenW(p)
+	if errors.Is(err, ErrNoNode) {
+		// deleted meanwhile, the data watch fires
+		return nil
+	} else if err != nil {
+		return newError("watch", p, err)
+	}
+	w.arm(ctx, childCh, childWatch)
+
+	old := w.nodes[p]
+	n := &watchedNode{value: data, mzxid: stat.Mzxid, children: map[string]bool{}}
+	for _, child := range children {
+		n.children[child] = true
+	}
+	w.nodes[p] = n
+	if parent, ok := w.nodes[path.Dir(p)]; ok && p != w.prefix {
+		parent.children[path.Base(p)] = true
+	}
+	if old == nil {
+		w.send(ctx, EventCreated, p, data)
+	} else if old.mzxid != stat.Mzxid {
+		w.send(ctx, EventChanged, p, data)
+	}
+
+	if old != nil {
+		for child := range old.children {
+			if !n.children[child] {
+				w.remove(ctx, path.Join(p, child))
+			}
+		}
+	}
+	for _, child := range children {
+		if full || old == nil || !old.children[child] {
+			if err := w.sync(ctx, path.Join(p, child), full); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// syncData of p after its data watch fires
+func (w *watcher) syncData(ctx context.Context, p string) error {
+	data, stat, ch, err := w.c.client.GetW(p)
+	if errors.Is(err, ErrNoNode) {
+		w.remove(ctx, p)
+		if p == w.prefix {
+			return w.watchPrefixCreated(ctx)
+		}
+		return nil
+	} else if err != nil {
+		return newError("watch", p, err)
+	}
+	w.arm(ctx, ch, dataWatch)
+
+	n, ok := w.nodes[p]
+	if !ok {
+		// removed meanwhile, then created again, its parent syncs it
+		return nil
+	}
+	if n.mzxid != stat.Mzxid {
+		n.value, n.mzxid = data, stat.Mzxid
+		w.send(ctx, EventChanged, p, data)
+	}
+	return nil
+}
+
+// syncChildren of p after its child watch fires
+func (w *watcher) syncChildren(ctx context.Context, p string) error {
+	children, _, ch, err := w.c.client.ChildrenW(p)
+	if errors.Is(err, ErrNoNode) {
+		// the data watch fires
+		return nil
+	} else if err != nil {
+		return newError("watch", p, err)
+	}
+	w.arm(ctx, ch, childWatch)
+
+	n, ok := w.nodes[p]
+	if !ok {
+		return nil
+	}
+	current := map[string]bool{}
+	for _, child := range children {
+		current[child] = true
+	}
+	for child := range n.children {
+		if !current[child] {
+			w.remove(ctx, path.Join(p, child))
+		}
+	}
+	for _, child := range children {
+		if !n.children[child] {
+			if err := w.sync(ctx, path.Join(p, child), false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// watchPrefixCreated sets a watch of the prefix node, which does not exist
+func (w *watcher) watchPrefixCreated(ctx context.Context) error {
+	ok, _, ch, err := w.c.client.ExistsW(w.prefix)
+	if err != nil {
+		return newError("watch", w.prefix, err)
+	}
+	w.arm(ctx, ch, existWatch)
+	if ok {
+		// created meanwhile
+		return w.sync(ctx, w.prefix, false)
+	}
+	return nil
+}
+
+// remove p and its descendants from the known tree, deleted events are sent, the deepest first
+func (w *watcher) remove(ctx context.Context, p string) {
+	n, ok := w.nodes[p]
+	if !ok {
+		return
+	}
+	for child := range n.children {
+		w.remove(ctx, path.Join(p, child))
+	}
+	delete(w.nodes, p)
+	if parent, ok := w.nodes[path.Dir(p)]; ok && p != w.prefix {
+		delete(parent.children, path.Base(p))
+	}
+	w.send(ctx, EventDeleted, p, n.value)
+}
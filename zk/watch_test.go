@@ -0,0 +1,220 @@
+package zk
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"we.com/jiabiao/common/wait"
+)
+
+type eventKey struct {
+	Type EventType
+	Path string
+}
+
+// expectEvents receives len(want) events, in any order, values are not compared
+func expectEvents(t *testing.T, events <-chan Event, want ...eventKey) {
+	t.Helper()
+	pending := map[eventKey]bool{}
+	for _, e := range want {
+		pending[e] = true
+	}
+	for len(pending) > 0 {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatalf("events closed, expected %v", pending)
+			}
+			key := eventKey{e.Type, e.Path}
+			if !pending[key] {
+				t.Fatalf("unexpected event %s %s, expected %v", e.Type, e.Path, pending)
+			}
+			delete(pending, key)
+		case <-time.After(wait.ForeverTestTimeout):
+			t.Fatalf("timeout waiting for %v", pending)
+		}
+	}
+}
+
+func expectNoEvent(t *testing.T, events <-chan Event) {
+	t.Helper()
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event %s %s", e.Type, e.Path)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWatch(t *testing.T) {
+	s := newFakeServer()
+	c, _ := newFakeClient(t, s)
+	c.CreateAll("/app/conf/a", []byte("1"), Persistent)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := c.Watch(ctx, "/app/")
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	expectNoEvent(t, events)
+
+	c.Set("/app/conf/a", []byte("2"), AnyVersion)
+	expectEvents(t, events, eventKey{EventChanged, "/app/conf/a"})
+
+	c.CreateAll("/app/new/b", []byte("b"), Persistent)
+	expectEvents(t, events,
+		eventKey{EventCreated, "/app/new"},
+		eventKey{EventCreated, "/app/new/b"},
+	)
+
+	// changes of a node created after watch
+	c.Set("/app/new/b", []byte("b2"), AnyVersion)
+	expectEvents(t, events, eventKey{EventChanged, "/app/new/b"})
+
+	c.DeleteAll("/app/conf")
+	expectEvents(t, events,
+		eventKey{EventDeleted, "/app/conf/a"},
+		eventKey{EventDeleted, "/app/conf"},
+	)
+
+	// the prefix is deleted and created again
+	c.DeleteAll("/app")
+	expectEvents(t, events,
+		eventKey{EventDeleted, "/app/new/b"},
+		eventKey{EventDeleted, "/app/new"},
+		eventKey{EventDeleted, "/app"},
+	)
+	c.CreateAll("/app/x", nil, Persistent)
+	expectEvents(t, events,
+		eventKey{EventCreated, "/app"},
+		eventKey{EventCreated, "/app/x"},
+	)
+	c.Create("/other", nil, Persistent)
+	expectNoEvent(t, events)
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestWatchValue(t *testing.T) {
+	c, _ := newFakeClient(t, newFakeServer())
+	c.Create("/a", []byte("1"), Persistent)
+	events, err := c.Watch(context.Background(), "/a")
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+
+	c.Set("/a", []byte("2"), AnyVersion)
+	if e := <-events; e.Type != EventChanged || string(e.Value) != "2" {
+		t.Errorf("unexpected event %s %q", e.Type, e.Value)
+	}
+	c.Delete("/a", AnyVersion)
+	if e := <-events; e.Type != EventDeleted || string(e.Value) != "2" {
+		t.Errorf("unexpected event %s %q", e.Type, e.Value)
+	}
+
+	// channel is closed with the client
+	c.Close()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Errorf("expected events closed")
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("timeout waiting for events closed")
+	}
+}
+
+func TestWatchMissingPrefix(t *testing.T) {
+	c, _ := newFakeClient(t, newFakeServer())
+	events, err := c.Watch(context.Background(), "/missing")
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	c.Create("/missing", []byte("v"), Persistent)
+	expectEvents(t, events, eventKey{EventCreated, "/missing"})
+	defer c.Close()
+}
+
+func TestWatchSessionExpired(t *testing.T) {
+	defer func(d time.Duration) { retryInterval = d }(retryInterval)
+	retryInterval = 10 * time.Millisecond
+
+	s := newFakeServer()
+	c, conn := newFakeClient(t, s)
+	other, _ := newFakeClient(t, s)
+	c.CreateAll("/app/a", []byte("1"), Persistent)
+	c.Create("/app/b", nil, Persistent)
+	c.Create("/app/mine", nil, Ephemeral)
+
+	events, err := c.Watch(context.Background(), "/app")
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	defer c.Close()
+
+	// changes while the server is not reachable and the session expires are missed by watches
+	conn.setDown(true)
+	conn.expire()
+	other.Set("/app/a", []byte("2"), AnyVersion)
+	other.Delete("/app/b", AnyVersion)
+	other.Create("/app/c", nil, Persistent)
+	conn.setDown(false)
+
+	expectEvents(t, events,
+		eventKey{EventChanged, "/app/a"},
+		eventKey{EventDeleted, "/app/b"},
+		eventKey{EventCreated, "/app/c"},
+		eventKey{EventDeleted, "/app/mine"},
+	)
+	expectNoEvent(t, events)
+
+	// watches are set again
+	other.Set("/app/c", []byte("c"), AnyVersion)
+	other.Create("/app/c/d", nil, Persistent)
+	expectEvents(t, events,
+		eventKey{EventChanged, "/app/c"},
+		eventKey{EventCreated, "/app/c/d"},
+	)
+	expectNoEvent(t, events)
+}
+
+func TestWatchPrefix(t *testing.T) {
+	c, _ := newFakeClient(t, newFakeServer())
+	defer c.Close()
+	c.CreateAll("/app/conf/a", nil, Persistent)
+
+	if index, err := c.WatchPrefix("/app", []string{"/app/conf"}, 0, nil); err != nil || index != 1 {
+		t.Fatalf("first: expected 1, got %d, %v", index, err)
+	}
+
+	done := make(chan uint64)
+	go func() {
+		index, _ := c.WatchPrefix("/app", []string{"/app/conf"}, 1, nil)
+		done <- index
+	}()
+	// changes of other keys are ignored, set /app/conf/a until the watch is set
+	c.Create("/app/other", nil, Persistent)
+	for returned := false; !returned; {
+		c.Set("/app/conf/a", nil, AnyVersion)
+		select {
+		case index := <-done:
+			returned = true
+			if index != 1 {
+				t.Errorf("expected 1, got %d", index)
+			}
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	stopCh := make(chan bool)
+	go func() {
+		index, _ := c.WatchPrefix("/app", []string{"/app/conf"}, 5, stopCh)
+		done <- index
+	}()
+	close(stopCh)
+	if index := <-done; index != 5 {
+		t.Errorf("stopped: expected 5, got %d", index)
+	}
+}
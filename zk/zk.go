@@ -1,17 +1,40 @@
 package zk
 
 import (
-	"path/filepath"
+	"context"
 	"strings"
-	"time"
+	"sync"
+
 	log "github.com/golang/glog"
 
 	zk "github.com/samuel/go-zookeeper/zk"
 )
 
+// conn is the part of *zk.Conn used by Client, so tests can use a stand-in
+type conn interface {
+	AddAuth(scheme string, auth []byte) error
+	Children(path string) ([]string, *zk.Stat, error)
+	ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error)
+	Get(path string) ([]byte, *zk.Stat, error)
+	GetW(path string) ([]byte, *zk.Stat, <-chan zk.Event, error)
+	Set(path string, data []byte, version int32) (*zk.Stat, error)
+	Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error)
+	Delete(path string, version int32) error
+	Exists(path string) (bool, *zk.Stat, error)
+	ExistsW(path string) (bool, *zk.Stat, <-chan zk.Event, error)
+	Close()
+}
+
 // Client provides a wrapper around the zookeeper client
 type Client struct {
-	client *zk.Conn
+	client conn
+	// acl of nodes created
+	acl []zk.ACL
+
+	mu sync.Mutex
+	// lost is closed when the connection is lost or the session expires, then replaced,
+	// it's closed and kept after the client is closed
+	lost chan struct{}
 }
 
 func (c *Client) Close() {
@@ -20,12 +43,59 @@ func (c *Client) Close() {
 	}
 }
 
-func NewClient(machines []string) (*Client, error) {
-	c, _, err := zk.Connect(machines, time.Second) //*10)
+// NewClient connects to the zookeeper servers, opts like WithDigestAuth and WithACL
+// configure auth of the session and ACL of nodes created
+func NewClient(machines []string, opts ...Option) (*Client, error) {
+	o := newOptions(opts)
+	c, events, err := zk.Connect(machines, o.sessionTimeout)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return &Client{c}, nil
+	return newClient(c, events, o)
+}
+
+// newClient adds auth of o to c, events are the session events of c, which is
+// closed when c is closed
+func newClient(c conn, events <-chan zk.Event, o *options) (*Client, error) {
+	client := &Client{client: c, acl: o.acl, lost: make(chan struct{})}
+	go client.watchSession(events)
+	for _, a := range o.auths {
+		if err := c.AddAuth(a.scheme, a.auth); err != nil {
+			c.Close()
+			return nil, &Error{Op: "auth", Path: a.scheme, Err: err}
+		}
+	}
+	return client, nil
+}
+
+// watchSession closes c.lost when the connection is lost or the session expires. Ephemeral
+// nodes may be removed by the server before the session expired event is received, as it's
+// only received after reconnected, so holders of locks and leaders must stop when the
+// connection is lost
+func (c *Client) watchSession(events <-chan zk.Event) {
+	for e := range events {
+		if e.Type != zk.EventSession {
+			continue
+		}
+		log.V(4).Infof("zk session: %s", e.State)
+		if e.State == zk.StateDisconnected || e.State == zk.StateExpired {
+			c.mu.Lock()
+			close(c.lost)
+			c.lost = make(chan struct{})
+			c.mu.Unlock()
+		}
+	}
+	c.mu.Lock()
+	close(c.lost)
+	c.mu.Unlock()
+}
+
+// sessionLost returns a chan closed the next time the connection is lost or the session
+// expires, or when the client is closed
+func (c *Client) sessionLost() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lost
 }
 
 func nodeWalk(prefix string, c *Client, vars map[string]string) error {
@@ -82,6 +152,7 @@ func (c *Client) GetValues(keys []string) (map[string]string, error) {
 	return vars, nil
 }
 
+// SetNodeValue sets value of key regardless of its version, use Set to compare and set
 func (c *Client) SetNodeValue(key string, value string) error {
 	var err error
 	key = strings.Replace(key, "/*", "", -1)
@@ -149,89 +220,37 @@ func (c *Client) GetNodesValues(keys []string) (map[string]string, error) {
 	return vars, nil
 }
 
-type watchResponse struct {
-	waitIndex uint64
-	err       error
-}
-
-func (c *Client) watch(key string, respChan chan watchResponse, cancelRoutine chan bool) {
-	_, _, keyEventCh, err := c.client.GetW(key)
-	if err != nil {
-		respChan <- watchResponse{0, err}
-	}
-	_, _, childEventCh, err := c.client.ChildrenW(key)
-	if err != nil {
-		respChan <- watchResponse{0, err}
-	}
-
-	for {
-		select {
-		case e := <-keyEventCh:
-			if e.Type == zk.EventNodeDataChanged {
-				respChan <- watchResponse{1, e.Err}
-			}
-		case e := <-childEventCh:
-			if e.Type == zk.EventNodeChildrenChanged {
-				respChan <- watchResponse{1, e.Err}
-			}
-		case <-cancelRoutine:
-			log.V(10).Info("Stop watching: " + key)
-			// There is no way to stop GetW/ChildrenW so just quit
-			return
-		}
-	}
-}
-
+// WatchPrefix blocks until a node under prefix, with one of keys as prefix, is created,
+// changed or deleted, or stopChan is closed, for a confd style loop.
+//
+// Deprecated: use Watch, changes between calls of WatchPrefix are missed
 func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
 	// return something > 0 to trigger a key retrieval from the store
 	if waitIndex == 0 {
 		return 1, nil
 	}
 
-	// List the childrens first
-	entries, err := c.GetValues([]string{prefix})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := c.Watch(ctx, prefix)
 	if err != nil {
 		return 0, err
 	}
 
-	respChan := make(chan watchResponse)
-	cancelRoutine := make(chan bool)
-	defer close(cancelRoutine)
-
-	//watch all subfolders for changes
-	watchMap := make(map[string]string)
-	for k, _ := range entries {
-		for _, v := range keys {
-			if strings.HasPrefix(k, v) {
-				for dir := filepath.Dir(k); dir != "/"; dir = filepath.Dir(dir) {
-					if _, ok := watchMap[dir]; !ok {
-						watchMap[dir] = ""
-						log.V(10).Info("Watching: " + dir)
-						go c.watch(dir, respChan, cancelRoutine)
-					}
-				}
-				break
-			}
-		}
-	}
-
-	//watch all keys in prefix for changes
-	for k, _ := range entries {
-		for _, v := range keys {
-			if strings.HasPrefix(k, v) {
-				log.V(10).Info("Watching: " + k)
-				go c.watch(k, respChan, cancelRoutine)
-				break
-			}
-		}
-	}
-
 	for {
 		select {
 		case <-stopChan:
 			return waitIndex, nil
-		case r := <-respChan:
-			return r.waitIndex, r.err
+		case e, ok := <-events:
+			if !ok {
+				return 0, ErrClosing
+			}
+			for _, k := range keys {
+				if strings.HasPrefix(e.Path, k) {
+					log.V(10).Infof("%s %s", e.Type, e.Path)
+					return 1, nil
+				}
+			}
 		}
 	}
 }